@@ -2,13 +2,23 @@ package main
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"os"
 	"os/signal"
 	"syscall"
 
 	"github.com/go-gst/go-glib/glib"
+	"github.com/kaedwen/webrtc/pkg/cli"
 	"github.com/kaedwen/webrtc/pkg/common"
+	"github.com/kaedwen/webrtc/pkg/events"
+	"github.com/kaedwen/webrtc/pkg/mqtt"
+	"github.com/kaedwen/webrtc/pkg/recorder"
+	"github.com/kaedwen/webrtc/pkg/reload"
 	"github.com/kaedwen/webrtc/pkg/ring"
 	"github.com/kaedwen/webrtc/pkg/server"
+	"github.com/kaedwen/webrtc/pkg/turn"
+	"github.com/kaedwen/webrtc/pkg/webhook"
 	"github.com/kaedwen/webrtc/pkg/webrtc"
 	"go.uber.org/zap"
 )
@@ -22,27 +32,121 @@ func main() {
 	cfg := common.Config{}
 	cfg.MustParse()
 
+	// these do without a valid config, devices helps to make it one
+	switch {
+	case cfg.CheckConfig != nil:
+		os.Exit(checkConfig(cfg.Validate()))
+	case cfg.GenConfig != nil:
+		exit(cli.GenConfig(os.Stdout, &cfg, cfg.GenConfig))
+	case cfg.Devices != nil:
+		exit(cli.Devices(os.Stdout))
+	}
+
+	if err := cfg.Validate(); err != nil {
+		checkConfig(err)
+		os.Exit(1)
+	}
+
 	lg, err := common.NewLogger(&cfg.Logging)
 	if err != nil {
 		panic(err)
 	}
 
+	switch {
+	case cfg.Probe != nil:
+		exit(cli.Probe(ctx, lg, os.Stdout, &cfg, cfg.Probe))
+	case cfg.RingTest != nil:
+		exit(cli.RingTest(ctx, lg, os.Stdout, &cfg, cfg.RingTest))
+	}
+
+	// serve is the default command
+
 	if cfg.Logging.Development {
 		lg.Info("configuration", zap.Any("", cfg))
 	}
 
-	http := server.NewHttpServer(lg.With(zap.String("context", "server")), &cfg)
+	http, err := server.NewHttpServer(lg.With(zap.String("context", "server")), &cfg)
+	if err != nil {
+		panic(err)
+	}
+
+	// glib sources of the elements are dispatched on the default context
+	go mainLoop.Run()
+
+	http.Health.AddLivenessCheck("gst-main-loop", func() error {
+		if !mainLoop.IsRunning() {
+			return errors.New("main loop is not running")
+		}
+		return nil
+	})
+
+	bus := events.NewBus(lg.With(zap.String("context", "events")))
+
+	rl := reload.NewReloader(lg.With(zap.String("context", "reload")), &cfg, os.Args[1:])
+
+	// the logger keeps the level of the start, it is changed in place
+	rl.Apply("log-level", func(c *common.Config) any { return c.Logging.Level.Level() }, func(c *common.Config) error {
+		cfg.Logging.Level.SetLevel(c.Logging.Level.Level())
+		return nil
+	})
+	rl.Static("logging", func(c *common.Config) any { return c.Logging.Development })
+	rl.Static("http", func(c *common.Config) any { return []any{c.Http, c.Auth, c.State, c.Acme, c.Push} })
+	rl.Static("turn", func(c *common.Config) any { return c.Turn })
+	rl.Static("recording", func(c *common.Config) any { return c.Recording })
+
+	var rec *recorder.Recorder
+	if cfg.Recording.Enabled {
+		rec, err = recorder.NewRecorder(lg.With(zap.String("context", "recorder")), &cfg.Recording, &cfg.VideoSrc, &cfg.AudioSrc)
+		if err != nil {
+			panic(err)
+		}
+		rec.Watch(ctx, bus)
+	}
+
+	err = rl.Restart(ctx, "ring", func(c *common.Config) any { return []any{c.Ring, c.Notifications()} }, func(ctx context.Context, c *common.Config) (<-chan struct{}, error) {
+		return ring.NewRingHandler(ctx, lg.With(zap.String("context", "ring")), &c.Ring, c.Notifications(), http.Health, bus, http.Snapshot)
+	})
+	if err != nil {
+		panic(err)
+	}
+
+	var relay *turn.TurnServer
+	if cfg.Turn.Enabled {
+		relay, err = turn.NewTurnServer(ctx, lg.With(zap.String("context", "turn")), &cfg.Turn)
+		if err != nil {
+			panic(err)
+		}
+	}
 
-	err = ring.NewRingHandler(ctx, lg.With(zap.String("context", "ring")), &cfg.Ring)
+	wh, err := webrtc.NewWebrtcHandler(ctx, lg.With(zap.String("context", "webrtc")), cfg.Stream(), http.Hndl, http.Publish, http.Snapshot, http.Stream, http.HLS, bus, http.Health, relay, rec)
 	if err != nil {
 		panic(err)
 	}
 
-	err = webrtc.NewWebrtcHandler(ctx, lg.With(zap.String("context", "webrtc")), cfg.Stream(), http.Hndl)
+	rl.Apply("stream", func(c *common.Config) any { return c.Stream() }, func(c *common.Config) error {
+		return wh.Reload(c.Stream())
+	})
+
+	err = rl.Restart(ctx, "webhooks", func(c *common.Config) any { return c.Webhook() }, func(ctx context.Context, c *common.Config) (<-chan struct{}, error) {
+		return webhook.NewWebhookHandler(ctx, lg.With(zap.String("context", "webhook")), c.Webhook(), bus)
+	})
 	if err != nil {
 		panic(err)
 	}
 
+	if http.Push != nil {
+		http.Push.Watch(ctx, bus)
+	}
+
+	err = rl.Restart(ctx, "mqtt", func(c *common.Config) any { return c.Mqtt }, func(ctx context.Context, c *common.Config) (<-chan struct{}, error) {
+		return mqtt.NewMqttHandler(ctx, lg.With(zap.String("context", "mqtt")), &c.Mqtt, bus, http.Health, http.Snapshot, http.Stream)
+	})
+	if err != nil {
+		panic(err)
+	}
+
+	go rl.Run(ctx)
+
 	err = http.ListenAndServe(ctx)
 	if err != nil {
 		panic(err)
@@ -62,3 +166,33 @@ func main() {
 		lg.Error("timeout", zap.Error(err))
 	}
 }
+
+// checkConfig reports the problems of the config, the result is the exit code
+func checkConfig(err error) int {
+	if err == nil {
+		fmt.Println("configuration is valid")
+		return 0
+	}
+
+	errs := []error{err}
+	if j, ok := err.(interface{ Unwrap() []error }); ok {
+		errs = j.Unwrap()
+	}
+
+	fmt.Fprintf(os.Stderr, "configuration has %d problem(s):\n", len(errs))
+	for _, e := range errs {
+		fmt.Fprintf(os.Stderr, "  %s\n", e)
+	}
+
+	return 1
+}
+
+// exit ends a command other than serve, its error is reported
+func exit(err error) {
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	os.Exit(0)
+}
@@ -0,0 +1,446 @@
+package acme
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/kaedwen/webrtc/pkg/common"
+	"go.uber.org/zap"
+	xacme "golang.org/x/crypto/acme"
+)
+
+const (
+	ChallengeHTTP01 = "http-01"
+	ChallengeDNS01  = "dns-01"
+
+	// path the http-01 tokens are served at
+	ChallengePath = "/.well-known/acme-challenge/"
+
+	accountKeyFile = "account.key"
+	certFile       = "cert.pem"
+	keyFile        = "cert.key"
+
+	// a certificate is renewed this long before it expires
+	renewBefore = 30 * 24 * time.Hour
+	// interval the certificate is checked in, also the delay after a failed renewal
+	checkInterval = 12 * time.Hour
+	// time an issuance may take
+	issueTimeout = 10 * time.Minute
+	// time the challenge listener gets to shut down
+	shutdownTimeout = 5 * time.Second
+)
+
+// delay after a failed issuance while there is no certificate yet, doubled after every failure
+// up to the check interval
+var retryInterval = time.Minute
+
+// Manager obtains and renews the certificate of the configured domains
+type Manager struct {
+	lg     *zap.Logger
+	cfg    *common.ConfigACME
+	dir    string
+	client *xacme.Client
+	dns    *rfc2136
+	mu     *sync.RWMutex
+	cert   *tls.Certificate
+	tokens map[string]string // http-01 key authorizations by token
+}
+
+func NewManager(lg *zap.Logger, cfg *common.ConfigACME, dir string) (*Manager, error) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, err
+	}
+
+	if cfg.Challenge != ChallengeHTTP01 && cfg.Challenge != ChallengeDNS01 {
+		return nil, fmt.Errorf("unsupported acme challenge - %s", cfg.Challenge)
+	}
+
+	key, err := loadAccountKey(filepath.Join(dir, accountKeyFile))
+	if err != nil {
+		return nil, fmt.Errorf("failed to load acme account key - %s", err)
+	}
+
+	client := &http.Client{Timeout: time.Minute}
+	if cfg.DirectoryCA != nil {
+		pool, err := loadPool(cfg.DirectoryCA.String())
+		if err != nil {
+			return nil, fmt.Errorf("failed to load acme directory ca - %s", err)
+		}
+		client.Transport = &http.Transport{TLSClientConfig: &tls.Config{RootCAs: pool}}
+	}
+
+	m := Manager{
+		lg:     lg,
+		cfg:    cfg,
+		dir:    dir,
+		client: &xacme.Client{Key: key, DirectoryURL: cfg.Directory, HTTPClient: client},
+		mu:     &sync.RWMutex{},
+		tokens: make(map[string]string),
+	}
+
+	if cfg.Challenge == ChallengeDNS01 {
+		m.dns, err = newRFC2136(cfg)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// a certificate of an earlier run is used until it is renewed
+	cert, err := tls.LoadX509KeyPair(filepath.Join(dir, certFile), filepath.Join(dir, keyFile))
+	if err == nil {
+		m.cert = &cert
+	} else if !errors.Is(err, os.ErrNotExist) {
+		lg.Warn("ignoring stored certificate", zap.Error(err))
+	}
+
+	return &m, nil
+}
+
+// Certificate returns the current certificate, nil before the first issuance
+func (m *Manager) Certificate() *tls.Certificate {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	return m.cert
+}
+
+// ChallengeResponse returns the key authorization of a pending http-01 token
+func (m *Manager) ChallengeResponse(token string) (string, bool) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	resp, ok := m.tokens[token]
+	return resp, ok
+}
+
+// ServeHTTP answers the http-01 challenges below the challenge path
+func (m *Manager) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	token, ok := strings.CutPrefix(r.URL.Path, ChallengePath)
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+
+	resp, ok := m.ChallengeResponse(token)
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/plain")
+	_, _ = w.Write([]byte(resp))
+}
+
+// Run renews the certificate when needed until the context is done
+func (m *Manager) Run(ctx context.Context) {
+	if m.cfg.Challenge == ChallengeHTTP01 && m.cfg.HttpAddr != "" {
+		m.serveChallenges(ctx)
+	}
+
+	retry := retryInterval
+	for {
+		wait := checkInterval
+
+		if m.needsRenewal() {
+			ictx, cancel := context.WithTimeout(ctx, issueTimeout)
+			err := m.issue(ictx)
+			cancel()
+
+			if err != nil {
+				m.lg.Error("failed to obtain certificate", zap.Strings("domains", m.cfg.Domains), zap.Error(err))
+
+				// the local ca serves meanwhile, the first certificate is tried again sooner
+				if m.Certificate() == nil {
+					wait, retry = retry, min(2*retry, checkInterval)
+					m.lg.Info("retrying certificate", zap.Duration("in", wait))
+				}
+			}
+		}
+
+		select {
+		case <-time.After(wait):
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// serveChallenges listens on plain http, the ca does not validate http-01 over tls.
+// It is bound before the first issuance, the server runs until the context is done
+func (m *Manager) serveChallenges(ctx context.Context) {
+	ln, err := net.Listen("tcp", m.cfg.HttpAddr)
+	if err != nil {
+		m.lg.Error("failed to serve http-01 challenges, issuance fails unless they reach the server otherwise", zap.Error(err))
+		return
+	}
+
+	srv := &http.Server{Handler: m, ReadHeaderTimeout: 10 * time.Second}
+
+	go func() {
+		<-ctx.Done()
+
+		sctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		defer cancel()
+		_ = srv.Shutdown(sctx)
+	}()
+
+	go func() {
+		if err := srv.Serve(ln); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			m.lg.Error("failed to serve http-01 challenges", zap.Error(err))
+		}
+	}()
+
+	m.lg.Info("serving http-01 challenges", zap.String("addr", ln.Addr().String()))
+}
+
+func (m *Manager) needsRenewal() bool {
+	cert := m.Certificate()
+	if cert == nil {
+		return true
+	}
+
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		return true
+	}
+
+	for _, d := range m.cfg.Domains {
+		if leaf.VerifyHostname(d) != nil {
+			return true
+		}
+	}
+
+	return time.Until(leaf.NotAfter) < renewBefore
+}
+
+func (m *Manager) issue(ctx context.Context) error {
+	m.lg.Info("obtaining certificate", zap.Strings("domains", m.cfg.Domains), zap.String("challenge", m.cfg.Challenge))
+
+	if err := m.register(ctx); err != nil {
+		return err
+	}
+
+	order, err := m.client.AuthorizeOrder(ctx, xacme.DomainIDs(m.cfg.Domains...))
+	if err != nil {
+		return err
+	}
+
+	for _, u := range order.AuthzURLs {
+		if err := m.authorize(ctx, u); err != nil {
+			return err
+		}
+	}
+
+	order, err = m.client.WaitOrder(ctx, order.URI)
+	if err != nil {
+		return err
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return err
+	}
+
+	csr, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{DNSNames: m.cfg.Domains}, key)
+	if err != nil {
+		return err
+	}
+
+	chain, _, err := m.client.CreateOrderCert(ctx, order.FinalizeURL, csr, true)
+	if err != nil {
+		return err
+	}
+
+	cert, err := m.store(chain, key)
+	if err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	m.cert = cert
+	m.mu.Unlock()
+
+	m.lg.Info("certificate obtained", zap.Strings("domains", m.cfg.Domains))
+
+	return nil
+}
+
+func (m *Manager) register(ctx context.Context) error {
+	acct := xacme.Account{}
+	if m.cfg.Email != "" {
+		acct.Contact = []string{"mailto:" + m.cfg.Email}
+	}
+
+	_, err := m.client.Register(ctx, &acct, xacme.AcceptTOS)
+	if errors.Is(err, xacme.ErrAccountAlreadyExists) {
+		return nil
+	}
+
+	return err
+}
+
+// authorize solves the configured challenge of a single authorization
+func (m *Manager) authorize(ctx context.Context, url string) error {
+	authz, err := m.client.GetAuthorization(ctx, url)
+	if err != nil {
+		return err
+	}
+
+	if authz.Status == xacme.StatusValid {
+		return nil
+	}
+
+	var chal *xacme.Challenge
+	for _, c := range authz.Challenges {
+		if c.Type == m.cfg.Challenge {
+			chal = c
+			break
+		}
+	}
+	if chal == nil {
+		return fmt.Errorf("no %s challenge offered for %s", m.cfg.Challenge, authz.Identifier.Value)
+	}
+
+	switch m.cfg.Challenge {
+	case ChallengeHTTP01:
+		resp, err := m.client.HTTP01ChallengeResponse(chal.Token)
+		if err != nil {
+			return err
+		}
+
+		m.mu.Lock()
+		m.tokens[chal.Token] = resp
+		m.mu.Unlock()
+
+		defer func() {
+			m.mu.Lock()
+			delete(m.tokens, chal.Token)
+			m.mu.Unlock()
+		}()
+	case ChallengeDNS01:
+		value, err := m.client.DNS01ChallengeRecord(chal.Token)
+		if err != nil {
+			return err
+		}
+
+		name := "_acme-challenge." + authz.Identifier.Value
+		if err := m.dns.Present(name, value); err != nil {
+			return fmt.Errorf("failed to add dns record - %s", err)
+		}
+
+		defer func() {
+			if err := m.dns.CleanUp(name, value); err != nil {
+				m.lg.Warn("failed to remove dns record", zap.String("name", name), zap.Error(err))
+			}
+		}()
+
+		// secondaries need a moment to pick up the record
+		select {
+		case <-time.After(m.cfg.DnsPropagation):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+
+	if _, err := m.client.Accept(ctx, chal); err != nil {
+		return err
+	}
+
+	_, err = m.client.WaitAuthorization(ctx, authz.URI)
+	return err
+}
+
+// store persists the chain and the key, the certificate survives a restart this way
+func (m *Manager) store(chain [][]byte, key *ecdsa.PrivateKey) (*tls.Certificate, error) {
+	var certPEM []byte
+	for _, der := range chain {
+		certPEM = append(certPEM, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})...)
+	}
+
+	keyDer, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return nil, err
+	}
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDer})
+
+	if err := os.WriteFile(filepath.Join(m.dir, keyFile), keyPEM, 0o600); err != nil {
+		return nil, err
+	}
+	if err := os.WriteFile(filepath.Join(m.dir, certFile), certPEM, 0o644); err != nil {
+		return nil, err
+	}
+
+	cert, err := tls.X509KeyPair(certPEM, keyPEM)
+	if err != nil {
+		return nil, err
+	}
+
+	return &cert, nil
+}
+
+// loadAccountKey reads the account key, it is created on the first start
+func loadAccountKey(path string) (crypto.Signer, error) {
+	data, err := os.ReadFile(path)
+	if err == nil {
+		block, _ := pem.Decode(data)
+		if block == nil {
+			return nil, fmt.Errorf("no key in %s", path)
+		}
+
+		key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+
+		signer, ok := key.(crypto.Signer)
+		if !ok {
+			return nil, fmt.Errorf("unsupported key in %s", path)
+		}
+
+		return signer, nil
+	}
+	if !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return key, os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), 0o600)
+}
+
+func loadPool(path string) (*x509.CertPool, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(data) {
+		return nil, fmt.Errorf("no certificates in %s", path)
+	}
+
+	return pool, nil
+}
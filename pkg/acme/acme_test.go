@@ -0,0 +1,516 @@
+package acme
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/kaedwen/webrtc/pkg/common"
+	"go.uber.org/zap"
+)
+
+type testAuthz struct {
+	domain string
+	token  string
+	status string
+}
+
+// testCA is a pebble like stand-in of an acme ca, it validates http-01 for real against
+// challengeAddr and signs the certificates with its own key. Signatures of the requests
+// are not checked, only the payloads are read
+type testCA struct {
+	*httptest.Server
+	t             *testing.T
+	challengeAddr string // where port 80 of the domains is
+
+	mu         *sync.Mutex
+	key        *ecdsa.PrivateKey
+	cert       *x509.Certificate
+	nonce      int
+	thumbprint string
+	authz      []*testAuthz
+	order      string
+	chain      []byte
+	validated  []string // domains of the solved challenges
+}
+
+func newTestCA(t *testing.T, challengeAddr string) *testCA {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test ca"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(24 * time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ca := &testCA{t: t, challengeAddr: challengeAddr, mu: &sync.Mutex{}, key: key, cert: cert}
+	ca.Server = httptest.NewTLSServer(http.HandlerFunc(ca.handle))
+	t.Cleanup(ca.Close)
+
+	return ca
+}
+
+// directoryCA writes the certificate of the tls listener for the directory-ca option
+func (ca *testCA) directoryCA() *common.Path {
+	path := filepath.Join(ca.t.TempDir(), "directory-ca.pem")
+	data := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ca.Certificate().Raw})
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		ca.t.Fatal(err)
+	}
+
+	p := common.Path{}
+	_ = p.UnmarshalText([]byte(path))
+	return &p
+}
+
+func (ca *testCA) handle(w http.ResponseWriter, r *http.Request) {
+	ca.mu.Lock()
+	defer ca.mu.Unlock()
+
+	ca.nonce++
+	w.Header().Set("Replay-Nonce", fmt.Sprint("nonce-", ca.nonce))
+
+	if r.Method == http.MethodHead {
+		return
+	}
+
+	if r.URL.Path == "/dir" {
+		ca.reply(w, http.StatusOK, "", map[string]string{
+			"newNonce":   ca.URL + "/nonce",
+			"newAccount": ca.URL + "/account",
+			"newOrder":   ca.URL + "/order",
+		})
+		return
+	}
+
+	protected, payload, err := readJWS(r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	switch path := r.URL.Path; {
+	case path == "/account":
+		ca.thumbprint = thumbprint(protected.JWK)
+		ca.reply(w, http.StatusCreated, ca.URL+"/account/1", map[string]string{"status": "valid"})
+	case path == "/order":
+		var req struct {
+			Identifiers []struct{ Value string }
+		}
+		if err := json.Unmarshal(payload, &req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		ca.authz = nil
+		for i, id := range req.Identifiers {
+			ca.authz = append(ca.authz, &testAuthz{domain: id.Value, token: fmt.Sprint("token-", i), status: "pending"})
+		}
+		ca.order = "pending"
+		ca.reply(w, http.StatusCreated, ca.URL+"/order/1", ca.orderBody())
+	case path == "/order/1":
+		ca.reply(w, http.StatusOK, ca.URL+"/order/1", ca.orderBody())
+	case strings.HasPrefix(path, "/authz/"):
+		a := ca.find(w, path, "/authz/")
+		if a == nil {
+			return
+		}
+		ca.reply(w, http.StatusOK, "", map[string]any{
+			"status":     a.status,
+			"identifier": map[string]string{"type": "dns", "value": a.domain},
+			"challenges": []map[string]string{
+				{"type": "dns-01", "url": ca.URL + "/unused", "token": a.token, "status": "pending"},
+				{"type": "http-01", "url": ca.URL + "/chal/" + strings.TrimPrefix(path, "/authz/"), "token": a.token, "status": a.status},
+			},
+		})
+	case strings.HasPrefix(path, "/chal/"):
+		a := ca.find(w, path, "/chal/")
+		if a == nil {
+			return
+		}
+		a.status = ca.validate(a)
+		ca.updateOrder()
+		ca.reply(w, http.StatusOK, "", map[string]string{"type": "http-01", "token": a.token, "status": a.status})
+	case path == "/finalize":
+		if err := ca.finalize(payload); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		ca.reply(w, http.StatusOK, ca.URL+"/order/1", ca.orderBody())
+	case path == "/cert":
+		w.Header().Set("Content-Type", "application/pem-certificate-chain")
+		_, _ = w.Write(ca.chain)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func (ca *testCA) reply(w http.ResponseWriter, status int, location string, body any) {
+	if location != "" {
+		w.Header().Set("Location", location)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(body)
+}
+
+func (ca *testCA) find(w http.ResponseWriter, path string, prefix string) *testAuthz {
+	var i int
+	if _, err := fmt.Sscan(strings.TrimPrefix(path, prefix), &i); err != nil || i >= len(ca.authz) {
+		http.NotFound(w, nil)
+		return nil
+	}
+
+	return ca.authz[i]
+}
+
+func (ca *testCA) orderBody() map[string]any {
+	var ids []map[string]string
+	var urls []string
+	for i, a := range ca.authz {
+		ids = append(ids, map[string]string{"type": "dns", "value": a.domain})
+		urls = append(urls, fmt.Sprint(ca.URL, "/authz/", i))
+	}
+
+	body := map[string]any{
+		"status":         ca.order,
+		"identifiers":    ids,
+		"authorizations": urls,
+		"finalize":       ca.URL + "/finalize",
+	}
+	if ca.order == "valid" {
+		body["certificate"] = ca.URL + "/cert"
+	}
+
+	return body
+}
+
+// validate fetches the key authorization over plain http like a real ca does
+func (ca *testCA) validate(a *testAuthz) string {
+	req, err := http.NewRequest(http.MethodGet, "http://"+ca.challengeAddr+ChallengePath+a.token, nil)
+	if err != nil {
+		return "invalid"
+	}
+	req.Host = a.domain
+
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return "invalid"
+	}
+	defer res.Body.Close()
+
+	body, _ := io.ReadAll(res.Body)
+	if res.StatusCode != http.StatusOK || string(body) != a.token+"."+ca.thumbprint {
+		return "invalid"
+	}
+
+	ca.validated = append(ca.validated, a.domain)
+	return "valid"
+}
+
+func (ca *testCA) updateOrder() {
+	for _, a := range ca.authz {
+		if a.status == "invalid" {
+			ca.order = "invalid"
+			return
+		}
+		if a.status != "valid" {
+			return
+		}
+	}
+	ca.order = "ready"
+}
+
+func (ca *testCA) finalize(payload []byte) error {
+	if ca.order != "ready" {
+		return fmt.Errorf("order is %s", ca.order)
+	}
+
+	var req struct{ CSR string }
+	if err := json.Unmarshal(payload, &req); err != nil {
+		return err
+	}
+	der, err := base64.RawURLEncoding.DecodeString(req.CSR)
+	if err != nil {
+		return err
+	}
+	csr, err := x509.ParseCertificateRequest(der)
+	if err != nil {
+		return err
+	}
+
+	leaf := &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		DNSNames:     csr.DNSNames,
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(90 * 24 * time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	leafDer, err := x509.CreateCertificate(rand.Reader, leaf, ca.cert, csr.PublicKey, ca.key)
+	if err != nil {
+		return err
+	}
+
+	ca.chain = append(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: leafDer}), pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ca.cert.Raw})...)
+	ca.order = "valid"
+
+	return nil
+}
+
+type jwsProtected struct {
+	JWK map[string]string `json:"jwk"`
+}
+
+func readJWS(r io.Reader) (*jwsProtected, []byte, error) {
+	var jws struct {
+		Protected string `json:"protected"`
+		Payload   string `json:"payload"`
+	}
+	if err := json.NewDecoder(r).Decode(&jws); err != nil {
+		return nil, nil, err
+	}
+
+	header, err := base64.RawURLEncoding.DecodeString(jws.Protected)
+	if err != nil {
+		return nil, nil, err
+	}
+	var protected jwsProtected
+	if err := json.Unmarshal(header, &protected); err != nil {
+		return nil, nil, err
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(jws.Payload)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return &protected, payload, nil
+}
+
+// thumbprint of an ec key as of rfc 7638
+func thumbprint(jwk map[string]string) string {
+	sum := sha256.Sum256([]byte(fmt.Sprintf(`{"crv":"%s","kty":"%s","x":"%s","y":"%s"}`, jwk["crv"], jwk["kty"], jwk["x"], jwk["y"])))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// freeAddr finds a port for the challenge listener, the test ca validates against it instead of 80
+func freeAddr(t *testing.T) string {
+	t.Helper()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	return ln.Addr().String()
+}
+
+func TestIssueHTTP01(t *testing.T) {
+	addr := freeAddr(t)
+	ca := newTestCA(t, addr)
+
+	cfg := &common.ConfigACME{
+		Domains:     []string{"door.example", "www.door.example"},
+		Directory:   ca.URL + "/dir",
+		DirectoryCA: ca.directoryCA(),
+		Challenge:   ChallengeHTTP01,
+		HttpAddr:    addr,
+	}
+	dir := t.TempDir()
+
+	m, err := NewManager(zap.NewNop(), cfg, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.Certificate() != nil {
+		t.Fatal("expected no certificate before the first issuance")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go m.Run(ctx)
+
+	deadline := time.Now().Add(10 * time.Second)
+	for m.Certificate() == nil {
+		if time.Now().After(deadline) {
+			t.Fatal("no certificate obtained")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	ca.mu.Lock()
+	validated := append([]string(nil), ca.validated...)
+	ca.mu.Unlock()
+	if len(validated) != 2 {
+		t.Fatalf("expected both domains to be validated over plain http, got %v", validated)
+	}
+
+	leaf, err := x509.ParseCertificate(m.Certificate().Certificate[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, d := range cfg.Domains {
+		if err := leaf.VerifyHostname(d); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if leaf.Issuer.CommonName != "test ca" || len(m.Certificate().Certificate) != 2 {
+		t.Fatalf("expected the chain of the test ca, got %s with %d certificates", leaf.Issuer, len(m.Certificate().Certificate))
+	}
+	if m.needsRenewal() {
+		t.Fatal("expected the fresh certificate to need no renewal")
+	}
+
+	// the tokens are only served while the authorization is pending
+	if _, ok := m.ChallengeResponse("token-0"); ok {
+		t.Fatal("expected the token to be removed after the authorization")
+	}
+
+	// a restart picks up the stored certificate and account
+	again, err := NewManager(zap.NewNop(), cfg, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again.Certificate() == nil || again.needsRenewal() {
+		t.Fatal("expected the stored certificate to be used after a restart")
+	}
+}
+
+func TestIssueHTTP01Unreachable(t *testing.T) {
+	// nothing listens where the ca validates, the issuance fails
+	ca := newTestCA(t, freeAddr(t))
+
+	cfg := &common.ConfigACME{
+		Domains:     []string{"door.example"},
+		Directory:   ca.URL + "/dir",
+		DirectoryCA: ca.directoryCA(),
+		Challenge:   ChallengeHTTP01,
+	}
+
+	m, err := NewManager(zap.NewNop(), cfg, t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	if err := m.issue(ctx); err == nil {
+		t.Fatal("expected the issuance to fail")
+	}
+	if m.Certificate() != nil {
+		t.Fatal("expected no certificate")
+	}
+}
+
+func TestServeHTTP(t *testing.T) {
+	m := &Manager{mu: &sync.RWMutex{}, tokens: map[string]string{"abc": "abc.thumb"}}
+
+	for path, want := range map[string]int{
+		ChallengePath + "abc":     http.StatusOK,
+		ChallengePath + "unknown": http.StatusNotFound,
+		"/abc":                    http.StatusNotFound,
+	} {
+		rec := httptest.NewRecorder()
+		m.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+
+		if rec.Code != want {
+			t.Errorf("%s: expected %d, got %d", path, want, rec.Code)
+		}
+		if want == http.StatusOK && rec.Body.String() != "abc.thumb" {
+			t.Errorf("%s: unexpected body %q", path, rec.Body.String())
+		}
+	}
+}
+
+func TestRetryWithoutCertificate(t *testing.T) {
+	prev := retryInterval
+	retryInterval = 20 * time.Millisecond
+	t.Cleanup(func() { retryInterval = prev })
+
+	// the challenges are not reachable until the first attempt failed
+	addr := freeAddr(t)
+	ca := newTestCA(t, addr)
+
+	cfg := &common.ConfigACME{
+		Domains:     []string{"door.example"},
+		Directory:   ca.URL + "/dir",
+		DirectoryCA: ca.directoryCA(),
+		Challenge:   ChallengeHTTP01,
+	}
+
+	m, err := NewManager(zap.NewNop(), cfg, t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go m.Run(ctx)
+
+	deadline := time.Now().Add(10 * time.Second)
+	for {
+		ca.mu.Lock()
+		failed := ca.order == "invalid"
+		ca.mu.Unlock()
+		if failed {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("expected the first issuance to fail")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := &http.Server{Handler: m}
+	go func() { _ = srv.Serve(ln) }()
+	t.Cleanup(func() { _ = srv.Close() })
+
+	for m.Certificate() == nil {
+		if time.Now().After(deadline) {
+			t.Fatal("expected the issuance to be retried")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
@@ -0,0 +1,80 @@
+package acme
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/kaedwen/webrtc/pkg/common"
+	"github.com/miekg/dns"
+)
+
+// ttl of the challenge records, resolvers should not hold on to them
+const challengeTTL = 60
+
+// rfc2136 adds and removes the dns-01 records by dynamic updates to the primary of the zone
+type rfc2136 struct {
+	server    string
+	zone      string
+	key       string // fqdn of the tsig key
+	secret    string
+	algorithm string
+}
+
+func newRFC2136(cfg *common.ConfigACME) (*rfc2136, error) {
+	if cfg.DnsServer == "" || cfg.DnsZone == "" {
+		return nil, errors.New("dns-01 needs the dns server and zone")
+	}
+
+	u := rfc2136{
+		server:    cfg.DnsServer,
+		zone:      dns.Fqdn(cfg.DnsZone),
+		algorithm: dns.Fqdn(cfg.DnsTsigAlgorithm),
+	}
+
+	if cfg.DnsTsigKey != nil && cfg.DnsTsigSecret != nil {
+		u.key, u.secret = dns.Fqdn(*cfg.DnsTsigKey), *cfg.DnsTsigSecret
+	}
+
+	return &u, nil
+}
+
+func (u *rfc2136) Present(name string, value string) error {
+	return u.update(name, value, true)
+}
+
+func (u *rfc2136) CleanUp(name string, value string) error {
+	return u.update(name, value, false)
+}
+
+func (u *rfc2136) update(name string, value string, add bool) error {
+	rr := &dns.TXT{
+		Hdr: dns.RR_Header{Name: dns.Fqdn(name), Rrtype: dns.TypeTXT, Class: dns.ClassINET, Ttl: challengeTTL},
+		Txt: []string{value},
+	}
+
+	m := new(dns.Msg)
+	m.SetUpdate(u.zone)
+	if add {
+		m.Insert([]dns.RR{rr})
+	} else {
+		m.Remove([]dns.RR{rr})
+	}
+
+	c := dns.Client{Net: "tcp"}
+	if u.key != "" {
+		m.SetTsig(u.key, u.algorithm, 300, time.Now().Unix())
+		c.TsigSecret = map[string]string{u.key: u.secret}
+	}
+
+	res, _, err := c.Exchange(m, u.server)
+	if err != nil {
+		return err
+	}
+
+	if res.Rcode != dns.RcodeSuccess {
+		return fmt.Errorf("update refused - %s", dns.RcodeToString[res.Rcode])
+	}
+
+	return nil
+}
@@ -0,0 +1,164 @@
+package acme
+
+import (
+	"encoding/base64"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/kaedwen/webrtc/pkg/common"
+	"github.com/miekg/dns"
+)
+
+const (
+	testTsigKey = "acme-key."
+	testZone    = "door.example."
+)
+
+var testTsigSecret = base64.StdEncoding.EncodeToString([]byte("0123456789abcdef0123456789abcdef"))
+
+type update struct {
+	zone   string
+	record dns.RR
+}
+
+// testPrimary accepts dynamic updates signed with the test key and records them
+type testPrimary struct {
+	addr    string
+	mu      *sync.Mutex
+	updates []update
+}
+
+func newTestPrimary(t *testing.T) *testPrimary {
+	t.Helper()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p := &testPrimary{addr: ln.Addr().String(), mu: &sync.Mutex{}}
+
+	started := make(chan struct{})
+	srv := &dns.Server{
+		Listener:          ln,
+		Net:               "tcp",
+		TsigSecret:        map[string]string{testTsigKey: testTsigSecret},
+		Handler:           dns.HandlerFunc(p.handle),
+		NotifyStartedFunc: func() { close(started) },
+		// the default refuses dynamic updates
+		MsgAcceptFunc: func(dns.Header) dns.MsgAcceptAction { return dns.MsgAccept },
+	}
+	go func() { _ = srv.ActivateAndServe() }()
+	t.Cleanup(func() { _ = srv.Shutdown() })
+	<-started
+
+	return p
+}
+
+func (p *testPrimary) handle(w dns.ResponseWriter, r *dns.Msg) {
+	m := new(dns.Msg)
+	m.SetReply(r)
+
+	tsig := r.IsTsig()
+	switch {
+	case tsig == nil:
+		m.Rcode = dns.RcodeRefused
+	case w.TsigStatus() != nil:
+		m.Rcode = dns.RcodeNotAuth
+	case r.Opcode != dns.OpcodeUpdate || len(r.Question) != 1:
+		m.Rcode = dns.RcodeFormatError
+	default:
+		p.mu.Lock()
+		for _, rr := range r.Ns {
+			p.updates = append(p.updates, update{zone: r.Question[0].Name, record: rr})
+		}
+		p.mu.Unlock()
+	}
+
+	if tsig != nil && w.TsigStatus() == nil {
+		m.SetTsig(tsig.Hdr.Name, tsig.Algorithm, 300, time.Now().Unix())
+	}
+
+	_ = w.WriteMsg(m)
+}
+
+func (p *testPrimary) recorded() []update {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return append([]update(nil), p.updates...)
+}
+
+func newTestRFC2136(t *testing.T, addr string, key *string, secret *string) *rfc2136 {
+	t.Helper()
+
+	u, err := newRFC2136(&common.ConfigACME{
+		DnsServer:        addr,
+		DnsZone:          "door.example",
+		DnsTsigKey:       key,
+		DnsTsigSecret:    secret,
+		DnsTsigAlgorithm: dns.HmacSHA256,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return u
+}
+
+func TestRFC2136Update(t *testing.T) {
+	p := newTestPrimary(t)
+	u := newTestRFC2136(t, p.addr, common.Ptr("acme-key"), common.Ptr(testTsigSecret))
+
+	name := "_acme-challenge.door.example"
+	if err := u.Present(name, "token-value"); err != nil {
+		t.Fatal(err)
+	}
+	if err := u.CleanUp(name, "token-value"); err != nil {
+		t.Fatal(err)
+	}
+
+	updates := p.recorded()
+	if len(updates) != 2 {
+		t.Fatalf("expected an insert and a removal, got %v", updates)
+	}
+
+	// a removal of a single record is sent with class none, see rfc 2136 2.5.4
+	for i, class := range []uint16{dns.ClassINET, dns.ClassNONE} {
+		txt, ok := updates[i].record.(*dns.TXT)
+		if !ok {
+			t.Fatalf("expected a txt record, got %s", updates[i].record)
+		}
+		if updates[i].zone != testZone || txt.Hdr.Name != name+"." || txt.Hdr.Class != class || len(txt.Txt) != 1 || txt.Txt[0] != "token-value" {
+			t.Fatalf("unexpected update %d: %s in %s", i, txt, updates[i].zone)
+		}
+	}
+	if ttl := updates[0].record.Header().Ttl; ttl != challengeTTL {
+		t.Fatalf("expected the ttl %d, got %d", challengeTTL, ttl)
+	}
+}
+
+func TestRFC2136Tsig(t *testing.T) {
+	p := newTestPrimary(t)
+	other := base64.StdEncoding.EncodeToString([]byte("another secret of the same size!"))
+
+	for _, tc := range []struct {
+		name   string
+		key    *string
+		secret *string
+	}{
+		{"unsigned", nil, nil},
+		{"wrong secret", common.Ptr("acme-key"), &other},
+		{"unknown key", common.Ptr("other-key"), common.Ptr(testTsigSecret)},
+	} {
+		u := newTestRFC2136(t, p.addr, tc.key, tc.secret)
+		if err := u.Present("_acme-challenge.door.example", "token-value"); err == nil {
+			t.Errorf("%s: expected the update to be refused", tc.name)
+		}
+	}
+
+	if updates := p.recorded(); len(updates) != 0 {
+		t.Fatalf("expected no update to be taken, got %v", updates)
+	}
+}
@@ -0,0 +1,80 @@
+package cli
+
+import (
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+
+	"github.com/kaedwen/webrtc/pkg/ring"
+	"github.com/kaedwen/webrtc/pkg/streamer"
+)
+
+// key codes of an input device shown before they are cut off, keyboards have hundreds
+const maxCodes = 16
+
+// Devices lists the devices the config can refer to
+func Devices(w io.Writer) error {
+	for _, s := range []struct {
+		title string
+		class string
+	}{
+		{"video sources (video-src.device)", streamer.ClassVideoSource},
+		{"audio sources (audio-src.device)", streamer.ClassAudioSource},
+		{"audio sinks (audio-sink.device)", streamer.ClassAudioSink},
+	} {
+		devices, err := streamer.Devices(s.class)
+		if err != nil {
+			return err
+		}
+
+		fmt.Fprintln(w, s.title)
+		if len(devices) == 0 {
+			fmt.Fprintln(w, "  none")
+		}
+		for _, d := range devices {
+			fmt.Fprintf(w, "  %s\n", deviceName(d.Name, d.Path))
+			for _, c := range d.Caps {
+				fmt.Fprintf(w, "    %s\n", c)
+			}
+		}
+		fmt.Fprintln(w)
+	}
+
+	inputs, err := ring.InputDevices()
+	if err != nil {
+		return fmt.Errorf("failed to list input devices - %s", err)
+	}
+
+	fmt.Fprintln(w, "input devices (ring.input)")
+	if len(inputs) == 0 {
+		fmt.Fprintln(w, "  none")
+	}
+	for _, d := range inputs {
+		fmt.Fprintf(w, "  %s\n", deviceName(d.Name, d.Path))
+
+		types := make([]string, 0, len(d.Events))
+		for t := range d.Events {
+			types = append(types, t)
+		}
+		slices.Sort(types)
+
+		for _, t := range types {
+			codes := d.Events[t]
+			if len(codes) > maxCodes {
+				codes = append(codes[:maxCodes:maxCodes], fmt.Sprintf("and %d more", len(d.Events[t])-maxCodes))
+			}
+			fmt.Fprintf(w, "    %s: %s\n", t, strings.Join(codes, " "))
+		}
+	}
+
+	return nil
+}
+
+func deviceName(name string, path string) string {
+	if path == "" {
+		return name
+	}
+
+	return fmt.Sprintf("%s (%s)", name, path)
+}
@@ -0,0 +1,35 @@
+package cli
+
+import (
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/kaedwen/webrtc/pkg/common"
+)
+
+// GenConfig writes the config with the current values as annotated file, an existing file is kept
+func GenConfig(w io.Writer, cfg *common.Config, cmd *common.GenConfigCommand) error {
+	data, err := cfg.Annotated()
+	if err != nil {
+		return err
+	}
+
+	if cmd.Output == "-" {
+		_, err = w.Write(data)
+		return err
+	}
+
+	// the values may hold secrets
+	f, err := os.OpenFile(cmd.Output, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o600)
+	if err != nil {
+		return fmt.Errorf("failed to create config - %s", err)
+	}
+	defer f.Close()
+
+	if _, err := f.Write(data); err != nil {
+		return fmt.Errorf("failed to write config - %s", err)
+	}
+
+	return f.Close()
+}
@@ -0,0 +1,119 @@
+package cli
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/go-gst/go-gst/gst"
+	"github.com/kaedwen/webrtc/pkg/common"
+	"github.com/kaedwen/webrtc/pkg/streamer"
+	"github.com/pion/webrtc/v3/pkg/media"
+	"go.uber.org/zap"
+)
+
+// Probe runs the configured source pipelines one after the other and reports the negotiated caps and rates
+func Probe(ctx context.Context, lg *zap.Logger, w io.Writer, cfg *common.Config, cmd *common.ProbeCommand) error {
+	vs := &cfg.VideoSrc
+
+	src, err := streamer.NewVideoSourceElement(vs)
+	if err != nil {
+		return err
+	}
+
+	src.Bitrate = vs.Bitrate
+	src.Queue = vs.Queue
+	src.Codec = vs.Codec
+
+	if src.Warning != nil {
+		fmt.Fprintf(w, "warning: %s\n", src.Warning)
+	}
+	if src.Format != nil {
+		fmt.Fprintf(w, "video format: %s\n", src.Format)
+	}
+
+	var pipeline *gst.Pipeline
+	var ch <-chan media.Sample
+	if vs.Passthrough {
+		pipeline, ch, err = streamer.CreateVideoPipelineRTP(lg, *src)
+	} else {
+		pipeline, ch, err = streamer.CreateVideoPipelineSink(lg, *src)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to create video pipeline - %s", err)
+	}
+
+	// passthrough delivers packets, several make up a frame
+	unit := "frames"
+	if vs.Passthrough {
+		unit = "packets"
+	}
+
+	if err := probe(ctx, lg.With(zap.String("sub-context", "video")), w, "video", unit, pipeline, ch, cmd.Duration); err != nil {
+		return err
+	}
+
+	pipeline, ch, err = streamer.CreateAudioPipelineSink(*streamer.NewAudioSourceElement(&cfg.AudioSrc), lg)
+	if err != nil {
+		return fmt.Errorf("failed to create audio pipeline - %s", err)
+	}
+
+	return probe(ctx, lg.With(zap.String("sub-context", "audio")), w, "audio", "samples", pipeline, ch, cmd.Duration)
+}
+
+func probe(ctx context.Context, lg *zap.Logger, w io.Writer, name string, unit string, pipeline *gst.Pipeline, ch <-chan media.Sample, d time.Duration) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	errs := make(chan error, 1)
+	streamer.LoopBus(ctx, lg, pipeline, func(err error) {
+		select {
+		case errs <- err:
+		default:
+		}
+	})
+
+	if err := pipeline.SetState(gst.StatePlaying); err != nil {
+		return fmt.Errorf("failed to start %s pipeline - %s", name, err)
+	}
+	defer pipeline.SetState(gst.StateNull)
+
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	start := time.Now()
+	count := 0
+
+loop:
+	for {
+		select {
+		case <-ch:
+			count++
+		case err := <-errs:
+			return fmt.Errorf("%s pipeline failed - %s", name, err)
+		case <-timer.C:
+			break loop
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+
+	elapsed := time.Since(start)
+
+	caps, err := streamer.NegotiatedCaps(pipeline)
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(w, "%s: %d %s in %s (%.1f/s)\n", name, count, unit, elapsed.Round(time.Millisecond), float64(count)/elapsed.Seconds())
+	for _, c := range caps {
+		fmt.Fprintf(w, "  %s\n", c)
+	}
+
+	if count == 0 {
+		return fmt.Errorf("%s pipeline delivered nothing", name)
+	}
+
+	return nil
+}
@@ -0,0 +1,129 @@
+package cli
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"path/filepath"
+	"time"
+
+	"github.com/kaedwen/webrtc/pkg/common"
+	"github.com/kaedwen/webrtc/pkg/events"
+	"github.com/kaedwen/webrtc/pkg/hls"
+	"github.com/kaedwen/webrtc/pkg/mqtt"
+	"github.com/kaedwen/webrtc/pkg/push"
+	"github.com/kaedwen/webrtc/pkg/ring"
+	"github.com/kaedwen/webrtc/pkg/server"
+	"github.com/kaedwen/webrtc/pkg/webhook"
+	"github.com/kaedwen/webrtc/pkg/webrtc"
+	"go.uber.org/zap"
+)
+
+const (
+	// time the players get to answer the discovery before the ring
+	discoveryWait = 3 * time.Second
+
+	// time the handlers get to finish, e.g. publishing offline to the broker
+	stopTimeout = 5 * time.Second
+)
+
+// RingTest starts everything reacting to a ring but the input device and rings once
+func RingTest(ctx context.Context, lg *zap.Logger, w io.Writer, cfg *common.Config, cmd *common.RingTestCommand) error {
+	ctx, cancel := context.WithCancel(ctx)
+
+	// the handlers are stopped and waited for on every return
+	var stopped []<-chan struct{}
+	defer func() {
+		cancel()
+		awaitStopped(lg, stopped)
+	}()
+
+	health := common.NewHealth()
+	bus := events.NewBus(lg.With(zap.String("context", "events")))
+	snapshots := make(chan *server.SnapshotRequest, 1)
+	streams := make(chan *server.StreamRequest, 1)
+
+	// the notifiers attach snapshots, the stream is there when the camera is
+	_, err := webrtc.NewWebrtcHandler(ctx, lg.With(zap.String("context", "webrtc")), cfg.Stream(), nil, nil, snapshots, streams, hls.NewPlaylist(), bus, health, nil, nil)
+	if err != nil {
+		lg.Warn("ringing without snapshots", zap.Error(err))
+		go refuseSnapshots(ctx, snapshots, err)
+	}
+
+	rcfg := cfg.Ring
+	rcfg.Device = nil
+	done, err := ring.NewRingHandler(ctx, lg.With(zap.String("context", "ring")), &rcfg, cfg.Notifications(), health, bus, snapshots)
+	if err != nil {
+		return fmt.Errorf("failed to start ring - %s", err)
+	}
+	stopped = append(stopped, done)
+
+	done, err = webhook.NewWebhookHandler(ctx, lg.With(zap.String("context", "webhook")), cfg.Webhook(), bus)
+	if err != nil {
+		return fmt.Errorf("failed to start webhooks - %s", err)
+	}
+	stopped = append(stopped, done)
+
+	done, err = mqtt.NewMqttHandler(ctx, lg.With(zap.String("context", "mqtt")), &cfg.Mqtt, bus, health, snapshots, streams)
+	if err != nil {
+		return fmt.Errorf("failed to start mqtt - %s", err)
+	}
+	stopped = append(stopped, done)
+
+	if cfg.Push.Enabled {
+		ps, err := push.NewService(lg.With(zap.String("context", "push")), &cfg.Push, filepath.Join(cfg.State.Dir.String(), "push"))
+		if err != nil {
+			return fmt.Errorf("failed to start push - %s", err)
+		}
+		ps.Watch(ctx, bus)
+	}
+
+	if err := sleep(ctx, discoveryWait); err != nil {
+		return err
+	}
+
+	fmt.Fprintln(w, "ring")
+	bus.Publish(events.NewRing())
+
+	if err := sleep(ctx, cmd.Wait); err != nil {
+		return err
+	}
+
+	fmt.Fprintln(w, "done, see the log for the reactions")
+
+	return nil
+}
+
+// awaitStopped waits for the handlers to be done, at most for the stop timeout
+func awaitStopped(lg *zap.Logger, stopped []<-chan struct{}) {
+	timeout := time.After(stopTimeout)
+	for _, done := range stopped {
+		select {
+		case <-done:
+		case <-timeout:
+			lg.Warn("handlers did not stop in time", zap.Duration("timeout", stopTimeout))
+			return
+		}
+	}
+}
+
+func refuseSnapshots(ctx context.Context, snapshots <-chan *server.SnapshotRequest, err error) {
+	for {
+		select {
+		case req := <-snapshots:
+			req.Reply <- server.SnapshotReply{Err: fmt.Errorf("no stream - %s", err)}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func sleep(ctx context.Context, d time.Duration) error {
+	select {
+	case <-time.After(d):
+		return nil
+	case <-ctx.Done():
+		return errors.New("interrupted")
+	}
+}
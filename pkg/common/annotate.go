@@ -0,0 +1,151 @@
+package common
+
+import (
+	"bytes"
+	"fmt"
+	"reflect"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Annotated renders the config as a config file, every option is commented with
+// its flag, environment variable and default
+func (c *Config) Annotated() ([]byte, error) {
+	node, err := annotate(reflect.ValueOf(c).Elem())
+	if err != nil {
+		return nil, err
+	}
+
+	var b bytes.Buffer
+	enc := yaml.NewEncoder(&b)
+	enc.SetIndent(2)
+
+	if err := enc.Encode(node); err != nil {
+		return nil, err
+	}
+
+	if err := enc.Close(); err != nil {
+		return nil, err
+	}
+
+	return b.Bytes(), nil
+}
+
+// annotate builds the mapping of a struct the way yaml decodes it
+func annotate(v reflect.Value) (*yaml.Node, error) {
+	m := &yaml.Node{Kind: yaml.MappingNode}
+	t := v.Type()
+
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if !f.IsExported() {
+			continue
+		}
+
+		// the file itself and the commands are no options of the file
+		tag := f.Tag.Get("yaml")
+		if tag == "-" || (f.Anonymous && tag == "") {
+			continue
+		}
+
+		name, opts, _ := strings.Cut(tag, ",")
+		if opts == "inline" {
+			sub, err := annotate(v.Field(i))
+			if err != nil {
+				return nil, err
+			}
+			m.Content = append(m.Content, sub.Content...)
+			continue
+		}
+
+		if name == "" {
+			name = strings.ToLower(f.Name)
+		}
+
+		value, err := annotateValue(v.Field(i))
+		if err != nil {
+			return nil, fmt.Errorf("failed to render %s - %s", name, err)
+		}
+
+		key := &yaml.Node{Kind: yaml.ScalarNode, Value: name, HeadComment: optionComment(f)}
+		m.Content = append(m.Content, key, value)
+	}
+
+	return m, nil
+}
+
+func annotateValue(v reflect.Value) (*yaml.Node, error) {
+	switch {
+	case v.Kind() == reflect.Pointer && v.IsNil():
+		// left empty, yaml takes it as unset
+		return &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!null"}, nil
+	case v.Kind() == reflect.Struct && !reflect.PointerTo(v.Type()).Implements(textUnmarshaler):
+		return annotate(v)
+	case v.Kind() == reflect.Slice && v.Type().Elem().Kind() == reflect.Struct && v.Len() == 0:
+		// the keys of an entry are shown as they are not obvious from an empty list
+		n := &yaml.Node{Kind: yaml.SequenceNode, Style: yaml.FlowStyle}
+		n.LineComment = "entries take " + strings.Join(yamlKeys(v.Type().Elem()), ", ")
+		return n, nil
+	}
+
+	n := &yaml.Node{}
+	if err := n.Encode(v.Interface()); err != nil {
+		return nil, err
+	}
+
+	return n, nil
+}
+
+// optionComment tells how the option is given besides the file
+func optionComment(f reflect.StructField) string {
+	var parts []string
+
+	arg := f.Tag.Get("arg")
+	if arg != "-" {
+		for _, a := range strings.Split(arg, ",") {
+			switch {
+			case strings.HasPrefix(a, "--"):
+				parts = append(parts, a)
+			case strings.HasPrefix(a, "env:"):
+				parts = append(parts, "$"+strings.TrimPrefix(a, "env:"))
+			}
+		}
+	}
+
+	if d, ok := f.Tag.Lookup("default"); ok {
+		parts = append(parts, fmt.Sprintf("default %q", d))
+	}
+
+	if h := f.Tag.Get("help"); h != "" {
+		parts = append(parts, h)
+	}
+
+	return strings.Join(parts, ", ")
+}
+
+// yamlKeys returns the keys of a struct in the order of its fields
+func yamlKeys(t reflect.Type) []string {
+	var keys []string
+
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		tag := f.Tag.Get("yaml")
+		if !f.IsExported() || tag == "-" {
+			continue
+		}
+
+		name, opts, _ := strings.Cut(tag, ",")
+		if opts == "inline" {
+			keys = append(keys, yamlKeys(f.Type)...)
+			continue
+		}
+
+		if name == "" {
+			name = strings.ToLower(f.Name)
+		}
+		keys = append(keys, name)
+	}
+
+	return keys
+}
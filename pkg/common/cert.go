@@ -1,45 +1,255 @@
 package common
 
 import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
 	"crypto/rand"
-	"crypto/rsa"
 	"crypto/tls"
 	"crypto/x509"
 	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
 	"fmt"
 	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
 	"time"
 )
 
-func GenerateSelfSigned() (*tls.Certificate, error) {
-	priv, err := rsa.GenerateKey(rand.Reader, 2048)
+const (
+	caCertFile   = "ca.crt"
+	caKeyFile    = "ca.key"
+	leafCertFile = "server.crt"
+	leafKeyFile  = "server.key"
+
+	caValidity = 10 * 365 * 24 * time.Hour
+	// browsers refuse longer lived server certificates
+	leafValidity = 397 * 24 * time.Hour
+	// a leaf is reissued this long before it expires
+	renewBefore = 30 * 24 * time.Hour
+)
+
+// LocalCA issues the server certificates, devices trust it once instead of every certificate
+type LocalCA struct {
+	dir  string
+	cert *x509.Certificate
+	key  crypto.Signer
+}
+
+// LoadLocalCA reads the ca from dir, it is created on the first start
+func LoadLocalCA(dir string) (*LocalCA, error) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, err
+	}
+
+	ca := LocalCA{dir: dir}
+
+	cert, key, err := loadPair(filepath.Join(dir, caCertFile), filepath.Join(dir, caKeyFile))
+	if err == nil {
+		ca.cert, ca.key = cert, key
+		return &ca, nil
+	}
+	if !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+
+	host, _ := os.Hostname()
+
+	template, err := newTemplate(pkix.Name{Organization: []string{"Doorbell"}, CommonName: "Doorbell Local CA " + host}, caValidity)
+	if err != nil {
+		return nil, err
+	}
+	template.IsCA = true
+	template.MaxPathLenZero = true
+	template.KeyUsage = x509.KeyUsageCertSign | x509.KeyUsageCRLSign | x509.KeyUsageDigitalSignature
+
+	ca.cert, ca.key, err = createPair(template, nil, nil, filepath.Join(dir, caCertFile), filepath.Join(dir, caKeyFile))
 	if err != nil {
 		return nil, err
 	}
 
+	return &ca, nil
+}
+
+// Certificate is the ca in DER form for the download
+func (ca *LocalCA) Certificate() []byte {
+	return ca.cert.Raw
+}
+
+// Leaf returns the persisted server certificate, it is (re)issued when missing,
+// about to expire or not covering all hosts
+func (ca *LocalCA) Leaf(hosts []string) (*tls.Certificate, error) {
+	certPath, keyPath := filepath.Join(ca.dir, leafCertFile), filepath.Join(ca.dir, leafKeyFile)
+
+	// a broken pair, e.g. of a crash while writing it, is issued anew as well
+	cert, key, err := loadPair(certPath, keyPath)
+
+	if err != nil || !leafValid(cert, hosts) || cert.CheckSignatureFrom(ca.cert) != nil {
+		template, err := newTemplate(pkix.Name{Organization: []string{"Doorbell"}, CommonName: hosts[0]}, leafValidity)
+		if err != nil {
+			return nil, err
+		}
+		template.KeyUsage = x509.KeyUsageDigitalSignature
+		template.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth}
+
+		for _, h := range hosts {
+			if ip := net.ParseIP(h); ip != nil {
+				template.IPAddresses = append(template.IPAddresses, ip)
+			} else {
+				template.DNSNames = append(template.DNSNames, h)
+			}
+		}
+
+		cert, key, err = createPair(template, ca.cert, ca.key, certPath, keyPath)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return &tls.Certificate{
+		Certificate: [][]byte{cert.Raw, ca.cert.Raw},
+		PrivateKey:  key,
+		Leaf:        cert,
+	}, nil
+}
+
+// leafValid tells whether the certificate is far from its expiry and covers all hosts
+func leafValid(cert *x509.Certificate, hosts []string) bool {
+	if time.Until(cert.NotAfter) < renewBefore {
+		return false
+	}
+
+	for _, h := range hosts {
+		if ip := net.ParseIP(h); ip != nil {
+			if !slices.ContainsFunc(cert.IPAddresses, ip.Equal) {
+				return false
+			}
+		} else if !slices.Contains(cert.DNSNames, h) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// LocalHosts returns the names and addresses the server is reachable at, the first one is the hostname
+func LocalHosts(extra []string) []string {
+	hosts := []string{}
+
+	if host, err := os.Hostname(); err == nil {
+		hosts = append(hosts, host)
+		if !strings.Contains(host, ".") {
+			hosts = append(hosts, host+".local")
+		}
+	}
+	hosts = append(hosts, "localhost")
+
+	if addrs, err := net.InterfaceAddrs(); err == nil {
+		for _, a := range addrs {
+			if n, ok := a.(*net.IPNet); ok && !n.IP.IsLinkLocalUnicast() {
+				hosts = append(hosts, n.IP.String())
+			}
+		}
+	}
+
+	for _, h := range extra {
+		if !slices.Contains(hosts, h) {
+			hosts = append(hosts, h)
+		}
+	}
+
+	return hosts
+}
+
+func newTemplate(subject pkix.Name, validity time.Duration) (*x509.Certificate, error) {
 	serialNumberLimit := new(big.Int).Lsh(big.NewInt(1), 128)
 	serialNumber, err := rand.Int(rand.Reader, serialNumberLimit)
 	if err != nil {
 		return nil, fmt.Errorf("failed to generate serial number: %v", err)
 	}
 
-	template := x509.Certificate{
+	return &x509.Certificate{
 		SerialNumber:          serialNumber,
-		Subject:               pkix.Name{Organization: []string{"PHI"}},
-		NotBefore:             time.Now(),
-		NotAfter:              time.Now().Add(time.Hour * 24 * 180),
-		KeyUsage:              x509.KeyUsageKeyEncipherment | x509.KeyUsageDigitalSignature,
-		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		Subject:               subject,
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(validity),
 		BasicConstraintsValid: true,
+	}, nil
+}
+
+// createPair signs the template with the parent, it is self signed without one, and persists both
+func createPair(template *x509.Certificate, parent *x509.Certificate, parentKey crypto.Signer, certPath string, keyPath string) (*x509.Certificate, crypto.Signer, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if parent == nil {
+		parent, parentKey = template, key
 	}
 
-	cert, err := x509.CreateCertificate(rand.Reader, &template, &template, &priv.PublicKey, priv)
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, key.Public(), parentKey)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 
-	return &tls.Certificate{
-		PrivateKey:  priv,
-		Certificate: [][]byte{cert},
-	}, nil
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	keyDer, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if err := writeFile(keyPath, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDer}), 0o600); err != nil {
+		return nil, nil, err
+	}
+
+	if err := writeFile(certPath, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o644); err != nil {
+		return nil, nil, err
+	}
+
+	return cert, key, nil
+}
+
+// writeFile replaces the file at once, a crash never leaves a partly written one
+func writeFile(path string, data []byte, perm os.FileMode) error {
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, perm); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp, path)
+}
+
+func loadPair(certPath string, keyPath string) (*x509.Certificate, crypto.Signer, error) {
+	pair, err := tls.LoadX509KeyPair(certPath, keyPath)
+	if err != nil {
+		// missing files are told apart from broken ones
+		if _, serr := os.Stat(certPath); errors.Is(serr, os.ErrNotExist) {
+			return nil, nil, serr
+		}
+		if _, serr := os.Stat(keyPath); errors.Is(serr, os.ErrNotExist) {
+			return nil, nil, serr
+		}
+		return nil, nil, err
+	}
+
+	cert, err := x509.ParseCertificate(pair.Certificate[0])
+	if err != nil {
+		return nil, nil, err
+	}
+
+	key, ok := pair.PrivateKey.(crypto.Signer)
+	if !ok {
+		return nil, nil, fmt.Errorf("unsupported private key in %s", keyPath)
+	}
+
+	return cert, key, nil
 }
@@ -0,0 +1,178 @@
+package common
+
+import (
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func newTestCA(t *testing.T) (*LocalCA, string) {
+	t.Helper()
+
+	dir := filepath.Join(t.TempDir(), "ca")
+	ca, err := LoadLocalCA(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return ca, dir
+}
+
+func TestCAIsPersisted(t *testing.T) {
+	ca, dir := newTestCA(t)
+
+	again, err := LoadLocalCA(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !again.cert.Equal(ca.cert) {
+		t.Fatal("expected the ca to be loaded again")
+	}
+	if !ca.cert.IsCA {
+		t.Fatal("expected a ca certificate")
+	}
+}
+
+func TestLeafIsReused(t *testing.T) {
+	ca, _ := newTestCA(t)
+	hosts := []string{"doorbell", "doorbell.local", "192.168.1.20"}
+
+	first, err := ca.Leaf(hosts)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pool := x509.NewCertPool()
+	pool.AddCert(ca.cert)
+	for _, h := range hosts {
+		if _, err := first.Leaf.Verify(x509.VerifyOptions{DNSName: h, Roots: pool}); err != nil {
+			t.Fatalf("expected the leaf to be valid for %s, got %s", h, err)
+		}
+	}
+
+	second, err := ca.Leaf(hosts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !second.Leaf.Equal(first.Leaf) {
+		t.Fatal("expected the leaf to be reused")
+	}
+
+	// hosts covered already need no new one
+	third, err := ca.Leaf(hosts[:1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !third.Leaf.Equal(first.Leaf) {
+		t.Fatal("expected the leaf to be reused for fewer hosts")
+	}
+}
+
+func TestLeafReissued(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		prepare func(t *testing.T, ca *LocalCA, dir string)
+	}{
+		{"new host", func(t *testing.T, ca *LocalCA, dir string) {
+			if _, err := ca.Leaf([]string{"doorbell"}); err != nil {
+				t.Fatal(err)
+			}
+		}},
+		{"near expiry", func(t *testing.T, ca *LocalCA, dir string) {
+			template, err := newTemplate(pkix.Name{CommonName: "doorbell"}, renewBefore-time.Hour)
+			if err != nil {
+				t.Fatal(err)
+			}
+			template.DNSNames = []string{"doorbell", "door.example"}
+			if _, _, err := createPair(template, ca.cert, ca.key, filepath.Join(dir, leafCertFile), filepath.Join(dir, leafKeyFile)); err != nil {
+				t.Fatal(err)
+			}
+		}},
+		{"other ca", func(t *testing.T, ca *LocalCA, dir string) {
+			other, _ := newTestCA(t)
+			if _, err := other.Leaf([]string{"doorbell", "door.example"}); err != nil {
+				t.Fatal(err)
+			}
+			for _, f := range []string{leafCertFile, leafKeyFile} {
+				data, err := os.ReadFile(filepath.Join(other.dir, f))
+				if err != nil {
+					t.Fatal(err)
+				}
+				if err := os.WriteFile(filepath.Join(dir, f), data, 0o600); err != nil {
+					t.Fatal(err)
+				}
+			}
+		}},
+		{"mismatched pair", func(t *testing.T, ca *LocalCA, dir string) {
+			if _, err := ca.Leaf([]string{"doorbell", "door.example"}); err != nil {
+				t.Fatal(err)
+			}
+			// a crash after the key was written, the certificate is the one before
+			data, err := os.ReadFile(filepath.Join(dir, caKeyFile))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := os.WriteFile(filepath.Join(dir, leafKeyFile), data, 0o600); err != nil {
+				t.Fatal(err)
+			}
+		}},
+		{"truncated", func(t *testing.T, ca *LocalCA, dir string) {
+			if err := os.WriteFile(filepath.Join(dir, leafCertFile), []byte("-----BEGIN CERT"), 0o644); err != nil {
+				t.Fatal(err)
+			}
+			if err := os.WriteFile(filepath.Join(dir, leafKeyFile), nil, 0o600); err != nil {
+				t.Fatal(err)
+			}
+		}},
+	} {
+		ca, dir := newTestCA(t)
+		tc.prepare(t, ca, dir)
+
+		before, _ := os.ReadFile(filepath.Join(dir, leafCertFile))
+
+		leaf, err := ca.Leaf([]string{"doorbell", "door.example"})
+		if err != nil {
+			t.Errorf("%s: expected a new leaf, got %s", tc.name, err)
+			continue
+		}
+
+		if after, _ := os.ReadFile(filepath.Join(dir, leafCertFile)); string(after) == string(before) {
+			t.Errorf("%s: expected the leaf to be replaced", tc.name)
+		}
+		if err := leaf.Leaf.CheckSignatureFrom(ca.cert); err != nil {
+			t.Errorf("%s: expected the leaf to be signed by the ca, got %s", tc.name, err)
+		}
+		if err := leaf.Leaf.VerifyHostname("door.example"); err != nil || time.Until(leaf.Leaf.NotAfter) < renewBefore {
+			t.Errorf("%s: expected a fresh leaf for the hosts, got %v", tc.name, err)
+		}
+
+		// the new pair loads again
+		if _, _, err := loadPair(filepath.Join(dir, leafCertFile), filepath.Join(dir, leafKeyFile)); err != nil {
+			t.Errorf("%s: expected a consistent pair, got %s", tc.name, err)
+		}
+
+		if entries, _ := filepath.Glob(filepath.Join(dir, "*.tmp")); len(entries) > 0 {
+			t.Errorf("%s: expected no temporary files, got %v", tc.name, entries)
+		}
+	}
+}
+
+func TestLeafIPHost(t *testing.T) {
+	ca, _ := newTestCA(t)
+
+	leaf, err := ca.Leaf([]string{"doorbell", "::1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(leaf.Leaf.IPAddresses) != 1 || !leaf.Leaf.IPAddresses[0].Equal(net.IPv6loopback) || len(leaf.Leaf.DNSNames) != 1 {
+		t.Fatalf("expected the address as ip, got %v and %v", leaf.Leaf.IPAddresses, leaf.Leaf.DNSNames)
+	}
+	if len(leaf.Certificate) != 2 {
+		t.Fatalf("expected the chain up to the ca, got %d", len(leaf.Certificate))
+	}
+}
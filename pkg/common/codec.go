@@ -12,6 +12,7 @@ type StreamCodec string
 const (
 	// video codecs
 	H264 StreamCodec = "H264"
+	H265 StreamCodec = "H265"
 	VP8  StreamCodec = "VP8"
 	VP9  StreamCodec = "VP9"
 
@@ -23,6 +24,8 @@ func (c *StreamCodec) UnmarshalText(text []byte) error {
 	switch strings.ToUpper(string(text)) {
 	case string(H264):
 		*c = H264
+	case string(H265):
+		*c = H265
 	case string(VP8):
 		*c = VP8
 	case string(VP9):
@@ -40,6 +43,8 @@ func (c StreamCodec) Mime() string {
 	switch c {
 	case H264:
 		return webrtc.MimeTypeH264
+	case H265:
+		return webrtc.MimeTypeH265
 	case VP8:
 		return webrtc.MimeTypeVP8
 	case VP9:
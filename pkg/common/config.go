@@ -5,7 +5,9 @@ import (
 	"net"
 	"os"
 	"path/filepath"
+	"reflect"
 	"strings"
+	"time"
 
 	"github.com/alexflint/go-arg"
 	"go.uber.org/zap"
@@ -13,21 +15,49 @@ import (
 )
 
 type File = ConfigFile
+type Commands = ConfigCommands
 type VideoSrc = ConfigVideoSourceStream
 type AudioSrc = ConfigAudioSourceStream
 type AudioSink = ConfigAudioSinkStream
 type Logging = ConfigLogging
 type Ring = ConfigRing
 type Http = ConfigHTTP
+type Auth = ConfigAuth
+type Ice = ConfigICE
+type Turn = ConfigTURN
+type Recording = ConfigRecording
+type Fallback = ConfigFallback
+type Motion = ConfigMotion
+type Mqtt = ConfigMQTT
+type Webhooks = ConfigWebhooks
+type Push = ConfigPush
+type Notify = ConfigNotify
+type State = ConfigState
+type Acme = ConfigACME
 
 type Config struct {
 	File
+	Commands  `yaml:"-"`
 	VideoSrc  `yaml:"video-src"`
 	AudioSrc  `yaml:"audio-src"`
 	AudioSink `yaml:"audio-sink"`
 	Logging   `yaml:"logging"`
 	Ring      `yaml:"ring"`
 	Http      `yaml:"http"`
+	Auth      `yaml:"auth"`
+	Ice       `yaml:"ice"`
+	Turn      `yaml:"turn"`
+	Recording `yaml:"recording"`
+	Fallback  `yaml:"fallback"`
+	Motion    `yaml:"motion"`
+	Mqtt      `yaml:"mqtt"`
+	Webhooks  `yaml:"webhooks"`
+	Push      `yaml:"push"`
+	Notify    `yaml:"notify"`
+	State     `yaml:"state"`
+	Acme      `yaml:"acme"`
+
+	unknown []error // keys of the file no option takes
 }
 
 type Path struct {
@@ -42,12 +72,43 @@ type ConfigStream struct {
 	VideoSrc  ConfigVideoSourceStream // video src for webrtc send
 	AudioSrc  ConfigAudioSourceStream // audio src for webrtc send
 	AudioSink ConfigAudioSinkStream   // audio sink for webrtc receive
+	Ice       ConfigICE               // ice servers for both sides
+	Fallback  ConfigFallback          // outputs for clients without webrtc
+	Motion    ConfigMotion            // detection on the video src
 }
 
 type ConfigFile struct {
 	Path *Path `arg:"--config"`
 }
 
+// ConfigCommands are the subcommands, the service runs without one as with serve
+type ConfigCommands struct {
+	Serve       *ServeCommand       `arg:"subcommand:serve" help:"run the service"`
+	CheckConfig *CheckConfigCommand `arg:"subcommand:check-config" help:"validate the configuration and report all problems"`
+	Devices     *DevicesCommand     `arg:"subcommand:devices" help:"list the cameras, audio and input devices with their capabilities"`
+	Probe       *ProbeCommand       `arg:"subcommand:probe" help:"run the configured pipelines for a while and report what they negotiated"`
+	RingTest    *RingTestCommand    `arg:"subcommand:ring-test" help:"fire the configured ring reactions without a button press"`
+	GenConfig   *GenConfigCommand   `arg:"subcommand:gen-config" help:"write an annotated config file of all options"`
+}
+
+type ServeCommand struct{}
+
+type CheckConfigCommand struct{}
+
+type DevicesCommand struct{}
+
+type ProbeCommand struct {
+	Duration time.Duration `arg:"--duration" default:"5s" help:"time the pipelines run"`
+}
+
+type RingTestCommand struct {
+	Wait time.Duration `arg:"--wait" default:"20s" help:"time given to the reactions before exiting"`
+}
+
+type GenConfigCommand struct {
+	Output string `arg:"positional" default:"-" help:"file to write, - for stdout"`
+}
+
 type ConfigLogging struct {
 	Level       zap.AtomicLevel `arg:"--log-level,env:LOG_LEVEL" yaml:"level" default:"debug"`
 	Development bool            `arg:"--log-development,env:LOG_DEVELOPMENT" yaml:"development"`
@@ -61,29 +122,209 @@ type ConfigRing struct {
 	SonosTarget          string  `arg:"--sonos-target,env:SONOS_TARGET" yaml:"sonos-target" default:"-"`
 	SonosVolume          int     `arg:"--sonos-volume,env:SONOS_VOLUME" yaml:"sonos-volume" default:"50"`
 	HomeassistantWebhook *string `arg:"--ha-webhook,env:HA_WEBHOOK" yaml:"ha-webhook"`
+	MotionChime          bool    `arg:"--motion-chime,env:MOTION_CHIME" yaml:"motion-chime"` // play the jingle on motion too
 	NoIPv6               bool    `arg:"--disable-ipv6,env:NO_IPV6" yaml:"no-ipv6"`
 }
 
 type ConfigHTTP struct {
-	Host             string  `arg:"--http-host,env:HTTP_HOST" yaml:"host"`
-	Port             uint    `arg:"--http-port,env:HTTP_PORT" yaml:"port" default:"8080"`
-	Tls              bool    `arg:"--http-tls,env:HTTP_TLS" yaml:"tls" default:"true"`
-	TlsKey           *string `arg:"--http-tls-key,env:HTTP_TLS_KEY" yaml:"tls-key"`
-	TlsCert          *string `arg:"--http-tls-cert,env:HTTP_TLS_CERT" yaml:"tls-cert"`
-	PathGetLiveness  string  `arg:"env:HTTP_PATH_LIVENESS" yaml:"liveness" default:"/healthz"`
-	PathGetReadiness string  `arg:"env:HTTP_PATH_READINESS" yaml:"readiness" default:"/readyz"`
-	StaticPath       *Path   `arg:"--http-static,env:HTTP_STATIC" yaml:"static"`
+	Host             string        `arg:"--http-host,env:HTTP_HOST" yaml:"host"`
+	Port             uint          `arg:"--http-port,env:HTTP_PORT" yaml:"port" default:"8080"`
+	Tls              bool          `arg:"--http-tls,env:HTTP_TLS" yaml:"tls" default:"true"`
+	TlsKey           *string       `arg:"--http-tls-key,env:HTTP_TLS_KEY" yaml:"tls-key"`
+	TlsCert          *string       `arg:"--http-tls-cert,env:HTTP_TLS_CERT" yaml:"tls-cert"`
+	TlsHosts         []string      `arg:"--http-tls-host,separate,env:HTTP_TLS_HOSTS" yaml:"tls-hosts"` // further names of the generated certificate
+	PathGetLiveness  string        `arg:"env:HTTP_PATH_LIVENESS" yaml:"liveness" default:"/healthz"`
+	PathGetReadiness string        `arg:"env:HTTP_PATH_READINESS" yaml:"readiness" default:"/readyz"`
+	PathGetMetrics   string        `arg:"env:HTTP_PATH_METRICS" yaml:"metrics" default:"/metrics"`
+	PathWhep         string        `arg:"env:HTTP_PATH_WHEP" yaml:"whep" default:"/whep"`
+	PathWhip         string        `arg:"env:HTTP_PATH_WHIP" yaml:"whip" default:"/whip"`
+	PathGetSnapshot  string        `arg:"env:HTTP_PATH_SNAPSHOT" yaml:"snapshot" default:"/snapshot.jpg"`
+	PathGetMJPEG     string        `arg:"env:HTTP_PATH_MJPEG" yaml:"mjpeg" default:"/mjpeg"`
+	PathGetHLS       string        `arg:"env:HTTP_PATH_HLS" yaml:"hls" default:"/hls"`
+	PathPush         string        `arg:"env:HTTP_PATH_PUSH" yaml:"push" default:"/push"`
+	PathGetCA        string        `arg:"env:HTTP_PATH_CA" yaml:"ca" default:"/ca.crt"`
+	SnapshotMaxAge   time.Duration `arg:"--snapshot-max-age,env:SNAPSHOT_MAX_AGE" yaml:"snapshot-max-age" default:"2s"`
+	StaticPath       *Path         `arg:"--http-static,env:HTTP_STATIC" yaml:"static"`
+	PublicUrl        *string       `arg:"--http-public-url,env:HTTP_PUBLIC_URL" yaml:"public-url"` // base url used in links handed out
+}
+
+type ConfigState struct {
+	Dir Path `arg:"--state-dir,env:STATE_DIR" yaml:"dir" default:"state"` // generated keys and certificates
+}
+
+type ConfigACME struct {
+	Domains          []string      `arg:"--acme-domain,separate,env:ACME_DOMAINS" yaml:"domains"` // disabled when empty
+	Email            string        `arg:"--acme-email,env:ACME_EMAIL" yaml:"email"`
+	Directory        string        `arg:"--acme-directory,env:ACME_DIRECTORY" yaml:"directory" default:"https://acme-v02.api.letsencrypt.org/directory"`
+	DirectoryCA      *Path         `arg:"--acme-directory-ca,env:ACME_DIRECTORY_CA" yaml:"directory-ca"`          // trusted for a local test server
+	Challenge        string        `arg:"--acme-challenge,env:ACME_CHALLENGE" yaml:"challenge" default:"http-01"` // http-01 or dns-01
+	HttpAddr         string        `arg:"--acme-http-addr,env:ACME_HTTP_ADDR" yaml:"http-addr" default:":80"`     // plain http listener of http-01, the ca validates on port 80, empty when a proxy passes the challenges on
+	DnsServer        string        `arg:"--acme-dns-server,env:ACME_DNS_SERVER" yaml:"dns-server"`                // rfc2136 primary, host:port
+	DnsZone          string        `arg:"--acme-dns-zone,env:ACME_DNS_ZONE" yaml:"dns-zone"`
+	DnsTsigKey       *string       `arg:"--acme-dns-tsig-key,env:ACME_DNS_TSIG_KEY" yaml:"dns-tsig-key"`
+	DnsTsigSecret    *string       `arg:"--acme-dns-tsig-secret,env:ACME_DNS_TSIG_SECRET" yaml:"dns-tsig-secret"` // base64
+	DnsTsigAlgorithm string        `arg:"--acme-dns-tsig-algorithm,env:ACME_DNS_TSIG_ALGORITHM" yaml:"dns-tsig-algorithm" default:"hmac-sha256."`
+	DnsPropagation   time.Duration `arg:"--acme-dns-propagation,env:ACME_DNS_PROPAGATION" yaml:"dns-propagation" default:"10s"`
+}
+
+type ConfigAuth struct {
+	Users         []ConfigUser  `arg:"-" yaml:"users"`
+	Tokens        []string      `arg:"--auth-token,separate,env:AUTH_TOKENS" yaml:"tokens"`
+	SessionSecret *string       `arg:"--auth-session-secret,env:AUTH_SESSION_SECRET" yaml:"session-secret"`
+	SessionTTL    time.Duration `arg:"--auth-session-ttl,env:AUTH_SESSION_TTL" yaml:"session-ttl" default:"720h"`
+}
+
+type ConfigUser struct {
+	Name     string `yaml:"name"`
+	Password string `yaml:"password"` // bcrypt hash
+}
+
+type ConfigICE struct {
+	Servers    []ConfigICEServer `arg:"-" yaml:"servers"`
+	NoExternal bool              `arg:"--ice-no-external,env:ICE_NO_EXTERNAL" yaml:"no-external"`
+}
+
+type ConfigICEServer struct {
+	Urls       []string      `yaml:"urls"`
+	Username   string        `yaml:"username"`
+	Credential string        `yaml:"credential"`
+	Secret     *string       `yaml:"secret"` // shared secret for time limited credentials
+	TTL        time.Duration `yaml:"ttl"`
+}
+
+type ConfigTURN struct {
+	Enabled       bool          `arg:"--turn,env:TURN" yaml:"enabled"`
+	Listen        string        `arg:"--turn-listen,env:TURN_LISTEN" yaml:"listen" default:"0.0.0.0:3478"`
+	PublicIP      *string       `arg:"--turn-public-ip,env:TURN_PUBLIC_IP" yaml:"public-ip"`
+	PublicHost    *string       `arg:"--turn-public-host,env:TURN_PUBLIC_HOST" yaml:"public-host"`
+	Realm         string        `arg:"--turn-realm,env:TURN_REALM" yaml:"realm" default:"webrtc"`
+	RelayMinPort  uint16        `arg:"--turn-relay-min-port,env:TURN_RELAY_MIN_PORT" yaml:"relay-min-port" default:"50000"`
+	RelayMaxPort  uint16        `arg:"--turn-relay-max-port,env:TURN_RELAY_MAX_PORT" yaml:"relay-max-port" default:"50100"`
+	CredentialTTL time.Duration `arg:"--turn-credential-ttl,env:TURN_CREDENTIAL_TTL" yaml:"credential-ttl" default:"12h"`
+}
+
+type ConfigRecording struct {
+	Enabled   bool          `arg:"--recording,env:RECORDING" yaml:"enabled"`
+	Dir       Path          `arg:"--recording-dir,env:RECORDING_DIR" yaml:"dir" default:"recordings"`
+	PreRoll   time.Duration `arg:"--recording-pre-roll,env:RECORDING_PRE_ROLL" yaml:"pre-roll" default:"5s"`
+	PostRoll  time.Duration `arg:"--recording-post-roll,env:RECORDING_POST_ROLL" yaml:"post-roll" default:"20s"`
+	MaxCount  int           `arg:"--recording-max-count,env:RECORDING_MAX_COUNT" yaml:"max-count" default:"100"`
+	MaxSizeMB int64         `arg:"--recording-max-size,env:RECORDING_MAX_SIZE" yaml:"max-size-mb" default:"1024"`
+	MaxAge    time.Duration `arg:"--recording-max-age,env:RECORDING_MAX_AGE" yaml:"max-age" default:"168h"`
+}
+
+type ConfigMotion struct {
+	Enabled     bool           `arg:"--motion,env:MOTION" yaml:"enabled"`
+	Sensitivity uint8          `arg:"--motion-sensitivity,env:MOTION_SENSITIVITY" yaml:"sensitivity" default:"25"` // brightness change a pixel has to exceed
+	Threshold   float64        `arg:"--motion-threshold,env:MOTION_THRESHOLD" yaml:"threshold" default:"0.02"`     // share of changed pixels
+	Cooldown    time.Duration  `arg:"--motion-cooldown,env:MOTION_COOLDOWN" yaml:"cooldown" default:"10s"`
+	Regions     []ConfigRegion `arg:"-" yaml:"regions"`
+}
+
+// ConfigRegion is a part of the frame, all values are fractions of its size
+type ConfigRegion struct {
+	X      float64 `yaml:"x"`
+	Y      float64 `yaml:"y"`
+	Width  float64 `yaml:"width"`
+	Height float64 `yaml:"height"`
+}
+
+type ConfigMQTT struct {
+	Broker          *string `arg:"--mqtt-broker,env:MQTT_BROKER" yaml:"broker"` // e.g. tcp://localhost:1883
+	Username        *string `arg:"--mqtt-username,env:MQTT_USERNAME" yaml:"username"`
+	Password        *string `arg:"--mqtt-password,env:MQTT_PASSWORD" yaml:"password"`
+	ClientId        string  `arg:"--mqtt-client-id,env:MQTT_CLIENT_ID" yaml:"client-id" default:"doorbell"`
+	Topic           string  `arg:"--mqtt-topic,env:MQTT_TOPIC" yaml:"topic" default:"doorbell"` // prefix of all topics
+	Discovery       bool    `arg:"--mqtt-discovery,env:MQTT_DISCOVERY" yaml:"discovery" default:"true"`
+	DiscoveryPrefix string  `arg:"--mqtt-discovery-prefix,env:MQTT_DISCOVERY_PREFIX" yaml:"discovery-prefix" default:"homeassistant"`
+	DeviceName      string  `arg:"--mqtt-device-name,env:MQTT_DEVICE_NAME" yaml:"device-name" default:"Doorbell"`
+}
+
+type ConfigWebhooks struct {
+	Device      string          `arg:"--webhook-device,env:WEBHOOK_DEVICE" yaml:"device" default:"doorbell"` // handed to the body templates
+	Hooks       []ConfigWebhook `arg:"-" yaml:"hooks"`
+	SnapshotUrl string          `arg:"-" yaml:"-"` // derived from the public url
+}
+
+type ConfigWebhook struct {
+	Name    string            `yaml:"name"`
+	Url     string            `yaml:"url"`
+	Method  string            `yaml:"method"` // POST when empty
+	Headers map[string]string `yaml:"headers"`
+	Body    string            `yaml:"body"`    // go template of the json body
+	Events  []string          `yaml:"events"`  // all events when empty
+	Secret  *string           `yaml:"secret"`  // key of the hmac-sha256 signature
+	Timeout time.Duration     `yaml:"timeout"` // of a single attempt, 10s when empty
+	Retries *uint             `yaml:"retries"` // 3 when empty
+}
+
+type ConfigPush struct {
+	Enabled bool          `arg:"--push,env:PUSH" yaml:"enabled"`
+	Subject string        `arg:"--push-subject,env:PUSH_SUBJECT" yaml:"subject" default:"mailto:doorbell@localhost"`
+	TTL     time.Duration `arg:"--push-ttl,env:PUSH_TTL" yaml:"ttl" default:"60s"` // a late ring is useless
+	Motion  bool          `arg:"--push-motion,env:PUSH_MOTION" yaml:"motion"`      // notify on motion too
+}
+
+type ConfigNotify struct {
+	Ntfy        []ConfigNtfy   `arg:"-" yaml:"ntfy"`
+	Gotify      []ConfigGotify `arg:"-" yaml:"gotify"`
+	Matrix      []ConfigMatrix `arg:"-" yaml:"matrix"`
+	SnapshotUrl string         `arg:"-" yaml:"-"` // derived from the public url
+}
+
+// ConfigNotifier holds the options shared by all notification backends
+type ConfigNotifier struct {
+	Name     string   `yaml:"name"`
+	Events   []string `yaml:"events"`   // ring when empty
+	Title    string   `yaml:"title"`    // go template
+	Body     string   `yaml:"body"`     // go template
+	Priority int      `yaml:"priority"` // in the range of the backend
+	Snapshot bool     `yaml:"snapshot"` // attach the current image
+}
+
+type ConfigNtfy struct {
+	ConfigNotifier `yaml:",inline"`
+	Url            string  `yaml:"url"` // e.g. https://ntfy.sh
+	Topic          string  `yaml:"topic"`
+	Token          *string `yaml:"token"`
+}
+
+type ConfigGotify struct {
+	ConfigNotifier `yaml:",inline"`
+	Url            string `yaml:"url"`
+	Token          string `yaml:"token"` // of the application
+}
+
+type ConfigMatrix struct {
+	ConfigNotifier `yaml:",inline"`
+	Homeserver     string `yaml:"homeserver"` // e.g. https://matrix.org
+	Room           string `yaml:"room"`       // id of the room
+	Token          string `yaml:"token"`      // access token of the sending user
+}
+
+// name of the playlist within the hls dir
+const HLSPlaylist = "index.m3u8"
+
+type ConfigFallback struct {
+	MJPEGFps   uint          `arg:"--mjpeg-fps,env:MJPEG_FPS" yaml:"mjpeg-fps" default:"5"`
+	HLSDir     Path          `arg:"--hls-dir,env:HLS_DIR" yaml:"hls-dir" default:"/tmp/doorbell-hls"`
+	HLSSegment uint          `arg:"--hls-segment,env:HLS_SEGMENT" yaml:"hls-segment" default:"2"` // seconds
+	HLSPart    time.Duration `arg:"--hls-part,env:HLS_PART" yaml:"hls-part" default:"500ms"`      // low-latency clients load the parts, a few of them make up the lag
+	HLSIdle    time.Duration `arg:"--hls-idle,env:HLS_IDLE" yaml:"hls-idle" default:"30s"`
 }
 
 type ConfigVideoSourceStream struct {
-	Source    string      `arg:"--video-src,env:VIDEO_SRC" yaml:"source" default:"v4l2src"`
-	Device    string      `arg:"--video-src-device,env:VIDEO_SRC_DEVICE" yaml:"device" default:"/dev/video0"`
-	Codec     StreamCodec `arg:"--video-src-codec,env:VIDEO_SRC_CODEC" yaml:"codec" default:"vp8"`
-	Height    uint        `arg:"--video-src-height,env:VIDEO_SRC_HEIGHT" yaml:"height" default:"480"`
-	Width     uint        `arg:"--video-src-width,env:VIDEO_SRC_WIDTH" yaml:"width" default:"640"`
-	Framerate uint        `arg:"--video-src-fps,env:VIDEO_SRC_FPS" yaml:"fps" default:"30"`
-	Bitrate   uint        `arg:"--video-src-bps,env:VIDEO_SRC_BPS" yaml:"bps" default:"300"`
-	Queue     bool        `arg:"--video-src-queue,env:VIDEO_SRC_QUEUE" yaml:"queue" default:"false"`
+	Source      string      `arg:"--video-src,env:VIDEO_SRC" yaml:"source" default:"v4l2src"`
+	Device      string      `arg:"--video-src-device,env:VIDEO_SRC_DEVICE" yaml:"device" default:"/dev/video0"`
+	Location    *string     `arg:"--video-src-location,env:VIDEO_SRC_LOCATION" yaml:"location"`
+	Format      string      `arg:"--video-src-format,env:VIDEO_SRC_FORMAT" yaml:"format" default:"auto"` // auto picks it from the capabilities of a v4l2 camera
+	Codec       StreamCodec `arg:"--video-src-codec,env:VIDEO_SRC_CODEC" yaml:"codec" default:"vp8"`
+	Height      uint        `arg:"--video-src-height,env:VIDEO_SRC_HEIGHT" yaml:"height" default:"480"`
+	Width       uint        `arg:"--video-src-width,env:VIDEO_SRC_WIDTH" yaml:"width" default:"640"`
+	Framerate   uint        `arg:"--video-src-fps,env:VIDEO_SRC_FPS" yaml:"fps" default:"30"`
+	Bitrate     uint        `arg:"--video-src-bps,env:VIDEO_SRC_BPS" yaml:"bps" default:"300"`
+	Queue       bool        `arg:"--video-src-queue,env:VIDEO_SRC_QUEUE" yaml:"queue" default:"false"`
+	Passthrough bool        `arg:"--video-src-passthrough,env:VIDEO_SRC_PASSTHROUGH" yaml:"passthrough" default:"false"`
 }
 
 type ConfigAudioSourceStream struct {
@@ -109,13 +350,68 @@ func (c *Config) Stream() *ConfigStream {
 		VideoSrc:  c.VideoSrc,
 		AudioSrc:  c.AudioSrc,
 		AudioSink: c.AudioSink,
+		Ice:       c.Ice,
+		Fallback:  c.Fallback,
+		Motion:    c.Motion,
 	}
 }
 
+// Webhook returns the configured hooks including the home assistant webhook of the ring section
+func (c *Config) Webhook() *ConfigWebhooks {
+	w := c.Webhooks
+	w.Hooks = append([]ConfigWebhook(nil), c.Webhooks.Hooks...)
+
+	if c.Ring.HomeassistantWebhook != nil {
+		w.Hooks = append(w.Hooks, ConfigWebhook{
+			Name:   "homeassistant",
+			Url:    *c.Ring.HomeassistantWebhook,
+			Events: []string{"ring"}, // like before, motion needs an own hook
+		})
+	}
+
+	w.SnapshotUrl = c.Http.SnapshotUrl()
+
+	return &w
+}
+
+// Notifications returns the notification backends with the public snapshot link
+func (c *Config) Notifications() *ConfigNotify {
+	n := c.Notify
+	n.SnapshotUrl = c.Http.SnapshotUrl()
+
+	return &n
+}
+
+// LocalCA tells whether the certificate is issued by the local ca
+func (c *ConfigHTTP) LocalCA() bool {
+	return c.Tls && (c.TlsCert == nil || c.TlsKey == nil)
+}
+
+func (c *ConfigACME) Enabled() bool {
+	return len(c.Domains) > 0
+}
+
+func (c *ConfigAuth) Enabled() bool {
+	return len(c.Users) > 0 || len(c.Tokens) > 0
+}
+
 func (c *ConfigHTTP) Address() string {
 	return net.JoinHostPort(c.Host, fmt.Sprint(c.Port))
 }
 
+// SnapshotUrl is the public link of the snapshot, empty without a public url
+func (c *ConfigHTTP) SnapshotUrl() string {
+	if c.PublicUrl == nil {
+		return ""
+	}
+
+	return strings.TrimSuffix(*c.PublicUrl, "/") + c.PathGetSnapshot
+}
+
+func (p Path) MarshalText() ([]byte, error) {
+	return []byte(p.string), nil
+}
+
 func (p *Path) UnmarshalText(b []byte) error {
 	p.string = string(b)
 
@@ -134,20 +430,66 @@ func (p *Path) UnmarshalText(b []byte) error {
 }
 
 func (c *Config) MustParse() {
-	// first parse just the config file flag
-	arg.MustParse(&c.File)
-
-	if c.File.Path != nil {
-		if _, err := os.Stat(c.File.Path.String()); err == nil {
-			if data, err := os.ReadFile(c.File.Path.String()); err == nil {
-				err := yaml.Unmarshal(data, c)
-				if err != nil {
-					panic(err)
-				}
-			}
-		}
+	// first find the config file, the arguments are taken again on top of it
+	pre := Config{}
+	arg.MustParse(&pre)
+	c.File = pre.File
+
+	if err := c.readFile(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
 	}
 
 	// parse the rest
 	arg.MustParse(c)
 }
+
+// Parse works like MustParse on the given arguments but returns the errors, a reload must not end the process
+func (c *Config) Parse(args []string) error {
+	pre := Config{}
+	p, err := arg.NewParser(arg.Config{}, &pre)
+	if err != nil {
+		return err
+	}
+
+	if err := p.Parse(args); err != nil {
+		return err
+	}
+	c.File = pre.File
+
+	if err := c.readFile(); err != nil {
+		return err
+	}
+
+	p, err = arg.NewParser(arg.Config{}, c)
+	if err != nil {
+		return err
+	}
+
+	return p.Parse(args)
+}
+
+// readFile takes the values of the config file, the unknown keys are kept for Validate
+func (c *Config) readFile() error {
+	if c.File.Path == nil {
+		return nil
+	}
+
+	data, err := os.ReadFile(c.File.Path.String())
+	if err != nil {
+		return fmt.Errorf("failed to read config - %s", err)
+	}
+
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return fmt.Errorf("invalid config %s - %s", c.File.Path, err)
+	}
+
+	if err := doc.Decode(c); err != nil {
+		return fmt.Errorf("invalid config %s - %s", c.File.Path, err)
+	}
+
+	c.unknown = unknownKeys(&doc, reflect.TypeOf(c), "")
+
+	return nil
+}
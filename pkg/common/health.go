@@ -0,0 +1,88 @@
+package common
+
+import "sync"
+
+type HealthCheck func() error
+
+type Health struct {
+	mu        *sync.RWMutex
+	liveness  map[string]*HealthCheck
+	readiness map[string]*HealthCheck
+}
+
+type HealthStatus struct {
+	Healthy    bool                       `json:"healthy"`
+	Components map[string]ComponentStatus `json:"components"`
+}
+
+type ComponentStatus struct {
+	Healthy bool   `json:"healthy"`
+	Error   string `json:"error,omitempty"`
+}
+
+func NewHealth() *Health {
+	return &Health{
+		mu:        &sync.RWMutex{},
+		liveness:  make(map[string]*HealthCheck),
+		readiness: make(map[string]*HealthCheck),
+	}
+}
+
+func (h *Health) AddLivenessCheck(name string, check HealthCheck) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.liveness[name] = &check
+}
+
+// AddReadinessCheck replaces the check of the same name, the returned func
+// removes the check unless it was replaced meanwhile
+func (h *Health) AddReadinessCheck(name string, check HealthCheck) func() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	c := &check
+	h.readiness[name] = c
+
+	return func() {
+		h.mu.Lock()
+		defer h.mu.Unlock()
+
+		if h.readiness[name] == c {
+			delete(h.readiness, name)
+		}
+	}
+}
+
+func (h *Health) Liveness() HealthStatus {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	return runChecks(h.liveness)
+}
+
+func (h *Health) Readiness() HealthStatus {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	return runChecks(h.readiness)
+}
+
+func runChecks(checks map[string]*HealthCheck) HealthStatus {
+	s := HealthStatus{
+		Healthy:    true,
+		Components: make(map[string]ComponentStatus, len(checks)),
+	}
+
+	for name, check := range checks {
+		if err := (*check)(); err != nil {
+			s.Healthy = false
+			s.Components[name] = ComponentStatus{Healthy: false, Error: err.Error()}
+			continue
+		}
+
+		s.Components[name] = ComponentStatus{Healthy: true}
+	}
+
+	return s
+}
@@ -1,6 +1,10 @@
 package common
 
-import "time"
+import (
+	"net"
+	"sync"
+	"time"
+)
 
 func Time[T any](runnable func() (T, error)) (time.Duration, T, error) {
 	t := time.Now()
@@ -11,3 +15,19 @@ func Time[T any](runnable func() (T, error)) (time.Duration, T, error) {
 func Ptr[T any](v T) *T {
 	return &v
 }
+
+// Done returns a channel closed once the group finished
+func Done(wg *sync.WaitGroup) <-chan struct{} {
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+
+	return done
+}
+
+// PublicIP tells whether the address is reachable from the internet, local networks are no business of remote clients
+func PublicIP(ip net.IP) bool {
+	return !(ip.IsLoopback() || ip.IsPrivate() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() || ip.IsUnspecified())
+}
@@ -0,0 +1,330 @@
+package common
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"reflect"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/holoplot/go-evdev"
+	"github.com/kaedwen/webrtc/pkg/events"
+	"golang.org/x/crypto/bcrypt"
+	"gopkg.in/yaml.v3"
+)
+
+// FieldError is the problem of a single option, the path is the one of the config file
+type FieldError struct {
+	Path string
+	Line int // in the config file, 0 when unknown
+	Msg  string
+}
+
+func (e *FieldError) Error() string {
+	if e.Line > 0 {
+		return fmt.Sprintf("%s: %s (line %d)", e.Path, e.Msg, e.Line)
+	}
+
+	return fmt.Sprintf("%s: %s", e.Path, e.Msg)
+}
+
+type validator struct {
+	errs []error
+}
+
+func (v *validator) fail(path string, format string, args ...any) {
+	v.errs = append(v.errs, &FieldError{Path: path, Msg: fmt.Sprintf(format, args...)})
+}
+
+func (v *validator) check(ok bool, path string, format string, args ...any) {
+	if !ok {
+		v.fail(path, format, args...)
+	}
+}
+
+func (v *validator) exists(path string, file string) {
+	if _, err := os.Stat(file); err != nil {
+		v.fail(path, "%s", err)
+	}
+}
+
+func (v *validator) url(path string, value string, schemes ...string) {
+	u, err := url.Parse(value)
+	if err != nil || u.Host == "" {
+		v.fail(path, "invalid url - %q", value)
+		return
+	}
+
+	if len(schemes) > 0 && !slices.Contains(schemes, u.Scheme) {
+		v.fail(path, "unsupported scheme %q, expected one of %s", u.Scheme, strings.Join(schemes, ", "))
+	}
+}
+
+func (v *validator) events(path string, kinds []string) {
+	for i, k := range kinds {
+		v.check(events.Kind(k).Valid(), fmt.Sprintf("%s[%d]", path, i), "unknown event %q", k)
+	}
+}
+
+// Validate checks the values and their combinations, all problems are joined in the error
+func (c *Config) Validate() error {
+	v := validator{errs: append([]error(nil), c.unknown...)}
+
+	c.validateStream(&v)
+	c.validateRing(&v)
+	c.validateHttp(&v)
+	c.validateHooks(&v)
+
+	v.check(c.Turn.RelayMinPort <= c.Turn.RelayMaxPort, "turn.relay-min-port", "above relay-max-port %d", c.Turn.RelayMaxPort)
+
+	for i, s := range c.Ice.Servers {
+		path := fmt.Sprintf("ice.servers[%d]", i)
+		v.check(len(s.Urls) > 0, path+".urls", "at least one url is needed")
+		for j, u := range s.Urls {
+			v.check(strings.HasPrefix(u, "stun:") || strings.HasPrefix(u, "turn:") || strings.HasPrefix(u, "turns:"), fmt.Sprintf("%s.urls[%d]", path, j), "expected a stun:, turn: or turns: url - %q", u)
+		}
+	}
+
+	v.check(c.Motion.Threshold > 0 && c.Motion.Threshold <= 1, "motion.threshold", "expected a share in (0, 1] - %g", c.Motion.Threshold)
+	for i, r := range c.Motion.Regions {
+		v.check(r.X >= 0 && r.Y >= 0 && r.Width > 0 && r.Height > 0 && r.X+r.Width <= 1 && r.Y+r.Height <= 1, fmt.Sprintf("motion.regions[%d]", i), "expected fractions within the frame")
+	}
+
+	v.check(c.Fallback.HLSSegment > 0, "fallback.hls-segment", "must be positive")
+	v.check(c.Fallback.HLSPart > 0 && c.Fallback.HLSPart < time.Duration(c.Fallback.HLSSegment)*time.Second, "fallback.hls-part", "expected a duration below the segment of %ds - %s", c.Fallback.HLSSegment, c.Fallback.HLSPart)
+
+	v.check(c.Recording.PreRoll >= 0, "recording.pre-roll", "must not be negative")
+	v.check(c.Recording.PostRoll >= 0, "recording.post-roll", "must not be negative")
+
+	if c.Mqtt.Broker != nil {
+		v.url("mqtt.broker", *c.Mqtt.Broker, "tcp", "ssl", "tls", "mqtt", "mqtts", "ws", "wss")
+	}
+
+	return errors.Join(v.errs...)
+}
+
+func (c *Config) validateStream(v *validator) {
+	vs := &c.VideoSrc
+
+	switch vs.Codec {
+	case VP8, VP9, H264:
+	case H265:
+		v.check(vs.Passthrough, "video-src.codec", "%s is only supported with passthrough", vs.Codec)
+	default:
+		v.fail("video-src.codec", "%s is not a video codec", vs.Codec)
+	}
+
+	v.check(vs.Width > 0 && vs.Height > 0, "video-src.width", "the size must not be empty")
+	v.check(vs.Framerate > 0, "video-src.fps", "must be positive")
+
+	if vs.Source == "v4l2src" {
+		v.exists("video-src.device", vs.Device)
+	}
+
+	v.check(c.AudioSrc.Codec == OPUS, "audio-src.codec", "%s is not an audio codec", c.AudioSrc.Codec)
+	v.check(c.AudioSrc.Channels == 1 || c.AudioSrc.Channels == 2, "audio-src.channels", "expected 1 or 2 - %d", c.AudioSrc.Channels)
+
+	v.check(strings.EqualFold(c.AudioSink.Codec, string(OPUS)), "audio-sink.codec", "%s is not supported, the peers send opus", c.AudioSink.Codec)
+}
+
+func (c *Config) validateRing(v *validator) {
+	_, ok := evdev.KEYFromString[c.Ring.Key]
+	v.check(ok, "ring.key", "unknown key %q", c.Ring.Key)
+
+	if c.Ring.Device != nil {
+		v.exists("ring.input", *c.Ring.Device)
+	}
+
+	if c.Ring.HomeassistantWebhook != nil {
+		v.url("ring.ha-webhook", *c.Ring.HomeassistantWebhook, "http", "https")
+	}
+
+	if c.Ring.JingleBaseUri != nil {
+		v.url("ring.jingle-base-uri", *c.Ring.JingleBaseUri, "http", "https")
+	}
+
+	v.check(c.Ring.SonosVolume >= 0 && c.Ring.SonosVolume <= 100, "ring.sonos-volume", "expected 0 to 100 - %d", c.Ring.SonosVolume)
+}
+
+func (c *Config) validateHttp(v *validator) {
+	h := &c.Http
+
+	v.check(h.Port <= 65535, "http.port", "out of range - %d", h.Port)
+
+	if h.PublicUrl != nil {
+		v.url("http.public-url", *h.PublicUrl, "http", "https")
+	}
+
+	// the certificate is generated without any of the two
+	switch {
+	case (h.TlsCert == nil) != (h.TlsKey == nil):
+		v.fail("http.tls-cert", "tls-cert and tls-key are only given together")
+	case h.TlsCert != nil:
+		if _, err := tls.LoadX509KeyPair(*h.TlsCert, *h.TlsKey); err != nil {
+			v.fail("http.tls-cert", "%s", err)
+		}
+		v.check(!c.Acme.Enabled(), "acme.domains", "acme replaces the given tls-cert")
+	}
+
+	for i, u := range c.Auth.Users {
+		path := fmt.Sprintf("auth.users[%d]", i)
+		v.check(u.Name != "", path+".name", "must not be empty")
+		if _, err := bcrypt.Cost([]byte(u.Password)); err != nil {
+			v.fail(path+".password", "expected a bcrypt hash - %s", err)
+		}
+	}
+
+	if !c.Acme.Enabled() {
+		return
+	}
+
+	a := &c.Acme
+	v.check(h.Tls, "acme.domains", "acme needs http.tls")
+	v.url("acme.directory", a.Directory, "https", "http")
+
+	switch a.Challenge {
+	case "http-01":
+		if a.HttpAddr != "" {
+			_, _, err := net.SplitHostPort(a.HttpAddr)
+			v.check(err == nil, "acme.http-addr", "expected host:port - %q", a.HttpAddr)
+		}
+	case "dns-01":
+		v.check(a.DnsServer != "", "acme.dns-server", "needed for dns-01")
+		v.check(a.DnsZone != "", "acme.dns-zone", "needed for dns-01")
+		v.check((a.DnsTsigKey == nil) == (a.DnsTsigSecret == nil), "acme.dns-tsig-key", "dns-tsig-key and dns-tsig-secret are only given together")
+	default:
+		v.fail("acme.challenge", "expected http-01 or dns-01 - %q", a.Challenge)
+	}
+
+	if a.DirectoryCA != nil {
+		v.exists("acme.directory-ca", a.DirectoryCA.String())
+	}
+}
+
+func (c *Config) validateHooks(v *validator) {
+	for i, h := range c.Webhooks.Hooks {
+		path := fmt.Sprintf("webhooks.hooks[%d]", i)
+		v.url(path+".url", h.Url, "http", "https")
+		v.events(path+".events", h.Events)
+	}
+
+	for i, n := range c.Notify.Ntfy {
+		path := fmt.Sprintf("notify.ntfy[%d]", i)
+		v.events(path+".events", n.Events)
+		v.url(path+".url", n.Url, "http", "https")
+		v.check(n.Topic != "", path+".topic", "must not be empty")
+	}
+
+	for i, n := range c.Notify.Gotify {
+		path := fmt.Sprintf("notify.gotify[%d]", i)
+		v.events(path+".events", n.Events)
+		v.url(path+".url", n.Url, "http", "https")
+		v.check(n.Token != "", path+".token", "must not be empty")
+	}
+
+	for i, n := range c.Notify.Matrix {
+		path := fmt.Sprintf("notify.matrix[%d]", i)
+		v.events(path+".events", n.Events)
+		v.url(path+".homeserver", n.Homeserver, "http", "https")
+		v.check(n.Room != "", path+".room", "must not be empty")
+		v.check(n.Token != "", path+".token", "must not be empty")
+	}
+}
+
+// unknownKeys reports the keys of the document that no field of t takes, yaml itself skips them silently
+func unknownKeys(node *yaml.Node, t reflect.Type, path string) []error {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+
+	// types decoding themselves are taken as they are
+	if reflect.PointerTo(t).Implements(textUnmarshaler) || reflect.PointerTo(t).Implements(yamlUnmarshaler) {
+		return nil
+	}
+
+	var errs []error
+
+	switch node.Kind {
+	case yaml.DocumentNode:
+		for _, n := range node.Content {
+			errs = append(errs, unknownKeys(n, t, path)...)
+		}
+	case yaml.SequenceNode:
+		if t.Kind() != reflect.Slice {
+			return nil
+		}
+		for i, n := range node.Content {
+			errs = append(errs, unknownKeys(n, t.Elem(), fmt.Sprintf("%s[%d]", path, i))...)
+		}
+	case yaml.MappingNode:
+		switch t.Kind() {
+		case reflect.Map:
+			for i := 0; i+1 < len(node.Content); i += 2 {
+				errs = append(errs, unknownKeys(node.Content[i+1], t.Elem(), join(path, node.Content[i].Value))...)
+			}
+		case reflect.Struct:
+			fields := yamlFields(t)
+			for i := 0; i+1 < len(node.Content); i += 2 {
+				key := node.Content[i]
+				ft, ok := fields[key.Value]
+				if !ok {
+					errs = append(errs, &FieldError{Path: join(path, key.Value), Line: key.Line, Msg: "unknown key"})
+					continue
+				}
+				errs = append(errs, unknownKeys(node.Content[i+1], ft, join(path, key.Value))...)
+			}
+		}
+	}
+
+	return errs
+}
+
+var (
+	textUnmarshaler = reflect.TypeFor[interface{ UnmarshalText([]byte) error }]()
+	yamlUnmarshaler = reflect.TypeFor[yaml.Unmarshaler]()
+)
+
+// yamlFields maps the keys of a struct to the field types the way yaml decodes them
+func yamlFields(t reflect.Type) map[string]reflect.Type {
+	fields := make(map[string]reflect.Type)
+
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if !f.IsExported() {
+			continue
+		}
+
+		tag := f.Tag.Get("yaml")
+		if tag == "-" {
+			continue
+		}
+
+		name, opts, _ := strings.Cut(tag, ",")
+		if opts == "inline" {
+			for k, ft := range yamlFields(f.Type) {
+				fields[k] = ft
+			}
+			continue
+		}
+
+		if name == "" {
+			name = strings.ToLower(f.Name)
+		}
+		fields[name] = f.Type
+	}
+
+	return fields
+}
+
+func join(path string, key string) string {
+	if path == "" {
+		return key
+	}
+
+	return path + "." + key
+}
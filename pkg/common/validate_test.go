@@ -0,0 +1,143 @@
+package common
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// a config valid on any machine, the tests add to it
+const baseConfig = `
+video-src:
+  source: videotestsrc
+`
+
+// parseTestConfig parses the file content with the defaults of all other options
+func parseTestConfig(t *testing.T, content string) *Config {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	c := &Config{}
+	if err := c.Parse([]string{"--config", path}); err != nil {
+		t.Fatal(err)
+	}
+
+	return c
+}
+
+// fieldErrors indexes the problems reported by Validate by their path
+func fieldErrors(t *testing.T, err error) map[string]*FieldError {
+	t.Helper()
+
+	errs := make(map[string]*FieldError)
+	if err == nil {
+		return errs
+	}
+
+	joined, ok := err.(interface{ Unwrap() []error })
+	if !ok {
+		t.Fatalf("expected joined errors, got %v", err)
+	}
+
+	for _, e := range joined.Unwrap() {
+		var fe *FieldError
+		if !errors.As(e, &fe) {
+			t.Fatalf("expected field errors only, got %v", e)
+		}
+		errs[fe.Path] = fe
+	}
+
+	return errs
+}
+
+func TestValidBaseConfig(t *testing.T) {
+	if err := parseTestConfig(t, baseConfig).Validate(); err != nil {
+		t.Fatalf("expected the defaults to be valid, got %s", err)
+	}
+}
+
+func TestUnknownKeys(t *testing.T) {
+	c := parseTestConfig(t, `
+video-src:
+  source: videotestsrc
+  colour: red
+notify:
+  ntfy:
+    - url: https://ntfy.sh
+      topic: door
+      title: "{{.Event}}"
+      priority: 3
+      prio: 3
+webhooks:
+  hooks:
+    - url: https://hooks.example
+      headers:
+        X-Anything: yes
+vidoe-src:
+  source: v4l2src
+`)
+
+	errs := fieldErrors(t, c.Validate())
+
+	for path, line := range map[string]int{
+		"video-src.colour":    4,
+		"notify.ntfy[0].prio": 11,
+		"vidoe-src":           17,
+	} {
+		fe, ok := errs[path]
+		if !ok {
+			t.Errorf("expected %s to be reported, got %v", path, errs)
+			continue
+		}
+		if fe.Line != line || fe.Msg != "unknown key" {
+			t.Errorf("%s: expected an unknown key in line %d, got %s", path, line, fe)
+		}
+	}
+
+	// the inline fields of the notifier and the keys of maps are taken
+	if len(errs) != 3 {
+		t.Fatalf("expected only the unknown keys, got %v", errs)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		content string
+		path    string
+	}{
+		{"audio codec", "audio-src:\n  codec: VP8\n", "audio-src.codec"},
+		{"ring key", "ring:\n  key: KEY_NOPE\n", "ring.key"},
+		{"tls cert without key", "http:\n  tls-cert: /etc/doorbell/cert.pem\n", "http.tls-cert"},
+		{"tls key without cert", "http:\n  tls-key: /etc/doorbell/key.pem\n", "http.tls-cert"},
+		{"hls part", "fallback:\n  hls-segment: 1\n  hls-part: 2s\n", "fallback.hls-part"},
+		{"ntfy url", "notify:\n  ntfy:\n    - url: ntfy.sh\n      topic: door\n", "notify.ntfy[0].url"},
+		{"webhook event", "webhooks:\n  hooks:\n    - url: https://hooks.example\n      events: [rang]\n", "webhooks.hooks[0].events[0]"},
+	} {
+		errs := fieldErrors(t, parseTestConfig(t, baseConfig+tc.content).Validate())
+
+		if _, ok := errs[tc.path]; !ok || len(errs) != 1 {
+			t.Errorf("%s: expected a problem with %s only, got %v", tc.name, tc.path, errs)
+		}
+	}
+}
+
+func TestAnnotatedRoundTrip(t *testing.T) {
+	b, err := parseTestConfig(t, baseConfig).Annotated()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c := parseTestConfig(t, string(b))
+	if len(c.unknown) > 0 {
+		t.Fatalf("expected the generated config to be read back, got %v", c.unknown)
+	}
+	if err := c.Validate(); err != nil {
+		t.Fatalf("expected the generated config to be valid, got %s", err)
+	}
+}
@@ -0,0 +1,80 @@
+package events
+
+import (
+	"context"
+	"sync"
+
+	"github.com/kaedwen/webrtc/pkg/metrics"
+	"go.uber.org/zap"
+)
+
+// events a subscriber may fall behind before they are dropped
+const DefaultQueueSize = 16
+
+type subscription struct {
+	name  string
+	kinds map[Kind]bool // all kinds when empty
+	ch    chan Event
+}
+
+// Bus hands the published events to the subscribers, every subscriber has its own
+// bounded queue so a slow one never blocks the publisher or the others
+type Bus struct {
+	lg   *zap.Logger
+	mu   *sync.RWMutex
+	subs map[*subscription]bool
+}
+
+func NewBus(lg *zap.Logger) *Bus {
+	return &Bus{
+		lg:   lg,
+		mu:   &sync.RWMutex{},
+		subs: make(map[*subscription]bool),
+	}
+}
+
+// Subscribe returns the events of the given kinds, all when none are given, until the context is done
+func (b *Bus) Subscribe(ctx context.Context, name string, size int, kinds ...Kind) <-chan Event {
+	s := subscription{
+		name:  name,
+		kinds: make(map[Kind]bool, len(kinds)),
+		ch:    make(chan Event, size),
+	}
+	for _, k := range kinds {
+		s.kinds[k] = true
+	}
+
+	b.mu.Lock()
+	b.subs[&s] = true
+	b.mu.Unlock()
+
+	go func() {
+		<-ctx.Done()
+
+		b.mu.Lock()
+		delete(b.subs, &s)
+		close(s.ch)
+		b.mu.Unlock()
+	}()
+
+	return s.ch
+}
+
+// Publish never blocks, events for a full queue are dropped
+func (b *Bus) Publish(e Event) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	for s := range b.subs {
+		if len(s.kinds) > 0 && !s.kinds[e.Kind()] {
+			continue
+		}
+
+		select {
+		case s.ch <- e:
+		default:
+			metrics.EventsDropped.WithLabelValues(s.name).Inc()
+			b.lg.Warn("event dropped", zap.String("subscriber", s.name), zap.String("kind", string(e.Kind())))
+		}
+	}
+}
@@ -0,0 +1,141 @@
+package events
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"go.uber.org/zap"
+)
+
+// dropped reads the drop counter of the subscriber
+func dropped(t *testing.T, name string) float64 {
+	t.Helper()
+
+	families, err := prometheus.DefaultGatherer.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, f := range families {
+		if f.GetName() != "doorbell_events_dropped_total" {
+			continue
+		}
+		for _, m := range f.GetMetric() {
+			for _, l := range m.GetLabel() {
+				if l.GetName() == "subscriber" && l.GetValue() == name {
+					return m.GetCounter().GetValue()
+				}
+			}
+		}
+	}
+
+	return 0
+}
+
+func receive(t *testing.T, ch <-chan Event) Event {
+	t.Helper()
+
+	select {
+	case e := <-ch:
+		return e
+	case <-time.After(time.Second):
+		t.Fatal("expected an event")
+		return nil
+	}
+}
+
+func TestBlockedSubscriber(t *testing.T) {
+	bus := NewBus(zap.NewNop())
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	blocked := bus.Subscribe(ctx, "test-blocked", 2)
+	other := bus.Subscribe(ctx, "test-other", 10)
+
+	// the counters are global
+	blockedBefore, otherBefore := dropped(t, "test-blocked"), dropped(t, "test-other")
+
+	// the blocked one never reads, publishing carries on regardless
+	done := make(chan struct{})
+	go func() {
+		for range 10 {
+			bus.Publish(NewRing())
+		}
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("expected publish not to block")
+	}
+
+	if n := dropped(t, "test-blocked") - blockedBefore; n != 8 {
+		t.Fatalf("expected the events beyond the queue to be dropped, got %g", n)
+	}
+	if n := dropped(t, "test-other") - otherBefore; n != 0 {
+		t.Fatalf("expected the other subscriber to drop nothing, got %g", n)
+	}
+
+	for range 10 {
+		receive(t, other)
+	}
+	if len(blocked) != 2 {
+		t.Fatalf("expected the queue to be full, got %d", len(blocked))
+	}
+}
+
+func TestKindFilter(t *testing.T) {
+	bus := NewBus(zap.NewNop())
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	rings := bus.Subscribe(ctx, "test-rings", DefaultQueueSize, KindRing)
+	all := bus.Subscribe(ctx, "test-all", DefaultQueueSize)
+
+	bus.Publish(NewMotion(true, 0.5))
+	bus.Publish(NewRing())
+
+	if e := receive(t, rings); e.Kind() != KindRing {
+		t.Fatalf("expected the ring only, got %s", e.Kind())
+	}
+	if len(rings) != 0 {
+		t.Fatalf("expected no further events, got %d", len(rings))
+	}
+
+	if e := receive(t, all); e.Kind() != KindMotionStart {
+		t.Fatalf("expected the motion first, got %s", e.Kind())
+	}
+	if e := receive(t, all); e.Kind() != KindRing {
+		t.Fatalf("expected the ring second, got %s", e.Kind())
+	}
+}
+
+func TestUnsubscribe(t *testing.T) {
+	bus := NewBus(zap.NewNop())
+	ctx, cancel := context.WithCancel(context.Background())
+
+	ch := bus.Subscribe(ctx, "test-unsubscribe", DefaultQueueSize)
+	cancel()
+
+	// the channel is closed once the subscription is gone
+	select {
+	case _, ok := <-ch:
+		if ok {
+			t.Fatal("expected no event")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expected the channel to be closed")
+	}
+
+	// publishing to the removed subscriber neither panics nor counts drops
+	bus.Publish(NewRing())
+
+	bus.mu.RLock()
+	defer bus.mu.RUnlock()
+	if len(bus.subs) != 0 {
+		t.Fatalf("expected the subscription to be removed, got %d", len(bus.subs))
+	}
+}
@@ -0,0 +1,166 @@
+package events
+
+import (
+	"slices"
+	"time"
+)
+
+type Kind string
+
+const (
+	KindRing             Kind = "ring"
+	KindMotionStart      Kind = "motion-start"
+	KindMotionStop       Kind = "motion-stop"
+	KindPeerConnected    Kind = "peer-connected"
+	KindPeerDisconnected Kind = "peer-disconnected"
+	KindPipelineError    Kind = "pipeline-error"
+	KindChime            Kind = "chime"
+	KindMute             Kind = "mute"
+)
+
+// Kinds are all kinds of events
+var Kinds = []Kind{KindRing, KindMotionStart, KindMotionStop, KindPeerConnected, KindPeerDisconnected, KindPipelineError, KindChime, KindMute}
+
+func (k Kind) Valid() bool {
+	return slices.Contains(Kinds, k)
+}
+
+type Event interface {
+	Kind() Kind
+	Time() time.Time
+}
+
+// Meta is embedded by all events
+type Meta struct {
+	At time.Time
+}
+
+func (m Meta) Time() time.Time {
+	return m.At
+}
+
+func now() Meta {
+	return Meta{At: time.Now()}
+}
+
+// Ring is published on a press of the ring button
+type Ring struct {
+	Meta
+}
+
+func NewRing() Ring {
+	return Ring{now()}
+}
+
+func (Ring) Kind() Kind {
+	return KindRing
+}
+
+// Motion is published when the detection starts or stops seeing motion
+type Motion struct {
+	Meta
+	Active bool
+	Ratio  float64 // share of changed pixels
+}
+
+func NewMotion(active bool, ratio float64) Motion {
+	return Motion{now(), active, ratio}
+}
+
+func (e Motion) Kind() Kind {
+	if e.Active {
+		return KindMotionStart
+	}
+	return KindMotionStop
+}
+
+// Peer is published when a viewer connects or disconnects
+type Peer struct {
+	Meta
+	Id        string
+	Connected bool
+}
+
+func NewPeer(id string, connected bool) Peer {
+	return Peer{now(), id, connected}
+}
+
+func (e Peer) Kind() Kind {
+	if e.Connected {
+		return KindPeerConnected
+	}
+	return KindPeerDisconnected
+}
+
+// PipelineError is published for errors on the bus of a pipeline
+type PipelineError struct {
+	Meta
+	Pipeline string
+	Err      error
+}
+
+func NewPipelineError(pipeline string, err error) PipelineError {
+	return PipelineError{now(), pipeline, err}
+}
+
+func (PipelineError) Kind() Kind {
+	return KindPipelineError
+}
+
+// Chime asks the players to play the jingle
+type Chime struct {
+	Meta
+}
+
+func NewChime() Chime {
+	return Chime{now()}
+}
+
+func (Chime) Kind() Kind {
+	return KindChime
+}
+
+// Mute silences or restores the speaker playing the audio of the peers
+type Mute struct {
+	Meta
+	Muted bool
+}
+
+func NewMute(muted bool) Mute {
+	return Mute{now(), muted}
+}
+
+func (Mute) Kind() Kind {
+	return KindMute
+}
+
+// Data describes an event for templates
+type Data struct {
+	Event     string
+	Time      time.Time
+	Timestamp string  // RFC 3339
+	Peer      string  // peer events
+	Pipeline  string  // pipeline errors
+	Error     string  // pipeline errors
+	Ratio     float64 // motion events
+}
+
+func NewData(e Event) Data {
+	d := Data{
+		Event:     string(e.Kind()),
+		Time:      e.Time(),
+		Timestamp: e.Time().Format(time.RFC3339),
+	}
+
+	switch ev := e.(type) {
+	case Peer:
+		d.Peer = ev.Id
+	case PipelineError:
+		d.Pipeline = ev.Pipeline
+		d.Error = ev.Err.Error()
+	case Motion:
+		d.Ratio = ev.Ratio
+	}
+
+	return d
+}
@@ -0,0 +1,300 @@
+// Package hls packages the parts written by the video pipeline into a low-latency hls playlist
+package hls
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// segments listed in the playlist, their parts are only listed for the last ones
+	playlistLength = 3
+	partSegments   = 2
+	// segments kept on disk after they left the playlist, clients may still load them
+	keptSegments = 3
+)
+
+var (
+	// ErrNotReady is returned when the playlist did not get the requested part in time
+	ErrNotReady = errors.New("playlist not ready")
+	// ErrTooFar is returned for a blocking request of a part far in the future
+	ErrTooFar = errors.New("requested part too far ahead")
+)
+
+type part struct {
+	name     string
+	duration time.Duration
+}
+
+type segment struct {
+	name     string
+	duration time.Duration
+	parts    []part
+}
+
+// Playlist collects the parts, every few of them are joined into a segment. The segments
+// are plain mpeg-ts, so a part is a valid stream on its own and the joined parts are too
+type Playlist struct {
+	mu      *sync.Mutex
+	changed chan struct{} // closed and replaced on every change
+
+	dir           string
+	partTarget    time.Duration
+	segmentTarget time.Duration
+
+	seq      int       // media sequence number of segments[0]
+	segments []segment // complete ones, including the ones left on disk only
+	parts    []part    // of the open segment
+	skip     bool      // the next part spans a pause of the stream
+}
+
+func NewPlaylist() *Playlist {
+	return &Playlist{
+		mu:      &sync.Mutex{},
+		changed: make(chan struct{}),
+	}
+}
+
+// Configure sets up the dir and durations and starts over
+func (p *Playlist) Configure(dir string, part time.Duration, segment time.Duration) error {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.reset()
+	p.dir, p.partTarget, p.segmentTarget = dir, part, segment
+	p.skip = false
+
+	return nil
+}
+
+// Reset drops the parts and segments, the next part written is ignored since it spans the time
+// the stream was stopped
+func (p *Playlist) Reset() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.reset()
+	p.skip = true
+}
+
+func (p *Playlist) reset() {
+	for _, s := range p.segments {
+		p.remove(s)
+	}
+	for _, pt := range p.parts {
+		_ = os.Remove(filepath.Join(p.dir, pt.name))
+	}
+
+	p.seq += len(p.segments) + 1
+	p.segments, p.parts = nil, nil
+	p.notify()
+}
+
+// AddPart takes the closed part file of the given name within the dir
+func (p *Playlist) AddPart(name string, duration time.Duration) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.skip {
+		p.skip = false
+		return os.Remove(filepath.Join(p.dir, name))
+	}
+
+	p.parts = append(p.parts, part{name: name, duration: duration})
+
+	var total time.Duration
+	for _, pt := range p.parts {
+		total += pt.duration
+	}
+
+	// a segment is closed once the next part would exceed the target
+	if total+p.partTarget/2 >= p.segmentTarget {
+		if err := p.closeSegment(total); err != nil {
+			return err
+		}
+	}
+
+	p.notify()
+
+	return nil
+}
+
+// closeSegment joins the parts of the open segment into its file
+func (p *Playlist) closeSegment(duration time.Duration) error {
+	msn := p.seq + len(p.segments)
+	s := segment{name: fmt.Sprintf("segment%05d.ts", msn), duration: duration, parts: p.parts}
+
+	if err := p.join(s); err != nil {
+		return fmt.Errorf("failed to write segment %s - %s", s.name, err)
+	}
+
+	p.segments = append(p.segments, s)
+	p.parts = nil
+
+	for len(p.segments) > playlistLength+keptSegments {
+		p.remove(p.segments[0])
+		p.segments = p.segments[1:]
+		p.seq++
+	}
+
+	return nil
+}
+
+// join writes the parts to a temporary file which replaces the segment at once
+func (p *Playlist) join(s segment) error {
+	path := filepath.Join(p.dir, s.name)
+	tmp := path + ".tmp"
+
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+
+	for _, pt := range s.parts {
+		if err = appendFile(f, filepath.Join(p.dir, pt.name)); err != nil {
+			break
+		}
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		_ = os.Remove(tmp)
+		return err
+	}
+
+	return os.Rename(tmp, path)
+}
+
+func appendFile(w io.Writer, path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	_, err = io.Copy(w, f)
+	return err
+}
+
+func (p *Playlist) remove(s segment) {
+	_ = os.Remove(filepath.Join(p.dir, s.name))
+	for _, pt := range s.parts {
+		_ = os.Remove(filepath.Join(p.dir, pt.name))
+	}
+}
+
+func (p *Playlist) notify() {
+	close(p.changed)
+	p.changed = make(chan struct{})
+}
+
+// listed returns the segments of the playlist and the sequence number of the first one
+func (p *Playlist) listed() ([]segment, int) {
+	n := max(len(p.segments)-playlistLength, 0)
+	return p.segments[n:], p.seq + n
+}
+
+// has tells whether the playlist contains the part of the segment, a negative part
+// asks for the complete segment
+func (p *Playlist) has(msn int, part int) bool {
+	open := p.seq + len(p.segments)
+	if part < 0 {
+		return msn < open
+	}
+
+	return msn < open || (msn == open && part < len(p.parts))
+}
+
+// Wait returns the playlist once it contains the part of the segment, see the _HLS_msn and
+// _HLS_part parameters of a blocking playlist reload. A negative msn waits for the first segment
+func (p *Playlist) Wait(ctx context.Context, msn int, part int, timeout time.Duration) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	for {
+		p.mu.Lock()
+		open := p.seq + len(p.segments)
+
+		var ready bool
+		switch {
+		case msn < 0:
+			ready = len(p.segments) > 0
+		case msn > open+2:
+			// the spec allows to refuse requests more than two segments ahead
+			p.mu.Unlock()
+			return nil, ErrTooFar
+		default:
+			ready = len(p.segments) > 0 && p.has(msn, part)
+		}
+
+		if ready {
+			b := p.render()
+			p.mu.Unlock()
+			return b, nil
+		}
+
+		changed := p.changed
+		p.mu.Unlock()
+
+		select {
+		case <-changed:
+		case <-ctx.Done():
+			return nil, ErrNotReady
+		}
+	}
+}
+
+// render writes the playlist, the parts are listed for the recent segments and the open one
+func (p *Playlist) render() []byte {
+	segments, seq := p.listed()
+
+	// every segment and part has to fit into the announced durations
+	target, partTarget := p.segmentTarget, p.partTarget
+	for _, s := range segments {
+		target = max(target, s.duration)
+		for _, pt := range s.parts {
+			partTarget = max(partTarget, pt.duration)
+		}
+	}
+	for _, pt := range p.parts {
+		partTarget = max(partTarget, pt.duration)
+	}
+
+	var b strings.Builder
+	b.WriteString("#EXTM3U\n")
+	b.WriteString("#EXT-X-VERSION:6\n")
+	fmt.Fprintf(&b, "#EXT-X-TARGETDURATION:%d\n", int(math.Ceil(target.Seconds())))
+	fmt.Fprintf(&b, "#EXT-X-SERVER-CONTROL:CAN-BLOCK-RELOAD=YES,PART-HOLD-BACK=%.3f\n", 3*partTarget.Seconds())
+	fmt.Fprintf(&b, "#EXT-X-PART-INF:PART-TARGET=%.3f\n", partTarget.Seconds())
+	fmt.Fprintf(&b, "#EXT-X-MEDIA-SEQUENCE:%d\n", seq)
+
+	for i, s := range segments {
+		if i >= len(segments)-partSegments {
+			writeParts(&b, s.parts)
+		}
+		fmt.Fprintf(&b, "#EXTINF:%.3f,\n%s\n", s.duration.Seconds(), s.name)
+	}
+	writeParts(&b, p.parts)
+
+	return []byte(b.String())
+}
+
+// writeParts lists the parts, each starts with a keyframe
+func writeParts(b *strings.Builder, parts []part) {
+	for _, pt := range parts {
+		fmt.Fprintf(b, "#EXT-X-PART:DURATION=%.3f,URI=%q,INDEPENDENT=YES\n", pt.duration.Seconds(), pt.name)
+	}
+}
@@ -0,0 +1,241 @@
+package hls
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newTestPlaylist(t *testing.T) (*Playlist, string) {
+	t.Helper()
+
+	dir := filepath.Join(t.TempDir(), "hls")
+	p := NewPlaylist()
+	if err := p.Configure(dir, 500*time.Millisecond, 2*time.Second); err != nil {
+		t.Fatal(err)
+	}
+
+	return p, dir
+}
+
+// writePart stands in for splitmuxsink closing a part
+func writePart(t *testing.T, p *Playlist, dir string, id int, duration time.Duration) string {
+	t.Helper()
+
+	name := fmt.Sprintf("part%05d.ts", id)
+	if err := os.WriteFile(filepath.Join(dir, name), []byte(name+";"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.AddPart(name, duration); err != nil {
+		t.Fatal(err)
+	}
+
+	return name
+}
+
+func render(t *testing.T, p *Playlist) string {
+	t.Helper()
+
+	b, err := p.Wait(context.Background(), -1, -1, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return string(b)
+}
+
+func TestSegmentsJoinParts(t *testing.T) {
+	p, dir := newTestPlaylist(t)
+
+	for i := range 5 {
+		writePart(t, p, dir, i, 500*time.Millisecond)
+	}
+
+	b, err := os.ReadFile(filepath.Join(dir, "segment00001.ts"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != "part00000.ts;part00001.ts;part00002.ts;part00003.ts;" {
+		t.Fatalf("expected the parts joined in order, got %s", b)
+	}
+
+	out := render(t, p)
+	for _, line := range []string{
+		"#EXT-X-VERSION:6",
+		"#EXT-X-TARGETDURATION:2",
+		"#EXT-X-SERVER-CONTROL:CAN-BLOCK-RELOAD=YES,PART-HOLD-BACK=1.500",
+		"#EXT-X-PART-INF:PART-TARGET=0.500",
+		"#EXT-X-MEDIA-SEQUENCE:1",
+		`#EXT-X-PART:DURATION=0.500,URI="part00003.ts",INDEPENDENT=YES`,
+		"#EXTINF:2.000,\nsegment00001.ts",
+		`#EXT-X-PART:DURATION=0.500,URI="part00004.ts",INDEPENDENT=YES`,
+	} {
+		if !strings.Contains(out, line) {
+			t.Errorf("expected %q in\n%s", line, out)
+		}
+	}
+
+	// the open part follows the last segment
+	if strings.Index(out, "segment00001.ts") > strings.Index(out, "part00004.ts") {
+		t.Fatalf("expected the open segment last\n%s", out)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "segment00001.ts.tmp")); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("expected no temporary file to be left, got %v", err)
+	}
+}
+
+func TestLongPartsRaiseTheTargets(t *testing.T) {
+	p, dir := newTestPlaylist(t)
+
+	// a passthrough camera sends keyframes less often than asked for
+	writePart(t, p, dir, 0, 2500*time.Millisecond)
+
+	out := render(t, p)
+	for _, line := range []string{"#EXT-X-TARGETDURATION:3", "PART-TARGET=2.500", "PART-HOLD-BACK=7.500"} {
+		if !strings.Contains(out, line) {
+			t.Errorf("expected %q in\n%s", line, out)
+		}
+	}
+}
+
+func TestOldSegmentsAreRemoved(t *testing.T) {
+	p, dir := newTestPlaylist(t)
+
+	for i := range 40 {
+		writePart(t, p, dir, i, 500*time.Millisecond)
+	}
+
+	out := render(t, p)
+	if strings.Count(out, "#EXTINF") != playlistLength {
+		t.Fatalf("expected %d segments listed\n%s", playlistLength, out)
+	}
+	if !strings.Contains(out, "#EXT-X-MEDIA-SEQUENCE:8\n") {
+		t.Fatalf("expected the sequence to move on\n%s", out)
+	}
+	// parts are only listed for the recent segments
+	if strings.Contains(out, "part00031.ts") || !strings.Contains(out, "part00032.ts") {
+		t.Fatalf("expected the parts of the last segments only\n%s", out)
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var segments, parts int
+	for _, e := range entries {
+		if strings.HasPrefix(e.Name(), "segment") {
+			segments++
+		} else {
+			parts++
+		}
+	}
+	if segments != playlistLength+keptSegments || parts != (playlistLength+keptSegments)*4 {
+		t.Fatalf("expected the old files to be removed, got %d segments and %d parts", segments, parts)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "part00000.ts")); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("expected the first part to be removed, got %v", err)
+	}
+}
+
+func TestResetSkipsThePause(t *testing.T) {
+	p, dir := newTestPlaylist(t)
+
+	for i := range 5 {
+		writePart(t, p, dir, i, 500*time.Millisecond)
+	}
+
+	p.Reset()
+
+	if entries, _ := os.ReadDir(dir); len(entries) != 0 {
+		t.Fatalf("expected the files to be removed, got %d", len(entries))
+	}
+
+	// the part open while the stream was stopped
+	skipped := writePart(t, p, dir, 5, time.Minute)
+	if _, err := os.Stat(filepath.Join(dir, skipped)); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("expected the part spanning the pause to be removed, got %v", err)
+	}
+
+	for i := 6; i < 10; i++ {
+		writePart(t, p, dir, i, 500*time.Millisecond)
+	}
+
+	out := render(t, p)
+	if strings.Contains(out, skipped) || !strings.Contains(out, "#EXT-X-MEDIA-SEQUENCE:3\n") || !strings.Contains(out, "segment00003.ts") {
+		t.Fatalf("expected a fresh playlist with a later sequence\n%s", out)
+	}
+}
+
+func TestWaitBlocksForThePart(t *testing.T) {
+	p, dir := newTestPlaylist(t)
+
+	for i := range 4 {
+		writePart(t, p, dir, i, 500*time.Millisecond)
+	}
+
+	// segment 2 is open without parts
+	if _, err := p.Wait(context.Background(), 2, -1, 20*time.Millisecond); !errors.Is(err, ErrNotReady) {
+		t.Fatalf("expected the segment not to be there, got %v", err)
+	}
+
+	res := make(chan string, 1)
+	go func() {
+		b, err := p.Wait(context.Background(), 2, 1, time.Second)
+		if err != nil {
+			res <- err.Error()
+			return
+		}
+		res <- string(b)
+	}()
+
+	writePart(t, p, dir, 4, 500*time.Millisecond)
+	select {
+	case out := <-res:
+		t.Fatalf("expected to wait for the second part, got\n%s", out)
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	writePart(t, p, dir, 5, 500*time.Millisecond)
+	if out := <-res; !strings.Contains(out, "part00005.ts") {
+		t.Fatalf("expected the playlist with the part, got\n%s", out)
+	}
+
+	// parts of earlier segments are there at once
+	if _, err := p.Wait(context.Background(), 0, 7, 20*time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestWaitRefusesFarAhead(t *testing.T) {
+	p, dir := newTestPlaylist(t)
+
+	for i := range 4 {
+		writePart(t, p, dir, i, 500*time.Millisecond)
+	}
+
+	if _, err := p.Wait(context.Background(), 5, -1, time.Second); !errors.Is(err, ErrTooFar) {
+		t.Fatalf("expected the request to be refused, got %v", err)
+	}
+}
+
+func TestWaitForFirstSegment(t *testing.T) {
+	p, dir := newTestPlaylist(t)
+
+	writePart(t, p, dir, 0, 500*time.Millisecond)
+
+	// a playlist without a segment does not help a client yet
+	if _, err := p.Wait(context.Background(), -1, -1, 20*time.Millisecond); !errors.Is(err, ErrNotReady) {
+		t.Fatalf("expected to wait for a segment, got %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := p.Wait(ctx, -1, -1, time.Second); !errors.Is(err, ErrNotReady) {
+		t.Fatalf("expected a gone client to stop waiting, got %v", err)
+	}
+}
@@ -0,0 +1,91 @@
+package metrics
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+const namespace = "doorbell"
+
+var (
+	PeersActive = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "peers_active",
+		Help:      "Number of connected webrtc peers.",
+	})
+
+	SamplesWritten = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "samples_written_total",
+		Help:      "Samples written to the peer tracks.",
+	}, []string{"track"})
+
+	SamplesDropped = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "samples_dropped_total",
+		Help:      "Samples dropped on the way from the pipelines to the peer tracks and branches.",
+	}, []string{"track"})
+
+	PipelineErrors = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "pipeline_errors_total",
+		Help:      "Errors reported on the gstreamer bus.",
+	}, []string{"pipeline"})
+
+	RingPresses = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "ring_presses_total",
+		Help:      "Presses of the ring button.",
+	})
+
+	MotionEvents = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "motion_events_total",
+		Help:      "Motion start and stop events of the detection.",
+	}, []string{"state"})
+
+	EventsDropped = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "events_dropped_total",
+		Help:      "Events dropped because the queue of the subscriber was full.",
+	}, []string{"subscriber"})
+
+	PushNotifications = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "push_notifications_total",
+		Help:      "Web push notifications sent to the subscriptions.",
+	}, []string{"result"})
+
+	Notifications = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "notifications_total",
+		Help:      "Notifications sent by the backends.",
+	}, []string{"backend", "result"})
+
+	SonosClips = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "sonos_clips_total",
+		Help:      "Audio clips sent to sonos players.",
+	}, []string{"result"})
+
+	VideoFormat = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "video_format_info",
+		Help:      "Format chosen from the capabilities of the camera.",
+	}, []string{"media", "format", "width", "height", "framerate"})
+
+	WebhookDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "webhook_duration_seconds",
+		Help:      "Latency of the triggered webhooks.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"hook", "result"})
+)
+
+func Result(err error) string {
+	if err != nil {
+		return "failure"
+	}
+
+	return "success"
+}
@@ -0,0 +1,122 @@
+package motion
+
+import (
+	"time"
+
+	"github.com/kaedwen/webrtc/pkg/common"
+)
+
+// size and rate of the frames the detection runs on
+const (
+	Width  = 160
+	Height = 120
+	Fps    = 5
+)
+
+type Event struct {
+	Active bool    // motion started or stopped
+	Ratio  float64 // share of changed pixels which caused the event
+	At     time.Time
+}
+
+type region struct {
+	x0, y0, x1, y1 int
+}
+
+// Detector compares every grayscale frame with the previous one
+type Detector struct {
+	cfg        *common.ConfigMotion
+	regions    []region
+	previous   []byte
+	active     bool
+	lastMotion time.Time
+}
+
+func NewDetector(cfg *common.ConfigMotion) *Detector {
+	d := Detector{cfg: cfg}
+
+	for _, r := range cfg.Regions {
+		d.regions = append(d.regions, region{
+			x0: clamp(int(r.X*Width), Width),
+			y0: clamp(int(r.Y*Height), Height),
+			x1: clamp(int((r.X+r.Width)*Width), Width),
+			y1: clamp(int((r.Y+r.Height)*Height), Height),
+		})
+	}
+
+	// without regions the whole frame counts
+	if len(d.regions) == 0 {
+		d.regions = []region{{0, 0, Width, Height}}
+	}
+
+	return &d
+}
+
+func clamp(v int, max int) int {
+	if v < 0 {
+		return 0
+	}
+	if v > max {
+		return max
+	}
+	return v
+}
+
+// Process takes a GRAY8 frame and returns an event when the state changed
+func (d *Detector) Process(frame []byte, at time.Time) *Event {
+	if len(frame) < Width*Height {
+		return nil
+	}
+
+	current := append([]byte(nil), frame[:Width*Height]...)
+	previous := d.previous
+	d.previous = current
+	if previous == nil {
+		return nil
+	}
+
+	ratio := d.changed(previous, current)
+
+	if ratio >= d.cfg.Threshold {
+		d.lastMotion = at
+		if !d.active {
+			d.active = true
+			return &Event{Active: true, Ratio: ratio, At: at}
+		}
+		return nil
+	}
+
+	// motion ends after the cool-down passed without any
+	if d.active && at.Sub(d.lastMotion) >= d.cfg.Cooldown {
+		d.active = false
+		return &Event{Active: false, Ratio: ratio, At: at}
+	}
+
+	return nil
+}
+
+// changed returns the share of pixels within the regions differing by more than the sensitivity
+func (d *Detector) changed(a []byte, b []byte) float64 {
+	var total, changed int
+	for _, r := range d.regions {
+		for y := r.y0; y < r.y1; y++ {
+			for x := r.x0; x < r.x1; x++ {
+				i := y*Width + x
+				diff := int(a[i]) - int(b[i])
+				if diff < 0 {
+					diff = -diff
+				}
+				if diff > int(d.cfg.Sensitivity) {
+					changed++
+				}
+				total++
+			}
+		}
+	}
+
+	if total == 0 {
+		return 0
+	}
+
+	return float64(changed) / float64(total)
+}
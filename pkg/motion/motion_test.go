@@ -0,0 +1,118 @@
+package motion
+
+import (
+	"testing"
+	"time"
+
+	"github.com/kaedwen/webrtc/pkg/common"
+)
+
+// frame returns a GRAY8 frame of the given brightness, the rectangle in pixels is set to fill
+func frame(bg byte, x0, y0, x1, y1 int, fill byte) []byte {
+	f := make([]byte, Width*Height)
+	for i := range f {
+		f[i] = bg
+	}
+	for y := y0; y < y1; y++ {
+		for x := x0; x < x1; x++ {
+			f[y*Width+x] = fill
+		}
+	}
+
+	return f
+}
+
+func TestFirstFrame(t *testing.T) {
+	d := NewDetector(&common.ConfigMotion{Sensitivity: 25, Threshold: 0.02, Cooldown: time.Second})
+
+	if ev := d.Process(frame(255, 0, 0, 0, 0, 0), time.Now()); ev != nil {
+		t.Fatalf("expected nothing without a previous frame, got %+v", ev)
+	}
+	if ev := d.Process(make([]byte, 10), time.Now()); ev != nil {
+		t.Fatalf("expected short frames to be skipped, got %+v", ev)
+	}
+}
+
+func TestThreshold(t *testing.T) {
+	// a quarter of the frame changes
+	for _, tc := range []struct {
+		threshold float64
+		start     bool
+	}{
+		{0.2, true},
+		{0.25, true},
+		{0.3, false},
+	} {
+		d := NewDetector(&common.ConfigMotion{Sensitivity: 25, Threshold: tc.threshold, Cooldown: time.Second})
+		d.Process(frame(100, 0, 0, 0, 0, 0), time.Now())
+
+		ev := d.Process(frame(100, 0, 0, Width/2, Height/2, 200), time.Now())
+		if tc.start != (ev != nil && ev.Active) {
+			t.Errorf("%g: expected start %t, got %+v", tc.threshold, tc.start, ev)
+		}
+		if ev != nil && ev.Ratio != 0.25 {
+			t.Errorf("%g: expected a ratio of 0.25, got %g", tc.threshold, ev.Ratio)
+		}
+	}
+}
+
+func TestSensitivity(t *testing.T) {
+	d := NewDetector(&common.ConfigMotion{Sensitivity: 25, Threshold: 0.5, Cooldown: time.Second})
+	d.Process(frame(100, 0, 0, 0, 0, 0), time.Now())
+
+	// a change by exactly the sensitivity does not count
+	if ev := d.Process(frame(125, 0, 0, 0, 0, 0), time.Now()); ev != nil {
+		t.Fatalf("expected no motion, got %+v", ev)
+	}
+	if ev := d.Process(frame(151, 0, 0, 0, 0, 0), time.Now()); ev == nil || !ev.Active {
+		t.Fatalf("expected motion, got %+v", ev)
+	}
+}
+
+func TestRegions(t *testing.T) {
+	// the left half only
+	d := NewDetector(&common.ConfigMotion{
+		Sensitivity: 25,
+		Threshold:   0.1,
+		Cooldown:    time.Second,
+		Regions:     []common.ConfigRegion{{X: 0, Y: 0, Width: 0.5, Height: 1}},
+	})
+	d.Process(frame(100, 0, 0, 0, 0, 0), time.Now())
+
+	if ev := d.Process(frame(100, Width/2, 0, Width, Height, 200), time.Now()); ev != nil {
+		t.Fatalf("expected the right half to be ignored, got %+v", ev)
+	}
+	if ev := d.Process(frame(100, 0, 0, Width/4, Height, 200), time.Now()); ev == nil || ev.Ratio != 0.5 {
+		t.Fatalf("expected half of the region to change, got %+v", ev)
+	}
+}
+
+func TestCooldown(t *testing.T) {
+	d := NewDetector(&common.ConfigMotion{Sensitivity: 25, Threshold: 0.02, Cooldown: 10 * time.Second})
+
+	still := frame(100, 0, 0, 0, 0, 0)
+	moved := frame(100, 0, 0, Width, Height/2, 200)
+	start := time.Unix(1000, 0)
+
+	d.Process(still, start)
+	if ev := d.Process(moved, start.Add(time.Second)); ev == nil || !ev.Active {
+		t.Fatalf("expected a start, got %+v", ev)
+	}
+	// every frame differs from its predecessor, motion goes on without a second start
+	if ev := d.Process(still, start.Add(2*time.Second)); ev != nil {
+		t.Fatalf("expected motion to go on, got %+v", ev)
+	}
+
+	// no stop before the cool-down after the last motion passed
+	if ev := d.Process(still, start.Add(11*time.Second)); ev != nil {
+		t.Fatalf("expected no stop within the cool-down, got %+v", ev)
+	}
+	ev := d.Process(still, start.Add(12*time.Second))
+	if ev == nil || ev.Active || !ev.At.Equal(start.Add(12*time.Second)) {
+		t.Fatalf("expected a stop, got %+v", ev)
+	}
+
+	if ev := d.Process(still, start.Add(30*time.Second)); ev != nil {
+		t.Fatalf("expected a single stop, got %+v", ev)
+	}
+}
@@ -0,0 +1,198 @@
+package mqtt
+
+import (
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/eclipse/paho.mqtt.golang/packets"
+)
+
+// testBroker is an embedded broker with just enough of mqtt 3.1.1 for one client,
+// it remembers the last payload of every topic published to it
+type testBroker struct {
+	ln net.Listener
+
+	mu       *sync.Mutex
+	conns    map[net.Conn]*sync.Mutex // write locks of the connected clients
+	subs     map[string][]net.Conn
+	last     map[string]string
+	retained map[string]bool
+	connects int
+}
+
+func newTestBroker(t *testing.T) *testBroker {
+	t.Helper()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	b := &testBroker{
+		ln:       ln,
+		mu:       &sync.Mutex{},
+		conns:    make(map[net.Conn]*sync.Mutex),
+		subs:     make(map[string][]net.Conn),
+		last:     make(map[string]string),
+		retained: make(map[string]bool),
+	}
+	t.Cleanup(b.close)
+
+	go func() {
+		for {
+			c, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go b.serve(c)
+		}
+	}()
+
+	return b
+}
+
+func (b *testBroker) url() string {
+	return "tcp://" + b.ln.Addr().String()
+}
+
+func (b *testBroker) close() {
+	_ = b.ln.Close()
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for c := range b.conns {
+		_ = c.Close()
+	}
+}
+
+func (b *testBroker) serve(c net.Conn) {
+	b.mu.Lock()
+	b.conns[c] = &sync.Mutex{}
+	b.mu.Unlock()
+
+	defer func() {
+		b.mu.Lock()
+		delete(b.conns, c)
+		b.mu.Unlock()
+		_ = c.Close()
+	}()
+
+	var will *packets.PublishPacket
+	for {
+		cp, err := packets.ReadPacket(c)
+		if err != nil {
+			// the will is only sent on an unexpected disconnect
+			if will != nil {
+				b.route(will)
+			}
+			return
+		}
+
+		switch p := cp.(type) {
+		case *packets.ConnectPacket:
+			if p.WillFlag {
+				will = packets.NewControlPacket(packets.Publish).(*packets.PublishPacket)
+				will.TopicName = p.WillTopic
+				will.Payload = p.WillMessage
+				will.Retain = p.WillRetain
+			}
+			b.mu.Lock()
+			b.connects++
+			b.mu.Unlock()
+			b.write(c, packets.NewControlPacket(packets.Connack))
+		case *packets.SubscribePacket:
+			ack := packets.NewControlPacket(packets.Suback).(*packets.SubackPacket)
+			ack.MessageID = p.MessageID
+			ack.ReturnCodes = make([]byte, len(p.Topics))
+			b.write(c, ack)
+
+			b.mu.Lock()
+			for _, topic := range p.Topics {
+				b.subs[topic] = append(b.subs[topic], c)
+			}
+			b.mu.Unlock()
+		case *packets.PublishPacket:
+			if p.Qos > 0 {
+				ack := packets.NewControlPacket(packets.Puback).(*packets.PubackPacket)
+				ack.MessageID = p.MessageID
+				b.write(c, ack)
+			}
+			b.route(p)
+		case *packets.PingreqPacket:
+			b.write(c, packets.NewControlPacket(packets.Pingresp))
+		case *packets.DisconnectPacket:
+			return
+		}
+	}
+}
+
+func (b *testBroker) write(c net.Conn, p packets.ControlPacket) {
+	b.mu.Lock()
+	lock := b.conns[c]
+	b.mu.Unlock()
+	if lock == nil {
+		return
+	}
+
+	lock.Lock()
+	defer lock.Unlock()
+	_ = p.Write(c)
+}
+
+// route keeps the payload and hands it to the subscribers with qos 0
+func (b *testBroker) route(p *packets.PublishPacket) {
+	b.mu.Lock()
+	b.last[p.TopicName] = string(p.Payload)
+	b.retained[p.TopicName] = p.Retain
+	subs := append([]net.Conn(nil), b.subs[p.TopicName]...)
+	b.mu.Unlock()
+
+	for _, c := range subs {
+		out := packets.NewControlPacket(packets.Publish).(*packets.PublishPacket)
+		out.TopicName = p.TopicName
+		out.Payload = p.Payload
+		b.write(c, out)
+	}
+}
+
+// send publishes to the subscribers as another client would
+func (b *testBroker) send(topic string, payload string) {
+	p := packets.NewControlPacket(packets.Publish).(*packets.PublishPacket)
+	p.TopicName = topic
+	p.Payload = []byte(payload)
+	b.route(p)
+}
+
+// subscribed tells whether the client subscribed the topic already
+func (b *testBroker) subscribed(topic string) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return len(b.subs[topic]) > 0
+}
+
+// payload returns the last payload of the topic and whether it was retained
+func (b *testBroker) payload(topic string) (string, bool, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	p, ok := b.last[topic]
+	return p, b.retained[topic], ok
+}
+
+// expect waits for the payload to show up on the topic
+func (b *testBroker) expect(t *testing.T, topic string, payload string) {
+	t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		p, _, _ := b.payload(topic)
+		if p == payload {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("expected %q on %s, got %q", payload, topic, p)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
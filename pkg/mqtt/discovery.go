@@ -0,0 +1,77 @@
+package mqtt
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// device all entities are grouped by in home assistant
+type device struct {
+	Identifiers  []string `json:"identifiers"`
+	Name         string   `json:"name"`
+	Manufacturer string   `json:"manufacturer"`
+	Model        string   `json:"model"`
+}
+
+// entity holds the discovery options used by the announced components
+type entity struct {
+	component string
+	object    string
+
+	Name              string  `json:"name"`
+	UniqueId          string  `json:"unique_id"`
+	Device            *device `json:"device"`
+	AvailabilityTopic string  `json:"availability_topic"`
+	DeviceClass       string  `json:"device_class,omitempty"`
+	Icon              string  `json:"icon,omitempty"`
+	StateTopic        string  `json:"state_topic,omitempty"`
+	CommandTopic      string  `json:"command_topic,omitempty"`
+	Topic             string  `json:"topic,omitempty"`
+	StateClass        string  `json:"state_class,omitempty"`
+	OffDelay          int     `json:"off_delay,omitempty"`
+	EntityCategory    string  `json:"entity_category,omitempty"`
+}
+
+func (h *MqttHandler) entities() []entity {
+	return []entity{
+		{component: "binary_sensor", object: "ring", Name: "Ring", Icon: "mdi:doorbell", StateTopic: h.topic(topicRing), OffDelay: 5},
+		{component: "binary_sensor", object: "motion", Name: "Motion", DeviceClass: "motion", StateTopic: h.topic(topicMotion)},
+		{component: "binary_sensor", object: "problem", Name: "Problem", DeviceClass: "problem", StateTopic: h.topic(topicProblem), EntityCategory: "diagnostic"},
+		{component: "sensor", object: "viewers", Name: "Viewers", Icon: "mdi:eye", StateTopic: h.topic(topicViewers), StateClass: "measurement"},
+		{component: "camera", object: "snapshot", Name: "Snapshot", Topic: h.topic(topicSnapshot)},
+		{component: "button", object: "chime", Name: "Chime", Icon: "mdi:bell-ring", CommandTopic: h.topic(topicChime)},
+		{component: "button", object: "take_snapshot", Name: "Take snapshot", Icon: "mdi:camera", CommandTopic: h.topic(topicTakeSnapshot)},
+		{component: "switch", object: "mute", Name: "Mute speaker", Icon: "mdi:volume-off", StateTopic: h.topic(topicMute), CommandTopic: h.topic(topicMute + topicSetSuffix)},
+		{component: "switch", object: "stream", Name: "Stream", Icon: "mdi:video", StateTopic: h.topic(topicStream), CommandTopic: h.topic(topicStream + topicSetSuffix)},
+	}
+}
+
+// announce publishes the home assistant discovery payloads, they are retained so
+// home assistant picks them up after a restart too
+func (h *MqttHandler) announce() error {
+	dev := device{
+		Identifiers:  []string{h.cfg.ClientId},
+		Name:         h.cfg.DeviceName,
+		Manufacturer: "kaedwen",
+		Model:        "webrtc doorbell",
+	}
+
+	for _, e := range h.entities() {
+		e.UniqueId = h.cfg.ClientId + "_" + e.object
+		e.Device = &dev
+		e.AvailabilityTopic = h.topic(topicAvailability)
+
+		payload, err := json.Marshal(e)
+		if err != nil {
+			return err
+		}
+
+		topic := fmt.Sprintf("%s/%s/%s/%s/config", h.cfg.DiscoveryPrefix, e.component, h.cfg.ClientId, e.object)
+		t := h.client.Publish(topic, 1, true, payload)
+		if err := wait(t); err != nil {
+			return fmt.Errorf("failed to announce %s - %s", e.object, err)
+		}
+	}
+
+	return nil
+}
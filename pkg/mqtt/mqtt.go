@@ -0,0 +1,324 @@
+package mqtt
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	paho "github.com/eclipse/paho.mqtt.golang"
+	"github.com/kaedwen/webrtc/pkg/common"
+	"github.com/kaedwen/webrtc/pkg/events"
+	"github.com/kaedwen/webrtc/pkg/server"
+	"go.uber.org/zap"
+)
+
+const (
+	payloadOnline  = "online"
+	payloadOffline = "offline"
+	payloadOn      = "ON"
+	payloadOff     = "OFF"
+
+	// time a publish may take before it is given up
+	publishTimeout = 5 * time.Second
+
+	// interval the readiness is published in
+	healthInterval = 30 * time.Second
+
+	// time the stream side gets to deliver a snapshot
+	snapshotTimeout = 10 * time.Second
+)
+
+// topics below the configured prefix
+const (
+	topicAvailability = "availability"
+	topicRing         = "ring"
+	topicMotion       = "motion"
+	topicProblem      = "problem"
+	topicViewers      = "viewers"
+	topicSnapshot     = "snapshot"
+	topicTakeSnapshot = "snapshot/take"
+	topicChime        = "chime"
+	topicMute         = "mute"
+	topicStream       = "stream"
+	topicSetSuffix    = "/set"
+)
+
+type MqttHandler struct {
+	ctx         context.Context // ends the pending snapshots
+	lg          *zap.Logger
+	cfg         *common.ConfigMQTT
+	bus         *events.Bus
+	health      *common.Health
+	removeCheck func()
+	snapshots   chan<- *server.SnapshotRequest
+	streams     chan<- *server.StreamRequest
+	client      paho.Client
+	commands    chan func()           // serializes the commands of the broker
+	viewers     int                   // connected peers
+	muted       bool                  // speaker, restored with the state on a reconnect
+	stream      *server.StreamRequest // keeps the stream running while switched on
+}
+
+func NewMqttHandler(ctx context.Context, lg *zap.Logger, cfg *common.ConfigMQTT, bus *events.Bus, health *common.Health, snapshots chan<- *server.SnapshotRequest, streams chan<- *server.StreamRequest) (<-chan struct{}, error) {
+	var wg sync.WaitGroup
+	if cfg.Broker == nil {
+		lg.Info("no mqtt broker configured")
+		return common.Done(&wg), nil
+	}
+
+	h := &MqttHandler{
+		ctx:       ctx,
+		lg:        lg,
+		cfg:       cfg,
+		bus:       bus,
+		health:    health,
+		snapshots: snapshots,
+		streams:   streams,
+		commands:  make(chan func(), 10),
+	}
+
+	opts := paho.NewClientOptions().
+		AddBroker(*cfg.Broker).
+		SetClientID(cfg.ClientId).
+		SetWill(h.topic(topicAvailability), payloadOffline, 1, true).
+		SetAutoReconnect(true).
+		SetConnectRetry(true).
+		SetOnConnectHandler(h.onConnect).
+		SetConnectionLostHandler(func(_ paho.Client, err error) {
+			h.lg.Warn("mqtt connection lost", zap.Error(err))
+		})
+	if cfg.Username != nil {
+		opts.SetUsername(*cfg.Username)
+	}
+	if cfg.Password != nil {
+		opts.SetPassword(*cfg.Password)
+	}
+
+	h.client = paho.NewClient(opts)
+
+	// the broker may come up later, the client keeps on trying meanwhile
+	h.client.Connect()
+
+	h.removeCheck = health.AddReadinessCheck("mqtt", func() error {
+		if !h.client.IsConnectionOpen() {
+			return errors.New("not connected to the broker")
+		}
+		return nil
+	})
+
+	// done once offline is published and the client disconnected
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		h.run(ctx, bus.Subscribe(ctx, "mqtt", events.DefaultQueueSize))
+	}()
+
+	return common.Done(&wg), nil
+}
+
+func (h *MqttHandler) topic(name string) string {
+	return h.cfg.Topic + "/" + name
+}
+
+// onConnect announces the device and (re)subscribes the commands on every connect
+func (h *MqttHandler) onConnect(c paho.Client) {
+	h.lg.Info("connected to mqtt broker", zap.String("broker", *h.cfg.Broker))
+
+	if h.cfg.Discovery {
+		if err := h.announce(); err != nil {
+			h.lg.Error("failed to publish discovery", zap.Error(err))
+		}
+	}
+
+	h.publish(topicAvailability, true, payloadOnline)
+
+	// the state belongs to the run loop, publishes before the connect are lost with the clean session
+	h.enqueue("state", h.publishState)
+
+	handlers := map[string]func(string){
+		topicChime:                   h.onChime,
+		topicTakeSnapshot:            h.onSnapshot,
+		topicMute + topicSetSuffix:   h.onMute,
+		topicStream + topicSetSuffix: h.onStream,
+	}
+
+	for name, handler := range handlers {
+		t := c.Subscribe(h.topic(name), 1, func(_ paho.Client, m paho.Message) {
+			payload := string(m.Payload())
+			h.enqueue(m.Topic(), func() { handler(payload) })
+		})
+		if err := wait(t); err != nil {
+			h.lg.Error("failed to subscribe", zap.String("topic", name), zap.Error(err))
+		}
+	}
+}
+
+// run publishes the events and executes the commands until the context is done
+func (h *MqttHandler) run(ctx context.Context, ch <-chan events.Event) {
+	ticker := time.NewTicker(healthInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case e := <-ch:
+			h.handleEvent(e)
+		case cmd := <-h.commands:
+			cmd()
+		case <-ticker.C:
+			h.publishHealth()
+		case <-ctx.Done():
+			h.removeCheck()
+			h.stopStream()
+
+			// the will is only sent on an unexpected disconnect
+			h.publish(topicAvailability, true, payloadOffline)
+			h.client.Disconnect(uint(publishTimeout.Milliseconds()))
+			return
+		}
+	}
+}
+
+func (h *MqttHandler) handleEvent(e events.Event) {
+	switch ev := e.(type) {
+	case events.Ring:
+		h.publish(topicRing, false, payloadOn)
+		go h.takeSnapshot()
+	case events.Motion:
+		h.publish(topicMotion, true, onOff(ev.Active))
+	case events.Peer:
+		if ev.Connected {
+			h.viewers++
+		} else if h.viewers > 0 {
+			h.viewers--
+		}
+		h.publish(topicViewers, true, fmt.Sprint(h.viewers))
+	case events.PipelineError:
+		h.publish(topicProblem, true, payloadOn)
+	case events.Mute:
+		h.muted = ev.Muted
+		h.publish(topicMute, true, onOff(ev.Muted))
+	}
+}
+
+// enqueue hands the command to the run loop, the router of the client must not wait for it
+func (h *MqttHandler) enqueue(name string, cmd func()) {
+	select {
+	case h.commands <- cmd:
+	default:
+		h.lg.Warn("dropping command, too many pending", zap.String("command", name))
+	}
+}
+
+func (h *MqttHandler) publishState() {
+	h.publish(topicViewers, true, fmt.Sprint(h.viewers))
+	h.publish(topicMute, true, onOff(h.muted))
+	h.publish(topicStream, true, onOff(h.stream != nil))
+	h.publishHealth()
+}
+
+func (h *MqttHandler) publishHealth() {
+	h.publish(topicProblem, true, onOff(!h.health.Readiness().Healthy))
+}
+
+func (h *MqttHandler) publish(name string, retained bool, payload any) {
+	t := h.client.Publish(h.topic(name), 1, retained, payload)
+	if err := wait(t); err != nil {
+		h.lg.Error("failed to publish", zap.String("topic", name), zap.Error(err))
+	}
+}
+
+// wait gives the token the publish timeout, running out of it is an error too
+func wait(t paho.Token) error {
+	if !t.WaitTimeout(publishTimeout) {
+		return errors.New("timed out")
+	}
+
+	return t.Error()
+}
+
+func (h *MqttHandler) onChime(string) {
+	h.bus.Publish(events.NewChime())
+}
+
+func (h *MqttHandler) onSnapshot(string) {
+	go h.takeSnapshot()
+}
+
+func (h *MqttHandler) onMute(payload string) {
+	h.bus.Publish(events.NewMute(payload == payloadOn))
+}
+
+func (h *MqttHandler) onStream(payload string) {
+	if payload != payloadOn {
+		h.stopStream()
+		return
+	}
+
+	if h.stream != nil {
+		return
+	}
+
+	// an hls consumer keeps the pipelines running, so viewers start instantly. The handover
+	// must not hold up the run loop, it is given up when switched off before
+	req := server.NewStreamRequest(server.StreamHLS)
+	go func() {
+		select {
+		case h.streams <- req:
+		case <-req.Done:
+		}
+	}()
+	h.stream = req
+
+	h.publish(topicStream, true, payloadOn)
+}
+
+func (h *MqttHandler) stopStream() {
+	if h.stream == nil {
+		return
+	}
+
+	close(h.stream.Done)
+	h.stream = nil
+
+	h.publish(topicStream, true, payloadOff)
+}
+
+// takeSnapshot publishes the current image for the camera entity
+func (h *MqttHandler) takeSnapshot() {
+	image, err := h.snapshot()
+	if err != nil {
+		h.lg.Error("failed to take snapshot", zap.Error(err))
+		return
+	}
+
+	h.publish(topicSnapshot, true, image)
+}
+
+// snapshot requests an image from the stream side, given up on shutdown or after the timeout
+func (h *MqttHandler) snapshot() ([]byte, error) {
+	ctx, cancel := context.WithTimeout(h.ctx, snapshotTimeout)
+	defer cancel()
+
+	req := server.SnapshotRequest{Reply: make(chan server.SnapshotReply, 1)}
+	select {
+	case h.snapshots <- &req:
+	case <-ctx.Done():
+		return nil, fmt.Errorf("snapshot not requested - %s", ctx.Err())
+	}
+
+	select {
+	case r := <-req.Reply:
+		return r.Image, r.Err
+	case <-ctx.Done():
+		return nil, fmt.Errorf("snapshot not delivered - %s", ctx.Err())
+	}
+}
+
+func onOff(b bool) string {
+	if b {
+		return payloadOn
+	}
+	return payloadOff
+}
@@ -0,0 +1,171 @@
+package mqtt
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/kaedwen/webrtc/pkg/common"
+	"github.com/kaedwen/webrtc/pkg/events"
+	"github.com/kaedwen/webrtc/pkg/server"
+	"go.uber.org/zap"
+)
+
+type testHandler struct {
+	broker    *testBroker
+	bus       *events.Bus
+	snapshots chan *server.SnapshotRequest
+	streams   chan *server.StreamRequest
+	cancel    context.CancelFunc
+	done      <-chan struct{}
+}
+
+func startTestHandler(t *testing.T) *testHandler {
+	t.Helper()
+
+	b := newTestBroker(t)
+	cfg := &common.ConfigMQTT{
+		Broker:          common.Ptr(b.url()),
+		ClientId:        "doorbell",
+		Topic:           "doorbell",
+		Discovery:       true,
+		DiscoveryPrefix: "homeassistant",
+		DeviceName:      "Doorbell",
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	th := &testHandler{
+		broker:    b,
+		bus:       events.NewBus(zap.NewNop()),
+		snapshots: make(chan *server.SnapshotRequest),
+		streams:   make(chan *server.StreamRequest),
+		cancel:    cancel,
+	}
+
+	var err error
+	th.done, err = NewMqttHandler(ctx, zap.NewNop(), cfg, th.bus, common.NewHealth(), th.snapshots, th.streams)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(th.stop)
+
+	// the commands are only taken once subscribed
+	for _, topic := range []string{"doorbell/chime", "doorbell/stream/set"} {
+		deadline := time.Now().Add(5 * time.Second)
+		for !b.subscribed(topic) {
+			if time.Now().After(deadline) {
+				t.Fatalf("%s was not subscribed", topic)
+			}
+			time.Sleep(5 * time.Millisecond)
+		}
+	}
+
+	return th
+}
+
+func (th *testHandler) stop() {
+	th.cancel()
+	<-th.done
+}
+
+func TestAnnouncesOnConnect(t *testing.T) {
+	th := startTestHandler(t)
+
+	th.broker.expect(t, "doorbell/availability", payloadOnline)
+	th.broker.expect(t, "doorbell/viewers", "0")
+	th.broker.expect(t, "doorbell/stream", payloadOff)
+
+	payload, retained, ok := th.broker.payload("homeassistant/binary_sensor/doorbell/ring/config")
+	if !ok || !retained {
+		t.Fatalf("expected the discovery of the ring to be retained, got %t and %t", ok, retained)
+	}
+
+	var e map[string]any
+	if err := json.Unmarshal([]byte(payload), &e); err != nil {
+		t.Fatal(err)
+	}
+	if e["state_topic"] != "doorbell/ring" || e["unique_id"] != "doorbell_ring" || e["availability_topic"] != "doorbell/availability" {
+		t.Fatalf("unexpected discovery %s", payload)
+	}
+}
+
+func TestPublishesEvents(t *testing.T) {
+	th := startTestHandler(t)
+
+	th.bus.Publish(events.NewPeer("a", true))
+	th.broker.expect(t, "doorbell/viewers", "1")
+
+	th.bus.Publish(events.NewMotion(true, 0.5))
+	th.broker.expect(t, "doorbell/motion", payloadOn)
+
+	th.bus.Publish(events.NewPeer("a", false))
+	th.broker.expect(t, "doorbell/viewers", "0")
+}
+
+func TestCommandsReachTheBus(t *testing.T) {
+	th := startTestHandler(t)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	ch := th.bus.Subscribe(ctx, "test", events.DefaultQueueSize, events.KindChime)
+
+	th.broker.send("doorbell/chime", "")
+
+	select {
+	case <-ch:
+	case <-time.After(5 * time.Second):
+		t.Fatal("chime did not reach the bus")
+	}
+}
+
+func TestStreamSwitchDoesNotBlock(t *testing.T) {
+	th := startTestHandler(t)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	ch := th.bus.Subscribe(ctx, "test", events.DefaultQueueSize, events.KindChime)
+
+	// nobody takes the stream request yet, the run loop goes on anyway
+	th.broker.send("doorbell/stream/set", payloadOn)
+	th.broker.expect(t, "doorbell/stream", payloadOn)
+
+	th.broker.send("doorbell/chime", "")
+	select {
+	case <-ch:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the run loop got stuck on the stream request")
+	}
+
+	var req *server.StreamRequest
+	select {
+	case req = <-th.streams:
+	case <-time.After(5 * time.Second):
+		t.Fatal("stream was not requested")
+	}
+	if req.Kind != server.StreamHLS {
+		t.Fatalf("expected a hls consumer, got %v", req.Kind)
+	}
+
+	th.broker.send("doorbell/stream/set", payloadOff)
+	th.broker.expect(t, "doorbell/stream", payloadOff)
+
+	select {
+	case <-req.Done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("stream was not released")
+	}
+}
+
+func TestStopPublishesOffline(t *testing.T) {
+	th := startTestHandler(t)
+	th.broker.expect(t, "doorbell/availability", payloadOnline)
+
+	// offline is published before the handler is done, a restart connects after it
+	th.stop()
+
+	payload, retained, _ := th.broker.payload("doorbell/availability")
+	if payload != payloadOffline || !retained {
+		t.Fatalf("expected a retained offline, got %q and %t", payload, retained)
+	}
+}
@@ -0,0 +1,264 @@
+package push
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/SherClockHolmes/webpush-go"
+	"github.com/kaedwen/webrtc/pkg/common"
+	"github.com/kaedwen/webrtc/pkg/events"
+	"github.com/kaedwen/webrtc/pkg/metrics"
+	"go.uber.org/zap"
+)
+
+const (
+	keysFile          = "vapid.json"
+	subscriptionsFile = "subscriptions.json"
+
+	// the app shows the live view at its root
+	liveViewUrl = "/"
+
+	sendTimeout = 10 * time.Second
+)
+
+// ErrInvalidSubscription is returned for subscriptions not taken, the store is not touched
+var ErrInvalidSubscription = errors.New("invalid subscription")
+
+type Subscription = webpush.Subscription
+
+type vapidKeys struct {
+	Public  string `json:"public"`
+	Private string `json:"private"`
+}
+
+// Service delivers web push notifications to the subscribed browsers of the app
+type Service struct {
+	lg     *zap.Logger
+	cfg    *common.ConfigPush
+	keys   vapidKeys
+	store  *store
+	client *http.Client
+}
+
+// NewService keeps the vapid keys and subscriptions in dir
+func NewService(lg *zap.Logger, cfg *common.ConfigPush, dir string) (*Service, error) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, err
+	}
+
+	keys, err := loadKeys(lg, filepath.Join(dir, keysFile))
+	if err != nil {
+		return nil, fmt.Errorf("failed to load vapid keys - %s", err)
+	}
+
+	st, err := loadStore(filepath.Join(dir, subscriptionsFile))
+	if err != nil {
+		return nil, fmt.Errorf("failed to load subscriptions - %s", err)
+	}
+
+	return &Service{lg: lg, cfg: cfg, keys: *keys, store: st, client: newClient()}, nil
+}
+
+// newClient only connects to public addresses, the endpoints are chosen by the browsers
+// and a name may resolve to anything
+func newClient() *http.Client {
+	dialer := &net.Dialer{
+		Timeout: sendTimeout,
+		Control: func(network, address string, _ syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			if ip := net.ParseIP(host); ip == nil || !common.PublicIP(ip) {
+				return fmt.Errorf("refusing to connect to %s", host)
+			}
+			return nil
+		},
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.DialContext = dialer.DialContext
+
+	return &http.Client{Transport: transport, Timeout: sendTimeout}
+}
+
+// checkEndpoint only accepts push services on the internet, anything else would make
+// the doorbell send requests to wherever a client wants on every ring
+func checkEndpoint(endpoint string) error {
+	u, err := url.Parse(endpoint)
+	if err != nil || !u.IsAbs() || u.Scheme != "https" || u.Hostname() == "" {
+		return fmt.Errorf("%w - endpoint must be an https url", ErrInvalidSubscription)
+	}
+
+	host := strings.ToLower(u.Hostname())
+	if host == "localhost" || strings.HasSuffix(host, ".localhost") {
+		return fmt.Errorf("%w - endpoint must not be local", ErrInvalidSubscription)
+	}
+	if ip := net.ParseIP(host); ip != nil && !common.PublicIP(ip) {
+		return fmt.Errorf("%w - endpoint must not be local", ErrInvalidSubscription)
+	}
+
+	return nil
+}
+
+// loadKeys reads the vapid keys, they are generated once since the subscriptions are bound to them
+func loadKeys(lg *zap.Logger, path string) (*vapidKeys, error) {
+	var keys vapidKeys
+
+	data, err := os.ReadFile(path)
+	if err == nil {
+		return &keys, json.Unmarshal(data, &keys)
+	}
+	if !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+
+	lg.Info("generating vapid keys", zap.String("path", path))
+	keys.Private, keys.Public, err = webpush.GenerateVAPIDKeys()
+	if err != nil {
+		return nil, err
+	}
+
+	data, err = json.Marshal(keys)
+	if err != nil {
+		return nil, err
+	}
+
+	return &keys, os.WriteFile(path, data, 0o600)
+}
+
+// PublicKey is the application server key the browsers subscribe with
+func (s *Service) PublicKey() string {
+	return s.keys.Public
+}
+
+func (s *Service) Subscribe(sub Subscription) error {
+	if sub.Endpoint == "" || sub.Keys.Auth == "" || sub.Keys.P256dh == "" {
+		return fmt.Errorf("%w - incomplete", ErrInvalidSubscription)
+	}
+	if err := checkEndpoint(sub.Endpoint); err != nil {
+		return err
+	}
+
+	s.lg.Info("new subscription", zap.String("endpoint", sub.Endpoint))
+	return s.store.Add(sub)
+}
+
+func (s *Service) Unsubscribe(endpoint string) error {
+	s.lg.Info("removed subscription", zap.String("endpoint", endpoint))
+	return s.store.Remove(endpoint)
+}
+
+// Watch notifies all subscriptions of the rings and, when configured, of motion until the context is done
+func (s *Service) Watch(ctx context.Context, bus *events.Bus) {
+	kinds := []events.Kind{events.KindRing}
+	if s.cfg.Motion {
+		kinds = append(kinds, events.KindMotionStart)
+	}
+
+	ch := bus.Subscribe(ctx, "push", events.DefaultQueueSize, kinds...)
+
+	go func() {
+		for e := range ch {
+			s.notify(ctx, e)
+		}
+	}()
+}
+
+// payload is understood by the angular service worker, a click on the
+// notification opens the app or focuses it and navigates to the live view
+type payload struct {
+	Notification notification `json:"notification"`
+}
+
+type notification struct {
+	Title              string `json:"title"`
+	Body               string `json:"body"`
+	Icon               string `json:"icon"`
+	Tag                string `json:"tag"`
+	Renotify           bool   `json:"renotify"`
+	RequireInteraction bool   `json:"requireInteraction"`
+	Timestamp          int64  `json:"timestamp"`
+	Data               struct {
+		OnActionClick map[string]action `json:"onActionClick"`
+	} `json:"data"`
+}
+
+type action struct {
+	Operation string `json:"operation"`
+	Url       string `json:"url"`
+}
+
+func newPayload(e events.Event) payload {
+	n := notification{
+		Title:     "Doorbell",
+		Body:      "Someone is at the door",
+		Icon:      "icons/icon-192x192.png",
+		Tag:       string(e.Kind()),
+		Renotify:  true,
+		Timestamp: e.Time().UnixMilli(),
+	}
+
+	if e.Kind() == events.KindRing {
+		n.RequireInteraction = true
+	} else {
+		n.Body = "Motion in front of the door"
+	}
+
+	n.Data.OnActionClick = map[string]action{
+		"default": {Operation: "navigateLastFocusedOrOpen", Url: liveViewUrl},
+	}
+
+	return payload{n}
+}
+
+func (s *Service) notify(ctx context.Context, e events.Event) {
+	message, err := json.Marshal(newPayload(e))
+	if err != nil {
+		s.lg.Error("failed to encode notification", zap.Error(err))
+		return
+	}
+
+	for _, sub := range s.store.List() {
+		err := s.send(ctx, message, &sub)
+		metrics.PushNotifications.WithLabelValues(metrics.Result(err)).Inc()
+		if err != nil {
+			s.lg.Error("failed to push", zap.String("endpoint", sub.Endpoint), zap.Error(err))
+		}
+	}
+}
+
+func (s *Service) send(ctx context.Context, message []byte, sub *Subscription) error {
+	res, err := webpush.SendNotificationWithContext(ctx, message, sub, &webpush.Options{
+		HTTPClient:      s.client,
+		Subscriber:      s.cfg.Subject,
+		VAPIDPublicKey:  s.keys.Public,
+		VAPIDPrivateKey: s.keys.Private,
+		TTL:             int(s.cfg.TTL.Seconds()),
+		Urgency:         webpush.UrgencyHigh,
+	})
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	switch {
+	case res.StatusCode == http.StatusNotFound || res.StatusCode == http.StatusGone:
+		// the browser dropped the subscription
+		return s.store.Remove(sub.Endpoint)
+	case res.StatusCode >= 400:
+		return fmt.Errorf("received wrong status code - %d", res.StatusCode)
+	}
+
+	return nil
+}
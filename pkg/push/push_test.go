@@ -0,0 +1,204 @@
+package push
+
+import (
+	"context"
+	"crypto/ecdh"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/SherClockHolmes/webpush-go"
+	"github.com/kaedwen/webrtc/pkg/common"
+	"github.com/kaedwen/webrtc/pkg/events"
+	"go.uber.org/zap"
+)
+
+func newTestService(t *testing.T, dir string) *Service {
+	t.Helper()
+
+	s, err := NewService(zap.NewNop(), &common.ConfigPush{Subject: "mailto:doorbell@example.org", TTL: time.Minute}, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return s
+}
+
+// newSubscription creates the keys a browser would subscribe with
+func newSubscription(t *testing.T, endpoint string) Subscription {
+	t.Helper()
+
+	key, err := ecdh.P256().GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	auth := make([]byte, 16)
+	if _, err := rand.Read(auth); err != nil {
+		t.Fatal(err)
+	}
+
+	return Subscription{
+		Endpoint: endpoint,
+		Keys: webpush.Keys{
+			P256dh: base64.RawURLEncoding.EncodeToString(key.PublicKey().Bytes()),
+			Auth:   base64.RawURLEncoding.EncodeToString(auth),
+		},
+	}
+}
+
+func TestKeysArePersisted(t *testing.T) {
+	dir := t.TempDir()
+
+	first := newTestService(t, dir)
+	if first.PublicKey() == "" {
+		t.Fatal("expected a public key")
+	}
+
+	info, err := os.Stat(filepath.Join(dir, keysFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0o600 {
+		t.Fatalf("expected the keys to be private, got %s", info.Mode().Perm())
+	}
+
+	// the subscriptions are bound to the keys, a restart keeps them
+	if second := newTestService(t, dir); second.PublicKey() != first.PublicKey() {
+		t.Fatal("expected the keys to be loaded again")
+	}
+}
+
+func TestStoreRoundTrip(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, subscriptionsFile)
+
+	st, err := loadStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	a := newSubscription(t, "https://push.example/a")
+	b := newSubscription(t, "https://push.example/b")
+	for _, sub := range []Subscription{a, b, a} {
+		if err := st.Add(sub); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if _, err := os.Stat(path + ".tmp"); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("expected no temporary file to be left, got %v", err)
+	}
+
+	loaded, err := loadStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if subs := loaded.List(); len(subs) != 2 || loaded.subs[b.Endpoint] != b {
+		t.Fatalf("expected both subscriptions once, got %v", subs)
+	}
+
+	if err := loaded.Remove(a.Endpoint); err != nil {
+		t.Fatal(err)
+	}
+	if err := loaded.Remove("https://push.example/unknown"); err != nil {
+		t.Fatal(err)
+	}
+
+	loaded, err = loadStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if subs := loaded.List(); len(subs) != 1 || subs[0].Endpoint != b.Endpoint {
+		t.Fatalf("expected only b to be left, got %v", subs)
+	}
+}
+
+func TestSubscribeChecksEndpoint(t *testing.T) {
+	s := newTestService(t, t.TempDir())
+
+	for endpoint, ok := range map[string]bool{
+		"https://fcm.googleapis.com/fcm/send/abc": true,
+		"https://198.51.100.20/push":              true,
+		"http://push.example/a":                   false,
+		"/push":                                   false,
+		"https://":                                false,
+		"https://localhost/push":                  false,
+		"https://push.localhost/push":             false,
+		"https://127.0.0.1/push":                  false,
+		"https://[::1]/push":                      false,
+		"https://192.168.1.2/push":                false,
+		"https://10.0.0.1:8443/push":              false,
+		"https://169.254.169.254/latest":          false,
+		"https://[fe80::1]/push":                  false,
+	} {
+		err := s.Subscribe(newSubscription(t, endpoint))
+		if ok && err != nil {
+			t.Errorf("%s: expected to be taken, got %s", endpoint, err)
+		}
+		if !ok && !errors.Is(err, ErrInvalidSubscription) {
+			t.Errorf("%s: expected to be rejected, got %v", endpoint, err)
+		}
+	}
+
+	if n := len(s.store.List()); n != 2 {
+		t.Fatalf("expected the valid subscriptions only, got %d", n)
+	}
+
+	if err := s.Subscribe(Subscription{Endpoint: "https://push.example/a"}); !errors.Is(err, ErrInvalidSubscription) {
+		t.Fatalf("expected a subscription without keys to be rejected, got %v", err)
+	}
+}
+
+func TestClientRefusesLocalAddresses(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer srv.Close()
+
+	// names pass the endpoint check whatever they resolve to, the dial is checked again
+	if _, err := newClient().Get(srv.URL); err == nil || !strings.Contains(err.Error(), "refusing to connect") {
+		t.Fatalf("expected the connection to be refused, got %v", err)
+	}
+}
+
+func TestSendDropsGoneSubscriptions(t *testing.T) {
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/gone":
+			w.WriteHeader(http.StatusGone)
+		case "/unknown":
+			w.WriteHeader(http.StatusNotFound)
+		case "/broken":
+			w.WriteHeader(http.StatusInternalServerError)
+		default:
+			w.WriteHeader(http.StatusCreated)
+		}
+	}))
+	defer srv.Close()
+
+	s := newTestService(t, t.TempDir())
+	s.client = srv.Client()
+
+	// the test endpoints are local, they are added past the check
+	for _, path := range []string{"/ok", "/gone", "/unknown", "/broken"} {
+		if err := s.store.Add(newSubscription(t, srv.URL+path)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	s.notify(context.Background(), events.NewRing())
+
+	left := make(map[string]bool)
+	for _, sub := range s.store.List() {
+		left[sub.Endpoint] = true
+	}
+	if len(left) != 2 || !left[srv.URL+"/ok"] || !left[srv.URL+"/broken"] {
+		t.Fatalf("expected the gone subscriptions to be dropped, left %v", left)
+	}
+}
@@ -0,0 +1,95 @@
+package push
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"sync"
+
+	"github.com/SherClockHolmes/webpush-go"
+)
+
+// store keeps the subscriptions by endpoint in a json file
+type store struct {
+	mu   *sync.Mutex
+	path string
+	subs map[string]webpush.Subscription
+}
+
+func loadStore(path string) (*store, error) {
+	s := store{
+		mu:   &sync.Mutex{},
+		path: path,
+		subs: make(map[string]webpush.Subscription),
+	}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return &s, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var subs []webpush.Subscription
+	if err := json.Unmarshal(data, &subs); err != nil {
+		return nil, err
+	}
+
+	for _, sub := range subs {
+		s.subs[sub.Endpoint] = sub
+	}
+
+	return &s, nil
+}
+
+func (s *store) Add(sub webpush.Subscription) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.subs[sub.Endpoint] = sub
+	return s.save()
+}
+
+func (s *store) Remove(endpoint string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.subs[endpoint]; !ok {
+		return nil
+	}
+
+	delete(s.subs, endpoint)
+	return s.save()
+}
+
+func (s *store) List() []webpush.Subscription {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.list()
+}
+
+func (s *store) list() []webpush.Subscription {
+	subs := make([]webpush.Subscription, 0, len(s.subs))
+	for _, sub := range s.subs {
+		subs = append(subs, sub)
+	}
+
+	return subs
+}
+
+// save replaces the file at once, so a crash never leaves half of it
+func (s *store) save() error {
+	data, err := json.MarshalIndent(s.list(), "", "  ")
+	if err != nil {
+		return err
+	}
+
+	tmp := s.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp, s.path)
+}
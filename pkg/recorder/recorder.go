@@ -0,0 +1,285 @@
+package recorder
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/go-gst/go-gst/gst"
+	"github.com/kaedwen/webrtc/pkg/common"
+	"github.com/kaedwen/webrtc/pkg/events"
+	"github.com/kaedwen/webrtc/pkg/streamer"
+	"go.uber.org/zap"
+)
+
+// upper bound of buffered samples when the stream carries no keyframes
+const maxBufferedSamples = 16384
+
+// file names of the recordings, milliseconds keep two recordings of the same second apart
+const nameLayout = "20060102-150405.000"
+
+type sample struct {
+	video    bool
+	keyframe bool
+	data     []byte
+	duration time.Duration
+	at       time.Time
+}
+
+// recordPipeline writes a recording, see streamer.RecordPipeline
+type recordPipeline interface {
+	PushVideo(data []byte, pts time.Duration, duration time.Duration) error
+	PushAudio(data []byte, pts time.Duration, duration time.Duration) error
+	Finish() error
+}
+
+type recording struct {
+	pipeline recordPipeline
+	path     string
+	start    time.Time // arrival of the first keyframe, zero until there is one
+	until    time.Time
+}
+
+// Recorder keeps the last seconds of the encoded stream and writes them together
+// with the following seconds to a file whenever it is triggered
+type Recorder struct {
+	lg       *zap.Logger
+	cfg      *common.ConfigRecording
+	codec    common.StreamCodec
+	rtp      bool
+	channels uint
+	mu       *sync.Mutex
+	buffer   []sample
+	active   *recording
+	now      func() time.Time                          // replaced by the tests
+	open     func(path string) (recordPipeline, error) // replaced by the tests
+}
+
+func NewRecorder(lg *zap.Logger, cfg *common.ConfigRecording, video *common.ConfigVideoSourceStream, audio *common.ConfigAudioSourceStream) (*Recorder, error) {
+	if audio.Codec != common.OPUS {
+		return nil, fmt.Errorf("unsupported recording audio codec given - %s", audio.Codec)
+	}
+
+	if err := os.MkdirAll(cfg.Dir.String(), 0o755); err != nil {
+		return nil, err
+	}
+
+	r := Recorder{
+		lg:       lg,
+		cfg:      cfg,
+		codec:    video.Codec,
+		rtp:      video.Passthrough,
+		channels: audio.Channels,
+		mu:       &sync.Mutex{},
+		now:      time.Now,
+	}
+	r.open = r.openPipeline
+
+	// apply the retention once for what is left from the last run
+	r.cleanup()
+
+	return &r, nil
+}
+
+// PushVideo taps an encoded video sample, on passthrough a rtp packet
+func (r *Recorder) PushVideo(data []byte, duration time.Duration, keyframe bool) {
+	r.push(sample{video: true, keyframe: keyframe, data: data, duration: duration, at: r.now()})
+}
+
+// PushAudio taps an encoded audio sample
+func (r *Recorder) PushAudio(data []byte, duration time.Duration) {
+	r.push(sample{data: data, duration: duration, at: r.now()})
+}
+
+func (r *Recorder) push(s sample) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.buffer = append(r.buffer, s)
+	r.trim(s.at.Add(-r.cfg.PreRoll))
+
+	if r.active != nil {
+		r.write(r.active, s)
+	}
+}
+
+// trim drops everything before the last keyframe older than limit, so the
+// buffer always covers the pre-roll and starts with a keyframe
+func (r *Recorder) trim(limit time.Time) {
+	cut := -1
+	for i, s := range r.buffer {
+		if s.at.After(limit) {
+			break
+		}
+		if s.video && s.keyframe {
+			cut = i
+		}
+	}
+
+	if cut < 0 && len(r.buffer) > maxBufferedSamples {
+		cut = len(r.buffer) - maxBufferedSamples
+	}
+
+	if cut > 0 {
+		r.buffer = append(r.buffer[:0], r.buffer[cut:]...)
+	}
+}
+
+// write hands the sample to the pipeline, nothing is written before the first keyframe
+func (r *Recorder) write(rec *recording, s sample) {
+	if rec.start.IsZero() {
+		if !s.video || !s.keyframe {
+			return
+		}
+		rec.start = s.at
+	}
+
+	if s.at.Before(rec.start) {
+		return
+	}
+
+	var err error
+	if s.video {
+		err = rec.pipeline.PushVideo(s.data, s.at.Sub(rec.start), s.duration)
+	} else {
+		err = rec.pipeline.PushAudio(s.data, s.at.Sub(rec.start), s.duration)
+	}
+	if err != nil {
+		r.lg.Error("failed to write recording", zap.String("path", rec.path), zap.Error(err))
+	}
+}
+
+// Watch starts a recording for every ring and motion until the context is done
+func (r *Recorder) Watch(ctx context.Context, bus *events.Bus) {
+	ch := bus.Subscribe(ctx, "recorder", events.DefaultQueueSize, events.KindRing, events.KindMotionStart)
+
+	go func() {
+		for e := range ch {
+			if err := r.Trigger(); err != nil {
+				r.lg.Error("failed to record", zap.String("event", string(e.Kind())), zap.Error(err))
+			}
+		}
+	}()
+}
+
+// Trigger starts a recording of the pre-roll and the following seconds,
+// a trigger during a recording extends it instead
+func (r *Recorder) Trigger() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := r.now()
+	until := now.Add(r.cfg.PostRoll)
+
+	if r.active != nil {
+		r.active.until = until
+		r.lg.Info("extended recording", zap.String("path", r.active.path))
+		return nil
+	}
+
+	path := filepath.Join(r.cfg.Dir.String(), now.Format(nameLayout)+streamer.RecordingExtension(r.codec))
+
+	pipeline, err := r.open(path)
+	if err != nil {
+		return err
+	}
+
+	rec := &recording{pipeline: pipeline, path: path, until: until}
+	for _, s := range r.buffer {
+		r.write(rec, s)
+	}
+
+	r.active = rec
+	r.lg.Info("started recording", zap.String("path", path))
+
+	go r.finish(rec)
+
+	return nil
+}
+
+// openPipeline starts the pipeline writing the recording to path
+func (r *Recorder) openPipeline(path string) (recordPipeline, error) {
+	pipeline, err := streamer.CreateRecordPipeline(r.lg, r.codec, r.rtp, r.channels, path)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := pipeline.SetState(gst.StatePlaying); err != nil {
+		return nil, err
+	}
+
+	return pipeline, nil
+}
+
+// finish waits for the end of the recording, it finalizes the file and applies the retention
+func (r *Recorder) finish(rec *recording) {
+	for {
+		r.mu.Lock()
+		d := rec.until.Sub(r.now())
+		if d <= 0 {
+			r.active = nil
+			r.mu.Unlock()
+			break
+		}
+		r.mu.Unlock()
+
+		time.Sleep(d)
+	}
+
+	if err := rec.pipeline.Finish(); err != nil {
+		r.lg.Error("failed to finish recording", zap.String("path", rec.path), zap.Error(err))
+	} else {
+		r.lg.Info("finished recording", zap.String("path", rec.path))
+	}
+
+	r.cleanup()
+}
+
+// cleanup removes the recordings exceeding the configured count, size or age, newest are kept
+func (r *Recorder) cleanup() {
+	entries, err := os.ReadDir(r.cfg.Dir.String())
+	if err != nil {
+		r.lg.Error("failed to list recordings", zap.Error(err))
+		return
+	}
+
+	files := make([]os.FileInfo, 0, len(entries))
+	for _, e := range entries {
+		if ext := filepath.Ext(e.Name()); e.IsDir() || (ext != ".webm" && ext != ".mp4") {
+			continue
+		}
+
+		info, err := e.Info()
+		if err != nil {
+			continue
+		}
+		files = append(files, info)
+	}
+
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].ModTime().After(files[j].ModTime())
+	})
+
+	var size int64
+	for i, f := range files {
+		size += f.Size()
+
+		expired := (r.cfg.MaxCount > 0 && i >= r.cfg.MaxCount) ||
+			(r.cfg.MaxSizeMB > 0 && size > r.cfg.MaxSizeMB*1024*1024) ||
+			(r.cfg.MaxAge > 0 && r.now().Sub(f.ModTime()) > r.cfg.MaxAge)
+		if !expired {
+			continue
+		}
+
+		path := filepath.Join(r.cfg.Dir.String(), f.Name())
+		if err := os.Remove(path); err != nil {
+			r.lg.Error("failed to remove recording", zap.String("path", path), zap.Error(err))
+			continue
+		}
+		r.lg.Info("removed recording", zap.String("path", path))
+	}
+}
@@ -0,0 +1,275 @@
+package recorder
+
+import (
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/kaedwen/webrtc/pkg/common"
+	"go.uber.org/zap"
+)
+
+type fakeClock struct {
+	mu *sync.Mutex
+	t  time.Time
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.t
+}
+
+func (c *fakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.t = c.t.Add(d)
+}
+
+type written struct {
+	video bool
+	data  string
+	pts   time.Duration
+}
+
+// fakePipeline records what a recording pipeline would write
+type fakePipeline struct {
+	mu       *sync.Mutex
+	path     string
+	samples  []written
+	finished chan struct{}
+}
+
+func (p *fakePipeline) PushVideo(data []byte, pts time.Duration, duration time.Duration) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.samples = append(p.samples, written{true, string(data), pts})
+	return nil
+}
+
+func (p *fakePipeline) PushAudio(data []byte, pts time.Duration, duration time.Duration) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.samples = append(p.samples, written{false, string(data), pts})
+	return nil
+}
+
+func (p *fakePipeline) Finish() error {
+	close(p.finished)
+	return nil
+}
+
+func (p *fakePipeline) written() []written {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return append([]written(nil), p.samples...)
+}
+
+// newTestRecorder returns a recorder on a fake clock, the opened pipelines are handed to the channel
+func newTestRecorder(t *testing.T, cfg *common.ConfigRecording) (*Recorder, *fakeClock, <-chan *fakePipeline) {
+	t.Helper()
+
+	if cfg.Dir.String() == "" {
+		if err := cfg.Dir.UnmarshalText([]byte(t.TempDir())); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	r, err := NewRecorder(zap.NewNop(), cfg, &common.ConfigVideoSourceStream{Codec: common.VP8}, &common.ConfigAudioSourceStream{Codec: common.OPUS, Channels: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	clock := &fakeClock{mu: &sync.Mutex{}, t: time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)}
+	r.now = clock.Now
+
+	opened := make(chan *fakePipeline, 10)
+	r.open = func(path string) (recordPipeline, error) {
+		p := &fakePipeline{mu: &sync.Mutex{}, path: path, finished: make(chan struct{})}
+		opened <- p
+		return p, nil
+	}
+
+	return r, clock, opened
+}
+
+func TestPreRollStartsWithKeyframe(t *testing.T) {
+	r, clock, _ := newTestRecorder(t, &common.ConfigRecording{PreRoll: 2 * time.Second})
+
+	// a keyframe every 1.5s, audio in between
+	for i := range 10 {
+		if i%3 == 0 {
+			r.PushVideo([]byte{byte(i)}, 500*time.Millisecond, true)
+		} else {
+			r.PushAudio([]byte{byte(i)}, 500*time.Millisecond)
+		}
+		clock.Advance(500 * time.Millisecond)
+	}
+
+	// the last sample arrived at 4.5s, the keyframe at 1.5s is the last one at least 2s before
+	if first := r.buffer[0]; !first.video || !first.keyframe || first.data[0] != 3 {
+		t.Fatalf("expected the buffer to start with the keyframe at 1.5s, got %+v", first)
+	}
+	if n := len(r.buffer); n != 7 {
+		t.Fatalf("expected 7 samples, got %d", n)
+	}
+}
+
+func TestBufferWithoutKeyframes(t *testing.T) {
+	r, _, _ := newTestRecorder(t, &common.ConfigRecording{PreRoll: time.Hour})
+
+	for range maxBufferedSamples + 10 {
+		r.PushAudio([]byte{0}, time.Millisecond)
+	}
+
+	if n := len(r.buffer); n != maxBufferedSamples {
+		t.Fatalf("expected the buffer to be bounded, got %d", n)
+	}
+}
+
+func TestTriggerWritesPreRoll(t *testing.T) {
+	r, clock, opened := newTestRecorder(t, &common.ConfigRecording{PreRoll: 5 * time.Second, PostRoll: time.Hour})
+
+	r.PushAudio([]byte("a0"), 0)
+	clock.Advance(time.Second)
+	r.PushVideo([]byte("k1"), 0, true)
+	clock.Advance(time.Second)
+	r.PushAudio([]byte("a2"), 0)
+
+	if err := r.Trigger(); err != nil {
+		t.Fatal(err)
+	}
+	p := <-opened
+
+	clock.Advance(time.Second)
+	r.PushVideo([]byte("v3"), 0, false)
+
+	// the audio before the first keyframe is left out, timestamps start at the keyframe
+	want := []written{{true, "k1", 0}, {false, "a2", time.Second}, {true, "v3", 2 * time.Second}}
+	got := p.written()
+	if len(got) != len(want) {
+		t.Fatalf("expected %v, got %v", want, got)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("expected %v, got %v", want, got)
+		}
+	}
+
+	if filepath.Base(p.path) != "20240501-120002.000.webm" {
+		t.Fatalf("unexpected path %s", p.path)
+	}
+}
+
+func TestTriggerExtends(t *testing.T) {
+	r, clock, opened := newTestRecorder(t, &common.ConfigRecording{PostRoll: 20 * time.Millisecond})
+	r.PushVideo([]byte("k"), 0, true)
+
+	if err := r.Trigger(); err != nil {
+		t.Fatal(err)
+	}
+	p := <-opened
+
+	clock.Advance(15 * time.Millisecond)
+	if err := r.Trigger(); err != nil {
+		t.Fatal(err)
+	}
+	if len(opened) != 0 {
+		t.Fatal("expected the running recording to be extended")
+	}
+
+	// past the first post-roll but within the extended one
+	clock.Advance(10 * time.Millisecond)
+	select {
+	case <-p.finished:
+		t.Fatal("expected the recording to go on")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	clock.Advance(10 * time.Millisecond)
+	select {
+	case <-p.finished:
+	case <-time.After(time.Second):
+		t.Fatal("expected the recording to finish")
+	}
+
+	// the next trigger starts a new recording
+	deadline := time.Now().Add(time.Second)
+	for {
+		r.mu.Lock()
+		active := r.active
+		r.mu.Unlock()
+		if active == nil {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("expected the recording to be done")
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	if err := r.Trigger(); err != nil {
+		t.Fatal(err)
+	}
+	if len(opened) != 1 {
+		t.Fatal("expected a new recording")
+	}
+
+	next := <-opened
+	clock.Advance(time.Second)
+	<-next.finished
+}
+
+func TestRetention(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		cfg  common.ConfigRecording
+		kept []string
+	}{
+		{"count", common.ConfigRecording{MaxCount: 2}, []string{"a.webm", "b.mp4"}},
+		{"size", common.ConfigRecording{MaxSizeMB: 1}, []string{"a.webm"}},
+		{"age", common.ConfigRecording{MaxAge: 90 * time.Minute}, []string{"a.webm", "b.mp4"}},
+		{"none", common.ConfigRecording{}, []string{"a.webm", "b.mp4", "c.webm", "d.webm"}},
+	} {
+		r, clock, _ := newTestRecorder(t, &tc.cfg)
+		dir := tc.cfg.Dir.String()
+
+		// a is the newest, one hour between each, each 600KiB
+		for i, name := range []string{"a.webm", "b.mp4", "c.webm", "d.webm", "notes.txt"} {
+			path := filepath.Join(dir, name)
+			if err := os.WriteFile(path, make([]byte, 600*1024), 0o644); err != nil {
+				t.Fatal(err)
+			}
+			at := clock.Now().Add(-time.Duration(i) * time.Hour)
+			if err := os.Chtimes(path, at, at); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		r.cleanup()
+
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var left []string
+		for _, e := range entries {
+			if e.Name() != "notes.txt" {
+				left = append(left, e.Name())
+			}
+		}
+
+		if len(left) != len(tc.kept) || len(entries) != len(left)+1 {
+			t.Errorf("%s: expected %v and the notes to be kept, got %v", tc.name, tc.kept, entries)
+			continue
+		}
+		for i := range left {
+			if left[i] != tc.kept[i] {
+				t.Errorf("%s: expected %v to be kept, got %v", tc.name, tc.kept, left)
+				break
+			}
+		}
+	}
+}
@@ -0,0 +1,197 @@
+package reload
+
+import (
+	"context"
+	"os"
+	"os/signal"
+	"reflect"
+	"syscall"
+	"time"
+
+	"github.com/kaedwen/webrtc/pkg/common"
+	"go.uber.org/zap"
+)
+
+// interval the config file is checked for changes in
+const watchInterval = 2 * time.Second
+
+// Section selects the part of the config a component depends on, it is compared between the reloads
+type Section func(*common.Config) any
+
+type component struct {
+	name    string
+	section Section
+	apply   func(*common.Config) error // nil when the changes need a restart
+	cfg     *common.Config             // the component runs with
+}
+
+// Reloader reads the config again on SIGHUP and whenever its file changes and
+// hands it to the components whose section differs from the one they run with
+type Reloader struct {
+	lg         *zap.Logger
+	args       []string
+	cfg        *common.Config // of the start
+	components []*component
+}
+
+func NewReloader(lg *zap.Logger, cfg *common.Config, args []string) *Reloader {
+	return &Reloader{lg: lg, args: args, cfg: cfg}
+}
+
+// Apply registers a component that takes the changes of its section while running
+func (r *Reloader) Apply(name string, section Section, apply func(*common.Config) error) {
+	r.components = append(r.components, &component{name: name, section: section, apply: apply, cfg: r.cfg})
+}
+
+// Static registers a section whose changes only take effect after a restart
+func (r *Reloader) Static(name string, section Section) {
+	r.Apply(name, section, nil)
+}
+
+// Restart starts the component and starts it again on every change of its section. The start
+// returns a channel closed once the instance finished, the previous one is canceled and waited
+// for before, so the two never run side by side
+func (r *Reloader) Restart(ctx context.Context, name string, section Section, start func(context.Context, *common.Config) (<-chan struct{}, error)) error {
+	var cancel context.CancelFunc
+	var done <-chan struct{}
+	run := func(cfg *common.Config) error {
+		if cancel != nil {
+			cancel()
+			<-done
+			cancel = nil
+		}
+
+		sctx, scancel := context.WithCancel(ctx)
+
+		d, err := start(sctx, cfg)
+		if err != nil {
+			scancel()
+			return err
+		}
+		cancel, done = scancel, d
+
+		return nil
+	}
+
+	if err := run(r.cfg); err != nil {
+		return err
+	}
+
+	r.Apply(name, section, run)
+
+	return nil
+}
+
+// Run reloads the config until the context is done
+func (r *Reloader) Run(ctx context.Context) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+
+	var changes <-chan struct{}
+	if r.cfg.File.Path != nil {
+		changes = watch(ctx, r.cfg.File.Path.String(), watchInterval)
+	}
+
+	for {
+		select {
+		case <-hup:
+			r.lg.Info("reloading config on hangup")
+			r.reload()
+		case <-changes:
+			r.lg.Info("reloading changed config file")
+			r.reload()
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func (r *Reloader) reload() {
+	next := common.Config{}
+	if err := next.Parse(r.args); err != nil {
+		r.lg.Error("keeping the running config", zap.Error(err))
+		return
+	}
+
+	if err := next.Validate(); err != nil {
+		r.lg.Error("keeping the running config", zap.Error(err))
+		return
+	}
+
+	for _, c := range r.components {
+		if reflect.DeepEqual(c.section(c.cfg), c.section(&next)) {
+			continue
+		}
+
+		lg := r.lg.With(zap.String("component", c.name))
+
+		if c.apply == nil {
+			lg.Warn("changes take effect after a restart")
+			continue
+		}
+
+		if err := c.apply(&next); err != nil {
+			// the component is brought back to the config it ran with
+			lg.Error("failed to apply changes", zap.Error(err))
+			if err := c.apply(c.cfg); err != nil {
+				lg.Error("failed to restore the running config", zap.Error(err))
+			}
+			continue
+		}
+
+		lg.Info("applied changes")
+		c.cfg = &next
+	}
+}
+
+// watch reports a change of the file once it stopped changing for an interval,
+// editors tend to write in several steps
+func watch(ctx context.Context, path string, interval time.Duration) <-chan struct{} {
+	ch := make(chan struct{}, 1)
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		last, _ := os.Stat(path)
+		seen := last
+
+		for {
+			select {
+			case <-ticker.C:
+				fi, err := os.Stat(path)
+				if err != nil {
+					continue
+				}
+
+				if !sameFile(fi, seen) {
+					seen = fi
+					continue
+				}
+
+				if sameFile(fi, last) {
+					continue
+				}
+				last = fi
+
+				select {
+				case ch <- struct{}{}:
+				default:
+				}
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	return ch
+}
+
+func sameFile(a os.FileInfo, b os.FileInfo) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+
+	return a.ModTime().Equal(b.ModTime()) && a.Size() == b.Size()
+}
@@ -0,0 +1,220 @@
+package reload
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/kaedwen/webrtc/pkg/common"
+	"go.uber.org/zap"
+)
+
+const baseConfig = "video-src:\n  source: videotestsrc\n"
+
+// newTestReloader starts from a config file, the returned function rewrites it
+func newTestReloader(t *testing.T, content string) (*Reloader, func(string)) {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	write := func(content string) {
+		if err := os.WriteFile(path, []byte(baseConfig+content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write(content)
+
+	args := []string{"--config", path}
+
+	cfg := common.Config{}
+	if err := cfg.Parse(args); err != nil {
+		t.Fatal(err)
+	}
+
+	return NewReloader(zap.NewNop(), &cfg, args), write
+}
+
+func TestReloadAppliesChangedSections(t *testing.T) {
+	r, write := newTestReloader(t, "mqtt:\n  topic: a\n")
+
+	var topics []string
+	r.Apply("mqtt", func(c *common.Config) any { return c.Mqtt.Topic }, func(c *common.Config) error {
+		topics = append(topics, c.Mqtt.Topic)
+		return nil
+	})
+
+	var untouched int
+	r.Apply("turn", func(c *common.Config) any { return c.Turn }, func(c *common.Config) error {
+		untouched++
+		return nil
+	})
+
+	// an unchanged file applies nothing
+	r.reload()
+	if len(topics) != 0 {
+		t.Fatalf("expected no changes, got %v", topics)
+	}
+
+	write("mqtt:\n  topic: b\n")
+	r.reload()
+
+	// the applied config is the one compared against next time
+	r.reload()
+
+	if len(topics) != 1 || topics[0] != "b" {
+		t.Fatalf("expected b to be applied once, got %v", topics)
+	}
+	if untouched != 0 {
+		t.Fatalf("expected the unchanged section to be left, applied %d times", untouched)
+	}
+}
+
+func TestReloadKeepsRunningConfigOnInvalidFile(t *testing.T) {
+	r, write := newTestReloader(t, "mqtt:\n  topic: a\n")
+
+	var applied int
+	r.Apply("mqtt", func(c *common.Config) any { return c.Mqtt.Topic }, func(c *common.Config) error {
+		applied++
+		return nil
+	})
+
+	write("mqtt: [\n")
+	r.reload()
+
+	if applied != 0 {
+		t.Fatalf("expected an invalid file to apply nothing, applied %d times", applied)
+	}
+}
+
+func TestReloadRestoresOnFailure(t *testing.T) {
+	r, write := newTestReloader(t, "mqtt:\n  topic: a\n")
+
+	var topics []string
+	r.Apply("mqtt", func(c *common.Config) any { return c.Mqtt.Topic }, func(c *common.Config) error {
+		topics = append(topics, c.Mqtt.Topic)
+		if c.Mqtt.Topic == "b" {
+			return errors.New("rejected")
+		}
+		return nil
+	})
+
+	write("mqtt:\n  topic: b\n")
+	r.reload()
+
+	if len(topics) != 2 || topics[0] != "b" || topics[1] != "a" {
+		t.Fatalf("expected b to be tried and a restored, got %v", topics)
+	}
+
+	// the failed config is not taken as running, the next reload tries again
+	r.reload()
+	if len(topics) != 4 {
+		t.Fatalf("expected the change to be tried again, got %v", topics)
+	}
+}
+
+func TestReloadStaticIsNotApplied(t *testing.T) {
+	r, write := newTestReloader(t, "mqtt:\n  topic: a\n")
+	r.Static("mqtt", func(c *common.Config) any { return c.Mqtt.Topic })
+
+	write("mqtt:\n  topic: b\n")
+	r.reload()
+
+	if topic := r.components[0].cfg.Mqtt.Topic; topic != "a" {
+		t.Fatalf("expected the static section to keep a, got %s", topic)
+	}
+}
+
+func TestRestartWaitsForThePreviousInstance(t *testing.T) {
+	r, write := newTestReloader(t, "mqtt:\n  topic: a\n")
+
+	var running, overlaps atomic.Int32
+	var topics []string
+
+	start := func(ctx context.Context, c *common.Config) (<-chan struct{}, error) {
+		if running.Add(1) > 1 {
+			overlaps.Add(1)
+		}
+		topics = append(topics, c.Mqtt.Topic)
+
+		done := make(chan struct{})
+		go func() {
+			<-ctx.Done()
+			// like the mqtt handler saying goodbye to the broker
+			time.Sleep(20 * time.Millisecond)
+			running.Add(-1)
+			close(done)
+		}()
+
+		return done, nil
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	if err := r.Restart(ctx, "mqtt", func(c *common.Config) any { return c.Mqtt.Topic }, start); err != nil {
+		t.Fatal(err)
+	}
+
+	write("mqtt:\n  topic: b\n")
+	r.reload()
+	write("mqtt:\n  topic: c\n")
+	r.reload()
+
+	if overlaps.Load() != 0 {
+		t.Fatalf("instances ran side by side %d times", overlaps.Load())
+	}
+	if len(topics) != 3 || topics[2] != "c" {
+		t.Fatalf("expected three starts ending with c, got %v", topics)
+	}
+	if running.Load() != 1 {
+		t.Fatalf("expected one running instance, got %d", running.Load())
+	}
+}
+
+func TestRestartFailureStartsThePreviousConfig(t *testing.T) {
+	r, write := newTestReloader(t, "mqtt:\n  topic: a\n")
+
+	var topics []string
+	start := func(ctx context.Context, c *common.Config) (<-chan struct{}, error) {
+		topics = append(topics, c.Mqtt.Topic)
+		if c.Mqtt.Topic == "b" {
+			return nil, errors.New("rejected")
+		}
+
+		done := make(chan struct{})
+		go func() {
+			<-ctx.Done()
+			close(done)
+		}()
+
+		return done, nil
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	if err := r.Restart(ctx, "mqtt", func(c *common.Config) any { return c.Mqtt.Topic }, start); err != nil {
+		t.Fatal(err)
+	}
+
+	write("mqtt:\n  topic: b\n")
+
+	finished := make(chan struct{})
+	go func() {
+		r.reload()
+		close(finished)
+	}()
+
+	select {
+	case <-finished:
+	case <-time.After(2 * time.Second):
+		t.Fatal("reload got stuck after a failed start")
+	}
+
+	if len(topics) != 3 || topics[2] != "a" {
+		t.Fatalf("expected a to be started again, got %v", topics)
+	}
+}
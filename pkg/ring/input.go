@@ -0,0 +1,46 @@
+package ring
+
+import (
+	"os"
+
+	"github.com/holoplot/go-evdev"
+)
+
+// InputDevice is an evdev device the ring key can be read from
+type InputDevice struct {
+	Path   string
+	Name   string
+	Events map[string][]string // codes by event type
+}
+
+// InputDevices lists the evdev devices with the events they report
+func InputDevices() ([]InputDevice, error) {
+	paths, err := evdev.ListDevicePaths()
+	if err != nil {
+		return nil, err
+	}
+
+	devices := make([]InputDevice, 0, len(paths))
+	for _, p := range paths {
+		d, err := evdev.OpenWithFlags(p.Path, os.O_RDONLY)
+		if err != nil {
+			continue
+		}
+
+		dev := InputDevice{Path: p.Path, Name: p.Name, Events: make(map[string][]string)}
+		for _, t := range d.CapableTypes() {
+			if t == evdev.EV_SYN {
+				continue
+			}
+
+			for _, c := range d.CapableEvents(t) {
+				dev.Events[evdev.TypeName(t)] = append(dev.Events[evdev.TypeName(t)], evdev.CodeName(t, c))
+			}
+		}
+		_ = d.Close()
+
+		devices = append(devices, dev)
+	}
+
+	return devices, nil
+}
@@ -0,0 +1,68 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"github.com/kaedwen/webrtc/pkg/common"
+)
+
+type gotifyMessage struct {
+	Title    string         `json:"title"`
+	Message  string         `json:"message"`
+	Priority int            `json:"priority"`
+	Extras   map[string]any `json:"extras,omitempty"`
+}
+
+type gotify struct {
+	cfg    common.ConfigGotify
+	client *http.Client
+}
+
+func newGotify(cfg common.ConfigGotify) *gotify {
+	return &gotify{cfg: cfg, client: &http.Client{}}
+}
+
+// Attaches is false, gotify only takes a link to the snapshot
+func (g *gotify) Attaches() bool {
+	return false
+}
+
+func (g *gotify) Send(ctx context.Context, msg *Message) error {
+	m := gotifyMessage{
+		Title:    msg.Title,
+		Message:  msg.Body,
+		Priority: msg.Priority,
+	}
+
+	if msg.SnapshotUrl != "" {
+		m.Extras = map[string]any{
+			"client::notification": map[string]any{
+				"bigImageUrl": msg.SnapshotUrl,
+				"click":       map[string]string{"url": msg.SnapshotUrl},
+			},
+		}
+	}
+
+	body, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, strings.TrimSuffix(g.cfg.Url, "/")+"/message", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Gotify-Key", g.cfg.Token)
+
+	res, err := do(g.client, req)
+	if err != nil {
+		return err
+	}
+
+	return res.Body.Close()
+}
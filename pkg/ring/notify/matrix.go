@@ -0,0 +1,124 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/kaedwen/webrtc/pkg/common"
+)
+
+type matrixUpload struct {
+	ContentUri string `json:"content_uri"`
+}
+
+type matrixImageInfo struct {
+	Mimetype string `json:"mimetype"`
+	Size     int    `json:"size"`
+}
+
+type matrixMessage struct {
+	MsgType string           `json:"msgtype"`
+	Body    string           `json:"body"`
+	Url     string           `json:"url,omitempty"`
+	Info    *matrixImageInfo `json:"info,omitempty"`
+}
+
+type matrix struct {
+	cfg    common.ConfigMatrix
+	client *http.Client
+}
+
+func newMatrix(cfg common.ConfigMatrix) *matrix {
+	return &matrix{cfg: cfg, client: &http.Client{}}
+}
+
+func (m *matrix) Attaches() bool {
+	return true
+}
+
+// Send posts the text to the room, followed by the snapshot when there is one
+func (m *matrix) Send(ctx context.Context, msg *Message) error {
+	text := msg.Title + "\n" + msg.Body
+	if msg.SnapshotUrl != "" && msg.Snapshot == nil {
+		text += "\n" + msg.SnapshotUrl
+	}
+
+	if err := m.send(ctx, matrixMessage{MsgType: "m.text", Body: text}); err != nil {
+		return err
+	}
+
+	if msg.Snapshot == nil {
+		return nil
+	}
+
+	uri, err := m.upload(ctx, msg.Snapshot)
+	if err != nil {
+		return err
+	}
+
+	return m.send(ctx, matrixMessage{
+		MsgType: "m.image",
+		Body:    "snapshot.jpg",
+		Url:     uri,
+		Info:    &matrixImageInfo{Mimetype: "image/jpeg", Size: len(msg.Snapshot)},
+	})
+}
+
+func (m *matrix) request(ctx context.Context, method string, path string, contentType string, body []byte) (*http.Request, error) {
+	req, err := http.NewRequestWithContext(ctx, method, strings.TrimSuffix(m.cfg.Homeserver, "/")+path, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", contentType)
+	req.Header.Set("Authorization", "Bearer "+m.cfg.Token)
+
+	return req, nil
+}
+
+// upload stores the image on the homeserver and returns its mxc uri
+func (m *matrix) upload(ctx context.Context, image []byte) (string, error) {
+	req, err := m.request(ctx, http.MethodPost, "/_matrix/media/v3/upload?filename=snapshot.jpg", "image/jpeg", image)
+	if err != nil {
+		return "", err
+	}
+
+	res, err := do(m.client, req)
+	if err != nil {
+		return "", err
+	}
+	defer res.Body.Close()
+
+	var up matrixUpload
+	if err := json.NewDecoder(res.Body).Decode(&up); err != nil {
+		return "", err
+	}
+
+	return up.ContentUri, nil
+}
+
+func (m *matrix) send(ctx context.Context, msg matrixMessage) error {
+	body, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+
+	// the transaction id makes retries of the homeserver idempotent
+	path := "/_matrix/client/v3/rooms/" + url.PathEscape(m.cfg.Room) + "/send/m.room.message/" + uuid.NewString()
+
+	req, err := m.request(ctx, http.MethodPut, path, "application/json", body)
+	if err != nil {
+		return err
+	}
+
+	res, err := do(m.client, req)
+	if err != nil {
+		return err
+	}
+
+	return res.Body.Close()
+}
@@ -0,0 +1,180 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"text/template"
+	"time"
+
+	"github.com/kaedwen/webrtc/pkg/common"
+	"github.com/kaedwen/webrtc/pkg/events"
+	"github.com/kaedwen/webrtc/pkg/metrics"
+)
+
+const (
+	defaultTitle = `Doorbell`
+	defaultBody  = `{{if eq .Event "ring"}}Someone is at the door{{else if eq .Event "motion-start"}}Motion in front of the door{{else}}{{.Event}}{{end}}`
+
+	// time a backend gets to deliver a notification
+	sendTimeout = 30 * time.Second
+)
+
+// Data is handed to the title and body templates
+type Data struct {
+	events.Data
+	SnapshotUrl string
+}
+
+// Message is the rendered notification a backend delivers
+type Message struct {
+	Title       string
+	Body        string
+	Priority    int
+	Snapshot    []byte // jpeg, nil when not attached
+	SnapshotUrl string // public link of the snapshot, may be empty
+}
+
+type backend interface {
+	Send(context.Context, *Message) error
+	Attaches() bool // whether the snapshot is sent as an image
+}
+
+// Notifier renders the events with the templates of its config and hands them to the backend
+type Notifier struct {
+	kind    string
+	cfg     common.ConfigNotifier
+	url     string // of the snapshot
+	title   *template.Template
+	body    *template.Template
+	backend backend
+}
+
+// NewNotifiers creates the notifiers of all configured backends
+func NewNotifiers(cfg *common.ConfigNotify) ([]*Notifier, error) {
+	var notifiers []*Notifier
+
+	add := func(kind string, c common.ConfigNotifier, b backend) error {
+		n, err := newNotifier(kind, c, cfg.SnapshotUrl, b)
+		if err != nil {
+			return err
+		}
+
+		notifiers = append(notifiers, n)
+		return nil
+	}
+
+	for _, c := range cfg.Ntfy {
+		if err := add("ntfy", c.ConfigNotifier, newNtfy(c)); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, c := range cfg.Gotify {
+		if err := add("gotify", c.ConfigNotifier, newGotify(c)); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, c := range cfg.Matrix {
+		if err := add("matrix", c.ConfigNotifier, newMatrix(c)); err != nil {
+			return nil, err
+		}
+	}
+
+	return notifiers, nil
+}
+
+func newNotifier(kind string, cfg common.ConfigNotifier, url string, b backend) (*Notifier, error) {
+	if cfg.Name == "" {
+		cfg.Name = kind
+	}
+	if cfg.Title == "" {
+		cfg.Title = defaultTitle
+	}
+	if cfg.Body == "" {
+		cfg.Body = defaultBody
+	}
+
+	title, err := template.New("title").Parse(cfg.Title)
+	if err != nil {
+		return nil, fmt.Errorf("invalid title of notifier %s - %s", cfg.Name, err)
+	}
+
+	body, err := template.New("body").Parse(cfg.Body)
+	if err != nil {
+		return nil, fmt.Errorf("invalid body of notifier %s - %s", cfg.Name, err)
+	}
+
+	return &Notifier{kind: kind, cfg: cfg, url: url, title: title, body: body, backend: b}, nil
+}
+
+func (n *Notifier) Name() string {
+	return n.cfg.Name
+}
+
+func (n *Notifier) Events() []events.Kind {
+	if len(n.cfg.Events) == 0 {
+		return []events.Kind{events.KindRing}
+	}
+
+	kinds := make([]events.Kind, 0, len(n.cfg.Events))
+	for _, e := range n.cfg.Events {
+		kinds = append(kinds, events.Kind(e))
+	}
+
+	return kinds
+}
+
+// Snapshot tells whether the current image should be passed to Notify
+func (n *Notifier) Snapshot() bool {
+	return n.cfg.Snapshot && n.backend.Attaches()
+}
+
+func (n *Notifier) Notify(ctx context.Context, e events.Event, snapshot []byte) error {
+	data := Data{events.NewData(e), n.url}
+
+	var title, body bytes.Buffer
+	if err := n.title.Execute(&title, data); err != nil {
+		return fmt.Errorf("failed to render title - %s", err)
+	}
+	if err := n.body.Execute(&body, data); err != nil {
+		return fmt.Errorf("failed to render body - %s", err)
+	}
+
+	msg := Message{
+		Title:    title.String(),
+		Body:     body.String(),
+		Priority: n.cfg.Priority,
+		Snapshot: snapshot,
+	}
+	if n.cfg.Snapshot {
+		msg.SnapshotUrl = n.url
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, sendTimeout)
+	defer cancel()
+
+	err := n.backend.Send(ctx, &msg)
+	metrics.Notifications.WithLabelValues(n.kind, metrics.Result(err)).Inc()
+
+	return err
+}
+
+// do sends the request and fails on any status but 2xx
+func do(client *http.Client, req *http.Request) (*http.Response, error) {
+	res, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	if res.StatusCode < 200 || res.StatusCode >= 300 {
+		_, _ = io.Copy(io.Discard, res.Body)
+		res.Body.Close()
+		return nil, fmt.Errorf("received wrong status code - %d", res.StatusCode)
+	}
+
+	return res, nil
+}
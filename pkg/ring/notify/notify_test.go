@@ -0,0 +1,278 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"mime"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/kaedwen/webrtc/pkg/common"
+	"github.com/kaedwen/webrtc/pkg/events"
+)
+
+const snapshotUrl = "https://door.example/snapshot.jpg"
+
+var snapshot = []byte{0xff, 0xd8, 0xff, 0xd9}
+
+type request struct {
+	method string
+	path   string
+	header http.Header
+	body   []byte
+}
+
+// standIn records the requests of a backend, reply answers them
+type standIn struct {
+	*httptest.Server
+	mu       *sync.Mutex
+	requests []request
+}
+
+func newStandIn(t *testing.T, reply func(w http.ResponseWriter, r *http.Request)) *standIn {
+	t.Helper()
+
+	s := &standIn{mu: &sync.Mutex{}}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+
+		s.mu.Lock()
+		s.requests = append(s.requests, request{r.Method, r.URL.RequestURI(), r.Header.Clone(), body})
+		s.mu.Unlock()
+
+		if reply != nil {
+			reply(w, r)
+		}
+	}))
+	t.Cleanup(s.Close)
+
+	return s
+}
+
+func (s *standIn) recorded(t *testing.T, n int) []request {
+	t.Helper()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.requests) != n {
+		t.Fatalf("expected %d requests, got %d", n, len(s.requests))
+	}
+
+	return s.requests
+}
+
+func notifier(t *testing.T, cfg *common.ConfigNotify) *Notifier {
+	t.Helper()
+
+	cfg.SnapshotUrl = snapshotUrl
+	nfs, err := NewNotifiers(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(nfs) != 1 {
+		t.Fatalf("expected one notifier, got %d", len(nfs))
+	}
+
+	return nfs[0]
+}
+
+func decodeHeader(t *testing.T, v string) string {
+	t.Helper()
+
+	s, err := new(mime.WordDecoder).DecodeHeader(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return s
+}
+
+func TestNtfy(t *testing.T) {
+	s := newStandIn(t, nil)
+	n := notifier(t, &common.ConfigNotify{Ntfy: []common.ConfigNtfy{{
+		ConfigNotifier: common.ConfigNotifier{Title: "Klingel ä", Priority: 4},
+		Url:            s.URL + "/",
+		Topic:          "door",
+		Token:          common.Ptr("secret"),
+	}}})
+
+	if err := n.Notify(context.Background(), events.NewRing(), nil); err != nil {
+		t.Fatal(err)
+	}
+
+	r := s.recorded(t, 1)[0]
+	if r.method != http.MethodPost || r.path != "/door" {
+		t.Fatalf("unexpected request %s %s", r.method, r.path)
+	}
+	if string(r.body) != "Someone is at the door" {
+		t.Fatalf("unexpected body %q", r.body)
+	}
+	if title := decodeHeader(t, r.header.Get("Title")); title != "Klingel ä" {
+		t.Fatalf("unexpected title %q", title)
+	}
+	if r.header.Get("Authorization") != "Bearer secret" || r.header.Get("Priority") != "4" {
+		t.Fatalf("unexpected headers %v", r.header)
+	}
+	if r.header.Get("Filename") != "" || r.header.Get("Click") != "" {
+		t.Fatalf("expected no attachment, got %v", r.header)
+	}
+}
+
+func TestNtfyAttachesSnapshot(t *testing.T) {
+	s := newStandIn(t, nil)
+	n := notifier(t, &common.ConfigNotify{Ntfy: []common.ConfigNtfy{{
+		ConfigNotifier: common.ConfigNotifier{Snapshot: true},
+		Url:            s.URL,
+		Topic:          "door",
+	}}})
+
+	if !n.Snapshot() {
+		t.Fatal("expected ntfy to take the snapshot")
+	}
+	if err := n.Notify(context.Background(), events.NewRing(), snapshot); err != nil {
+		t.Fatal(err)
+	}
+
+	r := s.recorded(t, 1)[0]
+	if r.method != http.MethodPut || !bytes.Equal(r.body, snapshot) {
+		t.Fatalf("expected the snapshot to be put, got %s with %d bytes", r.method, len(r.body))
+	}
+	if msg := decodeHeader(t, r.header.Get("Message")); msg != "Someone is at the door" {
+		t.Fatalf("expected the text in the headers, got %q", msg)
+	}
+	if r.header.Get("Filename") != "snapshot.jpg" || r.header.Get("Click") != snapshotUrl {
+		t.Fatalf("unexpected headers %v", r.header)
+	}
+	if r.header.Get("Authorization") != "" {
+		t.Fatalf("expected no authorization without token, got %q", r.header.Get("Authorization"))
+	}
+}
+
+func TestGotify(t *testing.T) {
+	s := newStandIn(t, nil)
+	n := notifier(t, &common.ConfigNotify{Gotify: []common.ConfigGotify{{
+		ConfigNotifier: common.ConfigNotifier{Body: "{{.Event}} at {{.SnapshotUrl}}", Priority: 8, Snapshot: true},
+		Url:            s.URL + "/",
+		Token:          "app",
+	}}})
+
+	// gotify takes a link only, the image is not fetched for it
+	if n.Snapshot() {
+		t.Fatal("expected gotify to do without the snapshot")
+	}
+	if err := n.Notify(context.Background(), events.NewRing(), nil); err != nil {
+		t.Fatal(err)
+	}
+
+	r := s.recorded(t, 1)[0]
+	if r.method != http.MethodPost || r.path != "/message" {
+		t.Fatalf("unexpected request %s %s", r.method, r.path)
+	}
+	if r.header.Get("X-Gotify-Key") != "app" || r.header.Get("Content-Type") != "application/json" {
+		t.Fatalf("unexpected headers %v", r.header)
+	}
+
+	var m struct {
+		Title    string `json:"title"`
+		Message  string `json:"message"`
+		Priority int    `json:"priority"`
+		Extras   map[string]struct {
+			BigImageUrl string `json:"bigImageUrl"`
+		} `json:"extras"`
+	}
+	if err := json.Unmarshal(r.body, &m); err != nil {
+		t.Fatal(err)
+	}
+	if m.Title != "Doorbell" || m.Message != "ring at "+snapshotUrl || m.Priority != 8 {
+		t.Fatalf("unexpected message %s", r.body)
+	}
+	if m.Extras["client::notification"].BigImageUrl != snapshotUrl {
+		t.Fatalf("expected the snapshot link in the extras, got %s", r.body)
+	}
+}
+
+func TestMatrix(t *testing.T) {
+	s := newStandIn(t, nil)
+	n := notifier(t, &common.ConfigNotify{Matrix: []common.ConfigMatrix{{
+		Homeserver: s.URL,
+		Room:       "!room:example.org",
+		Token:      "user",
+	}}})
+
+	if err := n.Notify(context.Background(), events.NewRing(), nil); err != nil {
+		t.Fatal(err)
+	}
+
+	r := s.recorded(t, 1)[0]
+	if r.method != http.MethodPut || !strings.HasPrefix(r.path, "/_matrix/client/v3/rooms/%21room:example.org/send/m.room.message/") {
+		t.Fatalf("unexpected request %s %s", r.method, r.path)
+	}
+	if r.header.Get("Authorization") != "Bearer user" {
+		t.Fatalf("unexpected authorization %q", r.header.Get("Authorization"))
+	}
+
+	var m matrixMessage
+	if err := json.Unmarshal(r.body, &m); err != nil {
+		t.Fatal(err)
+	}
+	if m.MsgType != "m.text" || m.Body != "Doorbell\nSomeone is at the door" {
+		t.Fatalf("unexpected message %s", r.body)
+	}
+}
+
+func TestMatrixUploadsSnapshot(t *testing.T) {
+	s := newStandIn(t, func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasPrefix(r.URL.Path, "/_matrix/media/") {
+			_, _ = w.Write([]byte(`{"content_uri":"mxc://example.org/abc"}`))
+		}
+	})
+	n := notifier(t, &common.ConfigNotify{Matrix: []common.ConfigMatrix{{
+		ConfigNotifier: common.ConfigNotifier{Snapshot: true},
+		Homeserver:     s.URL,
+		Room:           "!room:example.org",
+		Token:          "user",
+	}}})
+
+	if err := n.Notify(context.Background(), events.NewRing(), snapshot); err != nil {
+		t.Fatal(err)
+	}
+
+	rs := s.recorded(t, 3)
+
+	up := rs[1]
+	if up.method != http.MethodPost || up.path != "/_matrix/media/v3/upload?filename=snapshot.jpg" {
+		t.Fatalf("unexpected upload %s %s", up.method, up.path)
+	}
+	if up.header.Get("Content-Type") != "image/jpeg" || up.header.Get("Authorization") != "Bearer user" || !bytes.Equal(up.body, snapshot) {
+		t.Fatalf("unexpected upload headers %v", up.header)
+	}
+
+	// the text and the image are sent as two transactions
+	if rs[0].path == rs[2].path {
+		t.Fatal("expected a new transaction id for the image")
+	}
+
+	var m matrixMessage
+	if err := json.Unmarshal(rs[2].body, &m); err != nil {
+		t.Fatal(err)
+	}
+	if m.MsgType != "m.image" || m.Url != "mxc://example.org/abc" || m.Info == nil || m.Info.Size != len(snapshot) {
+		t.Fatalf("unexpected image message %s", rs[2].body)
+	}
+}
+
+func TestFailedStatus(t *testing.T) {
+	s := newStandIn(t, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusUnauthorized)
+	})
+	n := notifier(t, &common.ConfigNotify{Gotify: []common.ConfigGotify{{Url: s.URL, Token: "wrong"}}})
+
+	if err := n.Notify(context.Background(), events.NewRing(), nil); err == nil {
+		t.Fatal("expected an error on a failed status")
+	}
+}
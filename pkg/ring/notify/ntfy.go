@@ -0,0 +1,64 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"mime"
+	"net/http"
+	"strings"
+
+	"github.com/kaedwen/webrtc/pkg/common"
+)
+
+type ntfy struct {
+	cfg    common.ConfigNtfy
+	client *http.Client
+}
+
+func newNtfy(cfg common.ConfigNtfy) *ntfy {
+	return &ntfy{cfg: cfg, client: &http.Client{}}
+}
+
+func (n *ntfy) Attaches() bool {
+	return true
+}
+
+// Send publishes the message to the topic, a snapshot is uploaded as the
+// attachment and the text moves to the headers then
+func (n *ntfy) Send(ctx context.Context, msg *Message) error {
+	url := strings.TrimSuffix(n.cfg.Url, "/") + "/" + n.cfg.Topic
+
+	method, body := http.MethodPost, []byte(msg.Body)
+	if msg.Snapshot != nil {
+		method, body = http.MethodPut, msg.Snapshot
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+
+	// non ascii text has to be encoded within headers
+	req.Header.Set("Title", mime.QEncoding.Encode("utf-8", msg.Title))
+	if msg.Snapshot != nil {
+		req.Header.Set("Message", mime.QEncoding.Encode("utf-8", msg.Body))
+		req.Header.Set("Filename", "snapshot.jpg")
+	}
+	if msg.Priority > 0 {
+		req.Header.Set("Priority", fmt.Sprint(msg.Priority))
+	}
+	if msg.SnapshotUrl != "" {
+		req.Header.Set("Click", msg.SnapshotUrl)
+	}
+	if n.cfg.Token != nil {
+		req.Header.Set("Authorization", "Bearer "+*n.cfg.Token)
+	}
+
+	res, err := do(n.client, req)
+	if err != nil {
+		return err
+	}
+
+	return res.Body.Close()
+}
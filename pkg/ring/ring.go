@@ -3,13 +3,17 @@ package ring
 import (
 	"context"
 	"fmt"
-	"net/http"
 	"net/url"
+	"sync"
 	"time"
 
 	"github.com/holoplot/go-evdev"
 	"github.com/kaedwen/webrtc/pkg/common"
+	"github.com/kaedwen/webrtc/pkg/events"
+	"github.com/kaedwen/webrtc/pkg/metrics"
+	"github.com/kaedwen/webrtc/pkg/ring/notify"
 	"github.com/kaedwen/webrtc/pkg/ring/sonos"
+	"github.com/kaedwen/webrtc/pkg/server"
 	"go.uber.org/zap"
 )
 
@@ -18,33 +22,74 @@ type PlayHandler interface {
 	Watch(context.Context) error
 }
 
+// Notifier delivers the events to a notification service
+type Notifier interface {
+	Name() string
+	Events() []events.Kind
+	Snapshot() bool // wants the current image
+	Notify(ctx context.Context, e events.Event, snapshot []byte) error
+}
+
+// time the stream gets to deliver the snapshot of a notification
+const snapshotTimeout = 10 * time.Second
+
 type RingHandler struct {
 	lg           *zap.Logger
 	cfg          *common.ConfigRing
+	health       *common.Health
+	bus          *events.Bus
+	snapshots    chan<- *server.SnapshotRequest
 	playHandlers []PlayHandler
+	notifiers    []Notifier
+	jingle       *url.URL // known once the players are watched
+	wg           sync.WaitGroup
 }
 
-func NewRingHandler(ctx context.Context, lg *zap.Logger, cfg *common.ConfigRing) error {
+func NewRingHandler(ctx context.Context, lg *zap.Logger, cfg *common.ConfigRing, ncfg *common.ConfigNotify, health *common.Health, bus *events.Bus, snapshots chan<- *server.SnapshotRequest) (<-chan struct{}, error) {
 	spl, err := sonos.NewSonosHandler(lg.With(zap.String("context", "sonos")), cfg)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	rh := &RingHandler{lg, cfg, []PlayHandler{spl}}
+	nfs, err := notify.NewNotifiers(ncfg)
+	if err != nil {
+		return nil, err
+	}
+
+	rh := &RingHandler{lg: lg, cfg: cfg, health: health, bus: bus, snapshots: snapshots, playHandlers: []PlayHandler{spl}}
+	for _, n := range nfs {
+		rh.notifiers = append(rh.notifiers, n)
+	}
+
+	if err = rh.players(ctx); err != nil {
+		return nil, err
+	}
 
 	if err = rh.watch(ctx); err != nil {
-		return err
+		return nil, err
 	}
 
-	return nil
-}
+	rh.wg.Add(1)
+	go func() {
+		defer rh.wg.Done()
+		rh.chime(ctx, bus.Subscribe(ctx, "chime", events.DefaultQueueSize, events.KindRing, events.KindMotionStart, events.KindChime))
+	}()
 
-func (h *RingHandler) watch(ctx context.Context) error {
-	if h.cfg.Device == nil {
-		h.lg.Warn("nothing to watch for key press")
-		return nil
+	for _, n := range rh.notifiers {
+		ch := bus.Subscribe(ctx, "notify-"+n.Name(), events.DefaultQueueSize, n.Events()...)
+
+		rh.wg.Add(1)
+		go func() {
+			defer rh.wg.Done()
+			rh.notify(ctx, n, ch)
+		}()
 	}
 
+	return common.Done(&rh.wg), nil
+}
+
+// players prepares the players of the jingle, a chime works without the input device
+func (h *RingHandler) players(ctx context.Context) error {
 	if h.cfg.JingleBaseUri == nil {
 		h.lg.Warn("missing jingle base uri, nothing to play")
 		return nil
@@ -56,21 +101,37 @@ func (h *RingHandler) watch(ctx context.Context) error {
 		}
 	}
 
-	d, err := evdev.Open(*h.cfg.Device)
+	bu, err := url.Parse(*h.cfg.JingleBaseUri)
 	if err != nil {
 		return err
 	}
+	h.jingle = bu.JoinPath(h.cfg.JinglePath.String())
 
-	vMajor, vMinor, vMicro := d.DriverVersion()
-	h.lg.Info("input driver running", zap.String("version", fmt.Sprintf("%d.%d.%d", vMajor, vMinor, vMicro)))
+	return nil
+}
 
-	key := evdev.KEYFromString[h.cfg.Key]
+func (h *RingHandler) watch(ctx context.Context) error {
+	if h.cfg.Device == nil {
+		h.lg.Warn("nothing to watch for key press")
+		return nil
+	}
 
-	bu, err := url.Parse(*h.cfg.JingleBaseUri)
+	d, err := evdev.Open(*h.cfg.Device)
 	if err != nil {
 		return err
 	}
 
+	vMajor, vMinor, vMicro := d.DriverVersion()
+	h.lg.Info("input driver running", zap.String("version", fmt.Sprintf("%d.%d.%d", vMajor, vMinor, vMicro)))
+
+	// the ioctl fails as soon as the device is gone
+	removeCheck := h.health.AddReadinessCheck("ring-device", func() error {
+		_, err := d.Name()
+		return err
+	})
+
+	key := evdev.KEYFromString[h.cfg.Key]
+
 	go func() {
 		err := d.NonBlock()
 		if err != nil {
@@ -79,53 +140,84 @@ func (h *RingHandler) watch(ctx context.Context) error {
 
 		for {
 			e, err := d.ReadOne()
+			if ctx.Err() != nil {
+				return
+			}
 			if err == nil && e.Code == key && e.Value == 0 {
-				// run all players
-				tu := bu.JoinPath(h.cfg.JinglePath.String())
-				for _, p := range h.playHandlers {
-					if err := p.Play(ctx, tu); err != nil {
-						h.lg.Error("failed to play", zap.Error(err))
-					}
-				}
-
-				// run webhooks when configured
-				if h.cfg.HomeassistantWebhook != nil {
-					h.lg.Info("Triggering Homeassistant Webhook", zap.String("hook", *h.cfg.HomeassistantWebhook))
-					ctxt, cancel := context.WithTimeout(ctx, 30*time.Second)
-
-					err := h.TriggerWebhook(ctxt, *h.cfg.HomeassistantWebhook)
-					if err != nil {
-						h.lg.Error("failed to trigger webhook", zap.Error(err))
-					}
-
-					cancel()
-				}
+				metrics.RingPresses.Inc()
+				h.lg.Info("ring")
+
+				h.bus.Publish(events.NewRing())
 			}
 		}
 	}()
 
+	h.wg.Add(1)
 	go func() {
+		defer h.wg.Done()
 		<-ctx.Done()
+		removeCheck()
 		_ = d.Close()
 	}()
 
 	return nil
 }
 
-func (h *RingHandler) TriggerWebhook(ctx context.Context, hook string) error {
-	req, err := http.NewRequestWithContext(ctx, http.MethodPost, *h.cfg.HomeassistantWebhook, nil)
-	if err != nil {
-		return err
+// chime plays the jingle on all players for a ring, on request and, when asked to, for motion
+func (h *RingHandler) chime(ctx context.Context, ch <-chan events.Event) {
+	for e := range ch {
+		if h.jingle == nil {
+			continue
+		}
+
+		if e.Kind() == events.KindMotionStart && !h.cfg.MotionChime {
+			continue
+		}
+
+		for _, p := range h.playHandlers {
+			if err := p.Play(ctx, h.jingle); err != nil {
+				h.lg.Error("failed to play", zap.Error(err))
+			}
+		}
 	}
+}
 
-	res, err := http.DefaultClient.Do(req)
-	if err != nil {
-		return err
+func (h *RingHandler) notify(ctx context.Context, n Notifier, ch <-chan events.Event) {
+	lg := h.lg.With(zap.String("notifier", n.Name()))
+
+	for e := range ch {
+		var snapshot []byte
+		if n.Snapshot() {
+			image, err := h.snapshot(ctx)
+			if err != nil {
+				// the notification is still worth more than nothing
+				lg.Warn("notifying without snapshot", zap.Error(err))
+			}
+			snapshot = image
+		}
+
+		if err := n.Notify(ctx, e, snapshot); err != nil {
+			lg.Error("failed to notify", zap.String("event", string(e.Kind())), zap.Error(err))
+		}
 	}
+}
 
-	if res.StatusCode != 200 {
-		return fmt.Errorf("received wrong status code - %d", res.StatusCode)
+func (h *RingHandler) snapshot(ctx context.Context) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(ctx, snapshotTimeout)
+	defer cancel()
+
+	req := server.SnapshotRequest{Reply: make(chan server.SnapshotReply, 1)}
+
+	select {
+	case h.snapshots <- &req:
+	case <-ctx.Done():
+		return nil, fmt.Errorf("snapshot not requested - %s", ctx.Err())
 	}
 
-	return nil
+	select {
+	case r := <-req.Reply:
+		return r.Image, r.Err
+	case <-ctx.Done():
+		return nil, fmt.Errorf("snapshot not delivered - %s", ctx.Err())
+	}
 }
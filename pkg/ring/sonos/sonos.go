@@ -14,6 +14,7 @@ import (
 	"github.com/google/uuid"
 	"github.com/hashicorp/mdns"
 	"github.com/kaedwen/webrtc/pkg/common"
+	"github.com/kaedwen/webrtc/pkg/metrics"
 	"go.uber.org/zap"
 )
 
@@ -191,7 +192,9 @@ func (h *SonosHandler) Watch(ctx context.Context) error {
 func (h *SonosHandler) Play(ctx context.Context, uri *url.URL) error {
 	for _, p := range h.players {
 		h.lg.Info("playing clip", zap.String("target", p.address.String()), zap.String("clip", uri.String()))
-		if err := p.Play(ctx, uri, h.cfg.SonosVolume); err != nil {
+		err := p.Play(ctx, uri, h.cfg.SonosVolume)
+		metrics.SonosClips.WithLabelValues(metrics.Result(err)).Inc()
+		if err != nil {
 			h.lg.Error("failed to play", zap.String("address", p.address.String()), zap.Error(err))
 		}
 	}
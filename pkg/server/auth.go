@@ -0,0 +1,254 @@
+package server
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"fmt"
+	"html/template"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/kaedwen/webrtc/pkg/common"
+	"go.uber.org/zap"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	SessionCookie = "session"
+	LoginPath     = "/login"
+	LogoutPath    = "/logout"
+	userKey       = "user"
+)
+
+var loginTemplate = template.Must(template.New("login").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>Login</title>
+</head>
+<body>
+<form method="post" action="/login">
+<input type="hidden" name="next" value="{{ .Next }}">
+<p><input name="username" placeholder="Username" autocomplete="username" required></p>
+<p><input name="password" type="password" placeholder="Password" autocomplete="current-password" required></p>
+{{ if .Failed }}<p>Invalid username or password</p>{{ end }}
+<p><button type="submit">Login</button></p>
+</form>
+</body>
+</html>
+`))
+
+type Authenticator struct {
+	lg     *zap.Logger
+	cfg    *common.ConfigAuth
+	secret []byte
+	public map[string]bool // paths ending with a slash cover everything below
+}
+
+func NewAuthenticator(lg *zap.Logger, cfg *common.ConfigAuth, public ...string) (*Authenticator, error) {
+	a := Authenticator{
+		lg:     lg,
+		cfg:    cfg,
+		public: make(map[string]bool, len(public)),
+	}
+
+	for _, p := range public {
+		a.public[p] = true
+	}
+
+	if cfg.SessionSecret != nil {
+		a.secret = []byte(*cfg.SessionSecret)
+	} else {
+		// sessions will not survive a restart
+		a.secret = make([]byte, 32)
+		if _, err := rand.Read(a.secret); err != nil {
+			return nil, err
+		}
+		if cfg.Enabled() {
+			lg.Warn("no session secret configured, using a random one")
+		}
+	}
+
+	return &a, nil
+}
+
+// Authenticate returns the user of the request from a bearer token or a session cookie
+func (a *Authenticator) Authenticate(r *http.Request) (string, bool) {
+	if t, had := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); had {
+		for _, token := range a.cfg.Tokens {
+			if subtle.ConstantTimeCompare([]byte(t), []byte(token)) == 1 {
+				return "token", true
+			}
+		}
+
+		return "", false
+	}
+
+	if c, err := r.Cookie(SessionCookie); err == nil {
+		return a.verifySession(c.Value)
+	}
+
+	return "", false
+}
+
+func (a *Authenticator) user(name string) (common.ConfigUser, bool) {
+	for _, u := range a.cfg.Users {
+		if u.Name == name {
+			return u, true
+		}
+	}
+
+	return common.ConfigUser{}, false
+}
+
+func (a *Authenticator) checkPassword(name string, password string) bool {
+	u, ok := a.user(name)
+	return ok && bcrypt.CompareHashAndPassword([]byte(u.Password), []byte(password)) == nil
+}
+
+func (a *Authenticator) sign(payload string) string {
+	m := hmac.New(sha256.New, a.secret)
+	m.Write([]byte(payload))
+	return base64.RawURLEncoding.EncodeToString(m.Sum(nil))
+}
+
+// passwordBinding ties a session to the stored password, changing it revokes the sessions
+func passwordBinding(u common.ConfigUser) string {
+	h := sha256.Sum256([]byte(u.Password))
+	return base64.RawURLEncoding.EncodeToString(h[:12])
+}
+
+// newSession creates a signed cookie value of the form user|expiry|binding.signature
+func (a *Authenticator) newSession(u common.ConfigUser) string {
+	payload := base64.RawURLEncoding.EncodeToString([]byte(fmt.Sprint(u.Name, "|", time.Now().Add(a.cfg.SessionTTL).Unix(), "|", passwordBinding(u))))
+	return payload + "." + a.sign(payload)
+}
+
+func (a *Authenticator) verifySession(value string) (string, bool) {
+	payload, signature, found := strings.Cut(value, ".")
+	if !found || !hmac.Equal([]byte(signature), []byte(a.sign(payload))) {
+		return "", false
+	}
+
+	b, err := base64.RawURLEncoding.DecodeString(payload)
+	if err != nil {
+		return "", false
+	}
+
+	// names may contain the separator, the expiry and the binding never do
+	rest, binding, found := cutLast(string(b), "|")
+	if !found {
+		return "", false
+	}
+	name, expiry, found := cutLast(rest, "|")
+	if !found {
+		return "", false
+	}
+
+	ts, err := strconv.ParseInt(expiry, 10, 64)
+	if err != nil || time.Now().After(time.Unix(ts, 0)) {
+		return "", false
+	}
+
+	// removed users and changed passwords end the session
+	u, ok := a.user(name)
+	if !ok || !hmac.Equal([]byte(binding), []byte(passwordBinding(u))) {
+		return "", false
+	}
+
+	return name, true
+}
+
+func cutLast(s string, sep string) (string, string, bool) {
+	i := strings.LastIndex(s, sep)
+	if i < 0 {
+		return s, "", false
+	}
+
+	return s[:i], s[i+len(sep):], true
+}
+
+func (a *Authenticator) Middleware(c *gin.Context) {
+	if !a.cfg.Enabled() || a.isPublic(c.Request.URL.Path) {
+		c.Next()
+		return
+	}
+
+	if user, ok := a.Authenticate(c.Request); ok {
+		c.Set(userKey, user)
+		c.Next()
+		return
+	}
+
+	// browsers are sent to the login page
+	if c.Request.Method == http.MethodGet && c.GetHeader("Upgrade") == "" && strings.Contains(c.GetHeader("Accept"), "text/html") {
+		c.Redirect(http.StatusSeeOther, LoginPath+"?next="+c.Request.URL.RequestURI())
+		c.Abort()
+		return
+	}
+
+	c.AbortWithStatus(http.StatusUnauthorized)
+}
+
+func (a *Authenticator) isPublic(path string) bool {
+	if a.public[path] {
+		return true
+	}
+
+	for p := range a.public {
+		if strings.HasSuffix(p, "/") && strings.HasPrefix(path, p) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// only redirect inside this server
+func safeNext(next string) string {
+	if !strings.HasPrefix(next, "/") || strings.HasPrefix(next, "//") || strings.HasPrefix(next, "/\\") {
+		return "/"
+	}
+
+	return next
+}
+
+func (a *Authenticator) loginPage(c *gin.Context) {
+	c.Status(http.StatusOK)
+	c.Header("Content-Type", "text/html; charset=utf-8")
+	_ = loginTemplate.Execute(c.Writer, gin.H{"Next": safeNext(c.Query("next"))})
+}
+
+func (a *Authenticator) login(c *gin.Context) {
+	name := c.PostForm("username")
+	next := safeNext(c.PostForm("next"))
+
+	if !a.checkPassword(name, c.PostForm("password")) {
+		a.lg.Warn("login failed", zap.String("user", name), zap.String("remote", c.ClientIP()))
+
+		c.Status(http.StatusUnauthorized)
+		c.Header("Content-Type", "text/html; charset=utf-8")
+		_ = loginTemplate.Execute(c.Writer, gin.H{"Next": next, "Failed": true})
+		return
+	}
+
+	a.lg.Info("login", zap.String("user", name), zap.String("remote", c.ClientIP()))
+
+	c.SetSameSite(http.SameSiteLaxMode)
+	u, _ := a.user(name)
+	c.SetCookie(SessionCookie, a.newSession(u), int(a.cfg.SessionTTL.Seconds()), "/", "", c.Request.TLS != nil, true)
+	c.Redirect(http.StatusSeeOther, next)
+}
+
+func (a *Authenticator) logout(c *gin.Context) {
+	c.SetSameSite(http.SameSiteLaxMode)
+	c.SetCookie(SessionCookie, "", -1, "/", "", c.Request.TLS != nil, true)
+	c.Redirect(http.StatusSeeOther, LoginPath)
+}
@@ -0,0 +1,209 @@
+package server
+
+import (
+	"encoding/base64"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/kaedwen/webrtc/pkg/common"
+	"go.uber.org/zap"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func hashPassword(t *testing.T, password string) string {
+	t.Helper()
+
+	h, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return string(h)
+}
+
+func newTestAuthenticator(t *testing.T, cfg *common.ConfigAuth, public ...string) *Authenticator {
+	t.Helper()
+
+	if cfg.SessionTTL == 0 {
+		cfg.SessionTTL = time.Hour
+	}
+	cfg.SessionSecret = common.Ptr("secret")
+
+	a, err := NewAuthenticator(zap.NewNop(), cfg, public...)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return a
+}
+
+func TestVerifySession(t *testing.T) {
+	alice := common.ConfigUser{Name: "alice", Password: hashPassword(t, "a")}
+	piped := common.ConfigUser{Name: "al|ce", Password: hashPassword(t, "b")}
+
+	cfg := &common.ConfigAuth{Users: []common.ConfigUser{alice, piped}}
+	a := newTestAuthenticator(t, cfg)
+
+	valid := a.newSession(alice)
+	payload, signature, _ := strings.Cut(valid, ".")
+
+	expired := newTestAuthenticator(t, &common.ConfigAuth{Users: cfg.Users, SessionTTL: -time.Minute}).newSession(alice)
+	other := newTestAuthenticator(t, &common.ConfigAuth{Users: cfg.Users})
+	other.secret = []byte("other")
+
+	// a payload signed correctly but naming someone else than the binding belongs to
+	forged := base64.RawURLEncoding.EncodeToString([]byte("al|ce|" + strings.Repeat("9", 10) + "|" + passwordBinding(alice)))
+
+	for _, tc := range []struct {
+		name  string
+		value string
+		user  string
+	}{
+		{"valid", valid, "alice"},
+		{"separator in name", a.newSession(piped), "al|ce"},
+		{"tampered signature", payload + "." + strings.Repeat("A", len(signature)), ""},
+		{"tampered payload", base64.RawURLEncoding.EncodeToString([]byte("bob|9999999999|x")) + "." + signature, ""},
+		{"other secret", other.newSession(alice), ""},
+		{"expired", expired, ""},
+		{"binding of another user", forged + "." + a.sign(forged), ""},
+		{"no signature", payload, ""},
+		{"no expiry", base64.RawURLEncoding.EncodeToString([]byte("alice")) + "." + a.sign(base64.RawURLEncoding.EncodeToString([]byte("alice"))), ""},
+		{"garbage", "!!!." + a.sign("!!!"), ""},
+	} {
+		user, ok := a.verifySession(tc.value)
+		if ok != (tc.user != "") || user != tc.user {
+			t.Errorf("%s: expected %q, got %q and %t", tc.name, tc.user, user, ok)
+		}
+	}
+}
+
+func TestSessionRevoked(t *testing.T) {
+	alice := common.ConfigUser{Name: "alice", Password: hashPassword(t, "a")}
+	cfg := &common.ConfigAuth{Users: []common.ConfigUser{alice}}
+	a := newTestAuthenticator(t, cfg)
+
+	session := a.newSession(alice)
+	if _, ok := a.verifySession(session); !ok {
+		t.Fatal("expected the session to be valid")
+	}
+
+	cfg.Users = []common.ConfigUser{{Name: "alice", Password: hashPassword(t, "changed")}}
+	if _, ok := a.verifySession(session); ok {
+		t.Fatal("expected a password change to revoke the session")
+	}
+
+	cfg.Users = nil
+	if _, ok := a.verifySession(session); ok {
+		t.Fatal("expected a removed user to lose the session")
+	}
+}
+
+func TestSafeNext(t *testing.T) {
+	for next, want := range map[string]string{
+		"":                     "/",
+		"/":                    "/",
+		"/live?x=1":            "/live?x=1",
+		"//evil.example":       "/",
+		"/\\evil.example":      "/",
+		"https://evil.example": "/",
+		"live":                 "/",
+	} {
+		if got := safeNext(next); got != want {
+			t.Errorf("%q: expected %q, got %q", next, want, got)
+		}
+	}
+}
+
+func TestIsPublic(t *testing.T) {
+	a := newTestAuthenticator(t, &common.ConfigAuth{}, "/healthz", "/.well-known/acme-challenge/")
+
+	for path, want := range map[string]bool{
+		"/healthz":                        true,
+		"/healthz/more":                   false,
+		"/healthzz":                       false,
+		"/.well-known/acme-challenge/abc": true,
+		"/.well-known/acme-challenge/":    true,
+		"/.well-known/acme-challenge":     false,
+		"/.well-known/other":              false,
+		"/":                               false,
+	} {
+		if got := a.isPublic(path); got != want {
+			t.Errorf("%s: expected %t, got %t", path, want, got)
+		}
+	}
+}
+
+func TestMiddleware(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	alice := common.ConfigUser{Name: "alice", Password: hashPassword(t, "a")}
+	a := newTestAuthenticator(t, &common.ConfigAuth{Users: []common.ConfigUser{alice}, Tokens: []string{"token"}}, "/healthz")
+
+	engine := gin.New()
+	engine.Use(a.Middleware)
+	engine.GET("/*path", func(c *gin.Context) {
+		c.String(http.StatusOK, c.GetString(userKey))
+	})
+
+	for _, tc := range []struct {
+		name     string
+		path     string
+		header   map[string]string
+		cookie   string
+		status   int
+		location string
+		user     string
+	}{
+		{name: "browser", path: "/live?x=1", header: map[string]string{"Accept": "text/html"}, status: http.StatusSeeOther, location: "/login?next=/live?x=1"},
+		{name: "api", path: "/snapshot", header: map[string]string{"Accept": "image/jpeg"}, status: http.StatusUnauthorized},
+		{name: "websocket", path: "/signaling/a", header: map[string]string{"Accept": "text/html", "Upgrade": "websocket"}, status: http.StatusUnauthorized},
+		{name: "public", path: "/healthz", status: http.StatusOK},
+		{name: "token", path: "/snapshot", header: map[string]string{"Authorization": "Bearer token"}, status: http.StatusOK, user: "token"},
+		{name: "wrong token", path: "/snapshot", header: map[string]string{"Authorization": "Bearer wrong"}, status: http.StatusUnauthorized},
+		{name: "session", path: "/", cookie: a.newSession(alice), status: http.StatusOK, user: "alice"},
+		{name: "broken session", path: "/", cookie: "broken", header: map[string]string{"Accept": "text/html"}, status: http.StatusSeeOther, location: "/login?next=/"},
+	} {
+		req := httptest.NewRequest(http.MethodGet, tc.path, nil)
+		for k, v := range tc.header {
+			req.Header.Set(k, v)
+		}
+		if tc.cookie != "" {
+			req.AddCookie(&http.Cookie{Name: SessionCookie, Value: tc.cookie})
+		}
+
+		rec := httptest.NewRecorder()
+		engine.ServeHTTP(rec, req)
+
+		if rec.Code != tc.status {
+			t.Errorf("%s: expected %d, got %d", tc.name, tc.status, rec.Code)
+			continue
+		}
+		if loc := rec.Header().Get("Location"); loc != tc.location {
+			t.Errorf("%s: expected location %q, got %q", tc.name, tc.location, loc)
+		}
+		if tc.status == http.StatusOK && rec.Body.String() != tc.user {
+			t.Errorf("%s: expected user %q, got %q", tc.name, tc.user, rec.Body.String())
+		}
+	}
+}
+
+func TestMiddlewareDisabled(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	a := newTestAuthenticator(t, &common.ConfigAuth{})
+
+	engine := gin.New()
+	engine.Use(a.Middleware)
+	engine.GET("/", func(c *gin.Context) { c.Status(http.StatusOK) })
+
+	rec := httptest.NewRecorder()
+	engine.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected everything to pass without users and tokens, got %d", rec.Code)
+	}
+}
@@ -0,0 +1,25 @@
+package server
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/kaedwen/webrtc/pkg/common"
+)
+
+func healthResponse(c *gin.Context, s common.HealthStatus) {
+	if s.Healthy {
+		c.JSON(http.StatusOK, s)
+		return
+	}
+
+	c.JSON(http.StatusServiceUnavailable, s)
+}
+
+func (h *HttpServer) livenessHandler(c *gin.Context) {
+	healthResponse(c, h.Health.Liveness())
+}
+
+func (h *HttpServer) readinessHandler(c *gin.Context) {
+	healthResponse(c, h.Health.Readiness())
+}
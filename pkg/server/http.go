@@ -5,46 +5,148 @@ import (
 	"crypto/tls"
 	"fmt"
 	"net/http"
+	"path/filepath"
 	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/kaedwen/webrtc/pkg/acme"
 	"github.com/kaedwen/webrtc/pkg/common"
+	"github.com/kaedwen/webrtc/pkg/hls"
+	"github.com/kaedwen/webrtc/pkg/push"
 	"github.com/kaedwen/webrtc/static"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"go.uber.org/zap"
 	"nhooyr.io/websocket"
 	"nhooyr.io/websocket/wsjson"
 )
 
 type SignalingHandle struct {
-	Id   string
-	Recv chan *IncomingSignalingMessage
-	Trcv chan *OutgoingSignalingMessage
+	Id      string
+	Trickle bool // candidates are exchanged as messages instead of within the answer
+	Recv    chan *IncomingSignalingMessage
+	Trcv    chan *OutgoingSignalingMessage
+	Done    chan struct{} // closed once the peer is gone
 }
 
 type HttpServer struct {
 	http.Server
-	lg   *zap.Logger
-	cfg  *common.Config
-	Hndl chan *SignalingHandle
+	lg        *zap.Logger
+	cfg       *common.Config
+	Hndl      chan *SignalingHandle
+	Publish   chan *SignalingHandle // publishers replacing the local source
+	Snapshot  chan *SnapshotRequest
+	Stream    chan *StreamRequest // consumers of the fallback streams
+	HLS       *hls.Playlist       // filled by the video pipeline
+	Health    *common.Health
+	Push      *push.Service // nil unless web push is enabled
+	auth      *Authenticator
+	ca        *common.LocalCA // nil unless it issues the certificate
+	acme      *acme.Manager   // nil unless acme is enabled
+	whep      *resourceSessions
+	whip      *resourceSessions
+	snapshots *snapshotCache
+	hls       *hlsActivity
 }
 
-func NewSignalingHandle(id string) SignalingHandle {
+func NewSignalingHandle(id string, trickle bool) SignalingHandle {
 	return SignalingHandle{
-		Id:   id,
-		Recv: make(chan *IncomingSignalingMessage, 10),
-		Trcv: make(chan *OutgoingSignalingMessage, 10),
+		Id:      id,
+		Trickle: trickle,
+		Recv:    make(chan *IncomingSignalingMessage, 10),
+		Trcv:    make(chan *OutgoingSignalingMessage, 10),
+		Done:    make(chan struct{}),
 	}
 }
 
-func NewHttpServer(lg *zap.Logger, cfg *common.Config) *HttpServer {
+func NewHttpServer(lg *zap.Logger, cfg *common.Config) (*HttpServer, error) {
 	h := HttpServer{
-		Hndl: make(chan *SignalingHandle, 10),
-		cfg:  cfg,
-		lg:   lg,
+		Hndl:      make(chan *SignalingHandle, 10),
+		Publish:   make(chan *SignalingHandle, 1),
+		Snapshot:  make(chan *SnapshotRequest),
+		Stream:    make(chan *StreamRequest),
+		HLS:       hls.NewPlaylist(),
+		Health:    common.NewHealth(),
+		whep:      newResourceSessions(),
+		whip:      newResourceSessions(),
+		snapshots: newSnapshotCache(),
+		hls:       newHlsActivity(),
+		cfg:       cfg,
+		lg:        lg,
+	}
+
+	var err error
+	h.auth, err = NewAuthenticator(lg.With(zap.String("sub-context", "auth")), &cfg.Auth, cfg.Http.PathGetLiveness, cfg.Http.PathGetReadiness, cfg.Http.PathGetCA, acme.ChallengePath, LoginPath, LogoutPath)
+	if err != nil {
+		return nil, err
+	}
+
+	// the local ca also serves until acme got the first certificate
+	if cfg.Http.LocalCA() {
+		h.ca, err = loadLocalCA(cfg)
+		if err != nil {
+			return nil, err
+		}
+
+		if cfg.Acme.Enabled() {
+			h.acme, err = acme.NewManager(lg.With(zap.String("sub-context", "acme")), &cfg.Acme, filepath.Join(cfg.State.Dir.String(), "acme"))
+			if err != nil {
+				return nil, err
+			}
+		}
 	}
 
 	engine := gin.Default()
+
+	// everything but the probes and the login needs authentication
+	engine.Use(h.auth.Middleware)
+	engine.GET(LoginPath, h.auth.loginPage)
+	engine.POST(LoginPath, h.auth.login)
+	engine.GET(LogoutPath, h.auth.logout)
+
 	engine.GET("/signaling/:id", h.signalingHandler)
+	engine.GET(cfg.Http.PathGetSnapshot, h.snapshotHandler)
+
+	if h.ca != nil {
+		engine.GET(cfg.Http.PathGetCA, h.caHandler)
+	}
+
+	if h.acme != nil {
+		engine.GET(acme.ChallengePath+":token", h.acmeChallengeHandler)
+	}
+
+	// fallback streams for clients without webrtc
+	engine.GET(cfg.Http.PathGetMJPEG, h.mjpegHandler)
+	engine.GET(cfg.Http.PathGetHLS, h.hlsIndex)
+	engine.GET(cfg.Http.PathGetHLS+"/:file", h.hlsHandler)
+
+	// web push subscriptions of the app
+	if cfg.Push.Enabled {
+		h.Push, err = push.NewService(lg.With(zap.String("sub-context", "push")), &cfg.Push, filepath.Join(cfg.State.Dir.String(), "push"))
+		if err != nil {
+			return nil, err
+		}
+
+		engine.GET(cfg.Http.PathPush+"/key", h.pushKeyHandler)
+		engine.POST(cfg.Http.PathPush+"/subscriptions", h.pushSubscribeHandler)
+		engine.DELETE(cfg.Http.PathPush+"/subscriptions", h.pushUnsubscribeHandler)
+	}
+
+	// WHEP egress
+	engine.OPTIONS(cfg.Http.PathWhep, optionsHandler)
+	engine.POST(cfg.Http.PathWhep, h.postHandler(h.Hndl, h.whep))
+	engine.PATCH(cfg.Http.PathWhep+"/:id", h.patchHandler(h.whep))
+	engine.DELETE(cfg.Http.PathWhep+"/:id", h.deleteHandler(h.whep))
+
+	// WHIP ingest
+	engine.OPTIONS(cfg.Http.PathWhip, optionsHandler)
+	engine.POST(cfg.Http.PathWhip, h.postHandler(h.Publish, h.whip))
+	engine.PATCH(cfg.Http.PathWhip+"/:id", h.patchHandler(h.whip))
+	engine.DELETE(cfg.Http.PathWhip+"/:id", h.deleteHandler(h.whip))
+
+	// probes
+	engine.GET(cfg.Http.PathGetLiveness, h.livenessHandler)
+	engine.GET(cfg.Http.PathGetReadiness, h.readinessHandler)
+	engine.GET(cfg.Http.PathGetMetrics, gin.WrapH(promhttp.Handler()))
 
 	// static handler
 	static.SetupHandler(engine, cfg)
@@ -52,7 +154,7 @@ func NewHttpServer(lg *zap.Logger, cfg *common.Config) *HttpServer {
 	// set out handler
 	h.Handler = engine
 
-	return &h
+	return &h, nil
 }
 
 func (h *HttpServer) ListenAndServe(ctx context.Context) error {
@@ -71,19 +173,12 @@ func (h *HttpServer) ListenAndServe(ctx context.Context) error {
 				Certificates: []tls.Certificate{cert},
 			}
 		} else {
-			h.lg.Info("creating self signed certificate")
-			var cert *tls.Certificate
-			duration, cert, err := common.Time(func() (*tls.Certificate, error) {
-				return common.GenerateSelfSigned()
-			})
+			cfg, err := h.localTLSConfig(ctx)
 			if err != nil {
 				return err
 			}
-			h.lg.Info("certificate created", zap.Duration("elapsed", duration))
 
-			h.Server.TLSConfig = &tls.Config{
-				Certificates: []tls.Certificate{*cert},
-			}
+			h.Server.TLSConfig = cfg
 		}
 
 		go func() {
@@ -131,7 +226,13 @@ func (h *HttpServer) signalingHandler(c *gin.Context) {
 	ctx, cancel := context.WithCancel(c.Request.Context())
 	defer cancel()
 
-	hndl := NewSignalingHandle(id)
+	// the app learns its config before the ice servers
+	if err := wsjson.Write(ctx, conn, NewConfigMessage(h.clientConfig())); err != nil {
+		h.lg.Error("failed to send config", zap.Error(err))
+		return
+	}
+
+	hndl := NewSignalingHandle(id, true)
 
 	// push hndl outside
 	h.Hndl <- &hndl
@@ -185,3 +286,12 @@ func (h *HttpServer) signalingHandler(c *gin.Context) {
 	}
 
 }
+
+func (h *HttpServer) clientConfig() ClientConfig {
+	var cfg ClientConfig
+	if h.Push != nil {
+		cfg.Push = h.cfg.Http.PathPush
+	}
+
+	return cfg
+}
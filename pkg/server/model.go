@@ -12,6 +12,9 @@ const (
 	MessageTypeIceCandidate SignalingMessageType = "new-ice-candidate"
 	MessageTypeAnswer       SignalingMessageType = "answer"
 	MessageTypeOffer        SignalingMessageType = "offer"
+	MessageTypeIceServers   SignalingMessageType = "ice-servers"
+	MessageTypeError        SignalingMessageType = "error"
+	MessageTypeConfig       SignalingMessageType = "config"
 )
 
 // INCOMING
@@ -74,6 +77,11 @@ func (m *IncomingSignalingMessage) ToOfferMessage() (*OfferMessage, error) {
 
 // OUTGOING
 
+// ClientConfig is the part of the config the app needs to know
+type ClientConfig struct {
+	Push string `json:"push,omitempty"` // base path of the push endpoints, empty when disabled
+}
+
 type OutgoingSignalingMessage struct {
 	Type SignalingMessageType `json:"type"`
 	Data any                  `json:"data"`
@@ -99,3 +107,24 @@ func NewOfferMessage(offer *webrtc.SessionDescription) *OutgoingSignalingMessage
 		Data: offer,
 	}
 }
+
+func NewErrorMessage(err error) *OutgoingSignalingMessage {
+	return &OutgoingSignalingMessage{
+		Type: MessageTypeError,
+		Data: err.Error(),
+	}
+}
+
+func NewIceServersMessage(servers []webrtc.ICEServer) *OutgoingSignalingMessage {
+	return &OutgoingSignalingMessage{
+		Type: MessageTypeIceServers,
+		Data: servers,
+	}
+}
+
+func NewConfigMessage(cfg ClientConfig) *OutgoingSignalingMessage {
+	return &OutgoingSignalingMessage{
+		Type: MessageTypeConfig,
+		Data: cfg,
+	}
+}
@@ -0,0 +1,59 @@
+package server
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/kaedwen/webrtc/pkg/push"
+	"go.uber.org/zap"
+)
+
+type pushKey struct {
+	PublicKey string `json:"publicKey"`
+}
+
+type pushUnsubscribe struct {
+	Endpoint string `json:"endpoint"`
+}
+
+// pushKeyHandler hands out the key the browsers have to subscribe with
+func (h *HttpServer) pushKeyHandler(c *gin.Context) {
+	c.JSON(http.StatusOK, pushKey{h.Push.PublicKey()})
+}
+
+func (h *HttpServer) pushSubscribeHandler(c *gin.Context) {
+	var sub push.Subscription
+	if err := c.ShouldBindJSON(&sub); err != nil {
+		c.Status(http.StatusBadRequest)
+		return
+	}
+
+	if err := h.Push.Subscribe(sub); errors.Is(err, push.ErrInvalidSubscription) {
+		h.lg.Warn("rejected subscription", zap.Error(err))
+		c.String(http.StatusBadRequest, err.Error())
+		return
+	} else if err != nil {
+		h.lg.Error("failed to subscribe", zap.Error(err))
+		c.Status(http.StatusInternalServerError)
+		return
+	}
+
+	c.Status(http.StatusCreated)
+}
+
+func (h *HttpServer) pushUnsubscribeHandler(c *gin.Context) {
+	var req pushUnsubscribe
+	if err := c.ShouldBindJSON(&req); err != nil || req.Endpoint == "" {
+		c.Status(http.StatusBadRequest)
+		return
+	}
+
+	if err := h.Push.Unsubscribe(req.Endpoint); err != nil {
+		h.lg.Error("failed to unsubscribe", zap.Error(err))
+		c.Status(http.StatusInternalServerError)
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
@@ -0,0 +1,58 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/kaedwen/webrtc/pkg/common"
+	"github.com/kaedwen/webrtc/pkg/push"
+	"go.uber.org/zap"
+)
+
+const testSubscription = `{"endpoint":"%s","keys":{"p256dh":"BNcRdreALRFXTkOOUHK1EtK2wtaz5Ry4YfYCA_0QTpQtUbVlUls0VJXg7A8u-Ts1XbjhazAkj7I99e8QcYP7DkM","auth":"tBHItJI5svbpez7KI4CCXg"}}`
+
+func TestPushSubscribeStatus(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	dir := t.TempDir()
+	ps, err := push.NewService(zap.NewNop(), &common.ConfigPush{TTL: time.Minute}, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	h := &HttpServer{lg: zap.NewNop(), Push: ps}
+	engine := gin.New()
+	engine.POST("/push/subscriptions", h.pushSubscribeHandler)
+
+	subscribe := func(endpoint string) int {
+		req := httptest.NewRequest(http.MethodPost, "/push/subscriptions", strings.NewReader(strings.Replace(testSubscription, "%s", endpoint, 1)))
+		req.Header.Set("Content-Type", "application/json")
+		rec := httptest.NewRecorder()
+		engine.ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	if code := subscribe("https://push.example/a"); code != http.StatusCreated {
+		t.Fatalf("expected the subscription to be created, got %d", code)
+	}
+	if code := subscribe("https://127.0.0.1/a"); code != http.StatusBadRequest {
+		t.Fatalf("expected a local endpoint to be a bad request, got %d", code)
+	}
+
+	// the store can not be replaced by its new version
+	if err := os.Remove(filepath.Join(dir, "subscriptions.json")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Mkdir(filepath.Join(dir, "subscriptions.json"), 0o700); err != nil {
+		t.Fatal(err)
+	}
+	if code := subscribe("https://push.example/b"); code != http.StatusInternalServerError {
+		t.Fatalf("expected a failed save to be an internal error, got %d", code)
+	}
+}
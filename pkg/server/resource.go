@@ -0,0 +1,281 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/pion/webrtc/v3"
+	"go.uber.org/zap"
+)
+
+const (
+	mimeSDP         = "application/sdp"
+	mimeTrickleFrag = "application/trickle-ice-sdpfrag"
+
+	// limit for offers and candidate fragments
+	maxSDPSize = 64 * 1024
+
+	// time the peer gets to create the answer including all candidates
+	answerTimeout = 10 * time.Second
+)
+
+// resourceSessions holds the signaling handles of the active WHEP/WHIP resources
+type resourceSessions struct {
+	mu       *sync.Mutex
+	sessions map[string]*SignalingHandle
+}
+
+func newResourceSessions() *resourceSessions {
+	return &resourceSessions{
+		mu:       &sync.Mutex{},
+		sessions: make(map[string]*SignalingHandle),
+	}
+}
+
+func (s *resourceSessions) Add(hndl *SignalingHandle) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.sessions[hndl.Id] = hndl
+}
+
+func (s *resourceSessions) Has(id string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	_, ok := s.sessions[id]
+	return ok
+}
+
+// Push hands the message to the session without blocking, the lock
+// makes sure the channel is not closed in the meantime
+func (s *resourceSessions) Push(id string, m *IncomingSignalingMessage) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	hndl, ok := s.sessions[id]
+	if !ok {
+		return fmt.Errorf("session %s not found", id)
+	}
+
+	select {
+	case hndl.Recv <- m:
+		return nil
+	default:
+		return fmt.Errorf("session %s not consuming messages", id)
+	}
+}
+
+// Remove drops the session and closes its channel which tears down the peer
+func (s *resourceSessions) Remove(id string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	hndl, ok := s.sessions[id]
+	if ok {
+		close(hndl.Recv)
+		delete(s.sessions, id)
+	}
+
+	return ok
+}
+
+func readSDP(c *gin.Context, mime string) (string, error) {
+	if ct := c.ContentType(); ct != mime {
+		return "", fmt.Errorf("unsupported content type - %s", ct)
+	}
+
+	b, err := io.ReadAll(io.LimitReader(c.Request.Body, maxSDPSize))
+	if err != nil {
+		return "", err
+	}
+
+	return string(b), nil
+}
+
+// iceServerLinks formats the ice servers as Link headers
+func iceServerLinks(servers []webrtc.ICEServer) []string {
+	links := make([]string, 0)
+	for _, s := range servers {
+		for _, u := range s.URLs {
+			l := fmt.Sprintf(`<%s>; rel="ice-server"`, u)
+			if s.Username != "" {
+				l += fmt.Sprintf(`; username="%s"; credential="%v"; credential-type="password"`, s.Username, s.Credential)
+			}
+			links = append(links, l)
+		}
+	}
+
+	return links
+}
+
+// parseSDPFrag extracts the candidates of a trickle ice fragment
+func parseSDPFrag(frag string) []webrtc.ICECandidateInit {
+	candidates := make([]webrtc.ICECandidateInit, 0)
+
+	var mid *string
+	var index uint16
+	first := true
+	for _, line := range strings.Split(frag, "\n") {
+		line = strings.TrimSpace(line)
+
+		switch {
+		case strings.HasPrefix(line, "m="):
+			if !first {
+				index++
+			}
+			first = false
+		case strings.HasPrefix(line, "a=mid:"):
+			m := strings.TrimPrefix(line, "a=mid:")
+			mid = &m
+		case strings.HasPrefix(line, "a=candidate:"):
+			i := index
+			candidates = append(candidates, webrtc.ICECandidateInit{
+				Candidate:     strings.TrimPrefix(line, "a="),
+				SDPMid:        mid,
+				SDPMLineIndex: &i,
+			})
+		}
+	}
+
+	return candidates
+}
+
+func optionsHandler(c *gin.Context) {
+	c.Header("Accept-Post", mimeSDP)
+	c.Status(http.StatusNoContent)
+}
+
+// postHandler creates a resource by handing the offer to the given channel and answering with its result
+func (h *HttpServer) postHandler(ch chan<- *SignalingHandle, sessions *resourceSessions) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		h.createResource(c, ch, sessions)
+	}
+}
+
+func (h *HttpServer) createResource(c *gin.Context, ch chan<- *SignalingHandle, sessions *resourceSessions) {
+	sdp, err := readSDP(c, mimeSDP)
+	if err != nil {
+		c.String(http.StatusUnsupportedMediaType, err.Error())
+		return
+	}
+
+	data, err := json.Marshal(webrtc.SessionDescription{Type: webrtc.SDPTypeOffer, SDP: sdp})
+	if err != nil {
+		c.Status(http.StatusInternalServerError)
+		return
+	}
+
+	hndl := NewSignalingHandle(uuid.NewString(), false)
+
+	// push hndl outside and hand over the offer
+	ch <- &hndl
+	hndl.Recv <- &IncomingSignalingMessage{Type: MessageTypeOffer, Data: data}
+
+	timeout := time.NewTimer(answerTimeout)
+	defer timeout.Stop()
+
+	var servers []webrtc.ICEServer
+	for {
+		select {
+		case m := <-hndl.Trcv:
+			switch m.Type {
+			case MessageTypeIceServers:
+				servers, _ = m.Data.([]webrtc.ICEServer)
+				continue
+			case MessageTypeError:
+				close(hndl.Recv)
+				c.String(http.StatusBadRequest, fmt.Sprint(m.Data))
+				return
+			case MessageTypeAnswer:
+				answer, ok := m.Data.(*webrtc.SessionDescription)
+				if !ok {
+					close(hndl.Recv)
+					c.Status(http.StatusInternalServerError)
+					return
+				}
+
+				sessions.Add(&hndl)
+				h.lg.Info("resource created", zap.String("path", c.Request.URL.Path), zap.String("id", hndl.Id))
+
+				// a peer failing or leaving without DELETE drops its resource too
+				go func(path string) {
+					<-hndl.Done
+					if sessions.Remove(hndl.Id) {
+						h.lg.Info("resource gone", zap.String("path", path), zap.String("id", hndl.Id))
+					}
+				}(c.Request.URL.Path)
+
+				for _, l := range iceServerLinks(servers) {
+					c.Writer.Header().Add("Link", l)
+				}
+				c.Header("Location", c.Request.URL.Path+"/"+hndl.Id)
+				c.Data(http.StatusCreated, mimeSDP, []byte(answer.SDP))
+				return
+			}
+		case <-timeout.C:
+			close(hndl.Recv)
+			h.lg.Error("answer timed out", zap.String("id", hndl.Id))
+			c.Status(http.StatusGatewayTimeout)
+			return
+		}
+	}
+}
+
+func (h *HttpServer) patchHandler(sessions *resourceSessions) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		h.patchResource(c, sessions)
+	}
+}
+
+func (h *HttpServer) patchResource(c *gin.Context, sessions *resourceSessions) {
+	id := c.Param("id")
+	if !sessions.Has(id) {
+		c.Status(http.StatusNotFound)
+		return
+	}
+
+	frag, err := readSDP(c, mimeTrickleFrag)
+	if err != nil {
+		c.String(http.StatusUnsupportedMediaType, err.Error())
+		return
+	}
+
+	for _, candidate := range parseSDPFrag(frag) {
+		data, err := json.Marshal(candidate)
+		if err != nil {
+			c.Status(http.StatusInternalServerError)
+			return
+		}
+
+		err = sessions.Push(id, &IncomingSignalingMessage{Type: MessageTypeIceCandidate, Data: data})
+		if err != nil {
+			h.lg.Error("failed to push candidate", zap.String("id", id), zap.Error(err))
+			c.Status(http.StatusServiceUnavailable)
+			return
+		}
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+func (h *HttpServer) deleteHandler(sessions *resourceSessions) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id := c.Param("id")
+		if !sessions.Remove(id) {
+			c.Status(http.StatusNotFound)
+			return
+		}
+
+		h.lg.Info("resource deleted", zap.String("path", c.Request.URL.Path), zap.String("id", id))
+
+		c.Status(http.StatusOK)
+	}
+}
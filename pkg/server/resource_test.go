@@ -0,0 +1,88 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/pion/webrtc/v3"
+	"go.uber.org/zap"
+)
+
+// createTestResource posts an offer and answers it the way the peer side does
+func createTestResource(t *testing.T, sessions *resourceSessions) *SignalingHandle {
+	t.Helper()
+
+	gin.SetMode(gin.TestMode)
+	h := &HttpServer{lg: zap.NewNop()}
+
+	ch := make(chan *SignalingHandle, 1)
+	engine := gin.New()
+	engine.POST("/whep", h.postHandler(ch, sessions))
+
+	go func() {
+		hndl := <-ch
+		<-hndl.Recv
+		hndl.Trcv <- NewAnswerMessage(&webrtc.SessionDescription{Type: webrtc.SDPTypeAnswer, SDP: "v=0\r\n"})
+	}()
+
+	req := httptest.NewRequest(http.MethodPost, "/whep", strings.NewReader("v=0\r\n"))
+	req.Header.Set("Content-Type", mimeSDP)
+	rec := httptest.NewRecorder()
+	engine.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("expected the resource to be created, got %d", rec.Code)
+	}
+
+	id := strings.TrimPrefix(rec.Header().Get("Location"), "/whep/")
+
+	sessions.mu.Lock()
+	defer sessions.mu.Unlock()
+	hndl, ok := sessions.sessions[id]
+	if !ok {
+		t.Fatalf("expected session %s", id)
+	}
+
+	return hndl
+}
+
+func TestResourceDroppedWhenPeerIsGone(t *testing.T) {
+	sessions := newResourceSessions()
+	hndl := createTestResource(t, sessions)
+
+	// the peer failed without a DELETE
+	close(hndl.Done)
+
+	deadline := time.Now().Add(5 * time.Second)
+	for sessions.Has(hndl.Id) {
+		if time.Now().After(deadline) {
+			t.Fatal("expected the resource to be dropped")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	if _, ok := <-hndl.Recv; ok {
+		t.Fatal("expected the signaling to be closed")
+	}
+}
+
+func TestResourceDeletedBeforePeerIsGone(t *testing.T) {
+	sessions := newResourceSessions()
+	hndl := createTestResource(t, sessions)
+
+	if !sessions.Remove(hndl.Id) {
+		t.Fatal("expected the resource to be removed")
+	}
+
+	// the peer going down afterwards closes nothing twice
+	close(hndl.Done)
+	time.Sleep(20 * time.Millisecond)
+
+	if sessions.Has(hndl.Id) {
+		t.Fatal("expected the resource to stay removed")
+	}
+}
@@ -0,0 +1,82 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+)
+
+// time the stream gets to deliver a frame, including a pipeline start
+const snapshotTimeout = 10 * time.Second
+
+// SnapshotRequest asks the stream for the latest frame, the answer is sent on Reply
+type SnapshotRequest struct {
+	Reply chan SnapshotReply
+}
+
+type SnapshotReply struct {
+	Image []byte // jpeg
+	Err   error
+}
+
+// snapshotCache keeps the last frame, so polling clients do not start the pipeline over and over
+type snapshotCache struct {
+	mu    *sync.Mutex
+	image []byte
+	taken time.Time
+}
+
+func newSnapshotCache() *snapshotCache {
+	return &snapshotCache{mu: &sync.Mutex{}}
+}
+
+// Get returns the cached frame when it is younger than maxAge, otherwise a new one is requested
+func (s *snapshotCache) Get(ctx context.Context, ch chan<- *SnapshotRequest, maxAge time.Duration) ([]byte, time.Time, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.image != nil && time.Since(s.taken) < maxAge {
+		return s.image, s.taken, nil
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, snapshotTimeout)
+	defer cancel()
+
+	req := SnapshotRequest{Reply: make(chan SnapshotReply, 1)}
+
+	select {
+	case ch <- &req:
+	case <-ctx.Done():
+		return nil, time.Time{}, fmt.Errorf("snapshot not requested - %s", ctx.Err())
+	}
+
+	select {
+	case r := <-req.Reply:
+		if r.Err != nil {
+			return nil, time.Time{}, r.Err
+		}
+
+		s.image, s.taken = r.Image, time.Now()
+		return s.image, s.taken, nil
+	case <-ctx.Done():
+		return nil, time.Time{}, fmt.Errorf("snapshot not delivered - %s", ctx.Err())
+	}
+}
+
+func (h *HttpServer) snapshotHandler(c *gin.Context) {
+	image, taken, err := h.snapshots.Get(c.Request.Context(), h.Snapshot, h.cfg.Http.SnapshotMaxAge)
+	if err != nil {
+		h.lg.Error("failed to take snapshot", zap.Error(err))
+		c.Status(http.StatusServiceUnavailable)
+		return
+	}
+
+	c.Header("Cache-Control", "no-cache")
+	c.Header("Last-Modified", taken.UTC().Format(http.TimeFormat))
+	c.Data(http.StatusOK, "image/jpeg", image)
+}
@@ -0,0 +1,217 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/kaedwen/webrtc/pkg/common"
+	"github.com/kaedwen/webrtc/pkg/hls"
+	"go.uber.org/zap"
+)
+
+type StreamKind string
+
+const (
+	StreamMJPEG StreamKind = "mjpeg"
+	StreamHLS   StreamKind = "hls"
+
+	mjpegBoundary = "frame"
+
+	// time the pipeline gets to complete the first segment
+	playlistTimeout = 15 * time.Second
+)
+
+// StreamRequest registers a consumer of a fallback stream until Done is closed,
+// mjpeg consumers receive the frames on Frames
+type StreamRequest struct {
+	Kind   StreamKind
+	Frames chan []byte
+	Done   chan struct{}
+}
+
+func NewStreamRequest(kind StreamKind) *StreamRequest {
+	return &StreamRequest{
+		Kind:   kind,
+		Frames: make(chan []byte, 2),
+		Done:   make(chan struct{}),
+	}
+}
+
+// hlsActivity keeps a single hls consumer registered as long as the clients keep polling
+type hlsActivity struct {
+	mu   *sync.Mutex
+	req  *StreamRequest
+	last time.Time
+}
+
+func newHlsActivity() *hlsActivity {
+	return &hlsActivity{mu: &sync.Mutex{}}
+}
+
+func (a *hlsActivity) Touch(ctx context.Context, ch chan<- *StreamRequest, idle time.Duration) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.last = time.Now()
+	if a.req != nil {
+		return nil
+	}
+
+	req := NewStreamRequest(StreamHLS)
+	select {
+	case ch <- req:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	a.req = req
+
+	// release the consumer once the clients are gone
+	go func() {
+		for {
+			a.mu.Lock()
+			d := idle - time.Since(a.last)
+			if d <= 0 {
+				close(a.req.Done)
+				a.req = nil
+				a.mu.Unlock()
+				return
+			}
+			a.mu.Unlock()
+
+			time.Sleep(d)
+		}
+	}()
+
+	return nil
+}
+
+func (h *HttpServer) mjpegHandler(c *gin.Context) {
+	req := NewStreamRequest(StreamMJPEG)
+	defer close(req.Done)
+
+	select {
+	case h.Stream <- req:
+	case <-c.Request.Context().Done():
+		return
+	}
+
+	c.Header("Content-Type", "multipart/x-mixed-replace; boundary="+mjpegBoundary)
+	c.Header("Cache-Control", "no-cache")
+	c.Status(http.StatusOK)
+
+	for {
+		select {
+		case frame := <-req.Frames:
+			// the frame is shared with the other consumers, so it is written as it is
+			_, err := fmt.Fprintf(c.Writer, "--%s\r\nContent-Type: image/jpeg\r\nContent-Length: %d\r\n\r\n", mjpegBoundary, len(frame))
+			if err == nil {
+				_, err = c.Writer.Write(frame)
+			}
+			if err == nil {
+				_, err = c.Writer.WriteString("\r\n")
+			}
+			if err != nil {
+				h.lg.Info("mjpeg client gone", zap.Error(err))
+				return
+			}
+			c.Writer.Flush()
+		case <-c.Request.Context().Done():
+			return
+		}
+	}
+}
+
+func (h *HttpServer) hlsHandler(c *gin.Context) {
+	cfg := &h.cfg.Fallback
+
+	if err := h.hls.Touch(c.Request.Context(), h.Stream, cfg.HLSIdle); err != nil {
+		c.Status(http.StatusServiceUnavailable)
+		return
+	}
+
+	// only files of the hls dir are served
+	name := filepath.Base(c.Param("file"))
+
+	switch filepath.Ext(name) {
+	case ".m3u8":
+		if name != common.HLSPlaylist {
+			c.Status(http.StatusNotFound)
+			return
+		}
+
+		h.hlsPlaylist(c, time.Duration(cfg.HLSSegment)*time.Second)
+		return
+	case ".ts":
+		c.Header("Content-Type", "video/mp2t")
+	default:
+		c.Status(http.StatusNotFound)
+		return
+	}
+
+	c.Header("Cache-Control", "no-cache")
+	c.File(filepath.Join(cfg.HLSDir.String(), name))
+}
+
+// hlsPlaylist answers a blocking playlist reload once the requested part is there, the
+// first request has to wait for the pipeline
+func (h *HttpServer) hlsPlaylist(c *gin.Context, segment time.Duration) {
+	msn, part, err := blockingRequest(c)
+	if err != nil {
+		c.String(http.StatusBadRequest, err.Error())
+		return
+	}
+
+	// the spec expects an answer within three target durations
+	timeout := 3 * segment
+	if msn < 0 {
+		timeout = playlistTimeout
+	}
+
+	b, err := h.HLS.Wait(c.Request.Context(), msn, part, timeout)
+	switch {
+	case errors.Is(err, hls.ErrTooFar):
+		c.String(http.StatusBadRequest, err.Error())
+		return
+	case err != nil:
+		c.Status(http.StatusServiceUnavailable)
+		return
+	}
+
+	c.Header("Cache-Control", "no-cache")
+	c.Data(http.StatusOK, "application/vnd.apple.mpegurl", b)
+}
+
+// blockingRequest parses _HLS_msn and _HLS_part, both are -1 when not given
+func blockingRequest(c *gin.Context) (int, int, error) {
+	msn, part := -1, -1
+
+	if v, ok := c.GetQuery("_HLS_msn"); ok {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			return 0, 0, fmt.Errorf("invalid _HLS_msn %q", v)
+		}
+		msn = n
+	}
+
+	if v, ok := c.GetQuery("_HLS_part"); ok {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 || msn < 0 {
+			return 0, 0, fmt.Errorf("invalid _HLS_part %q", v)
+		}
+		part = n
+	}
+
+	return msn, part, nil
+}
+
+// hlsIndex redirects to the playlist, so the base path can be handed out
+func (h *HttpServer) hlsIndex(c *gin.Context) {
+	c.Redirect(http.StatusFound, h.cfg.Http.PathGetHLS+"/"+common.HLSPlaylist)
+}
@@ -0,0 +1,65 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/kaedwen/webrtc/pkg/common"
+	"github.com/kaedwen/webrtc/pkg/hls"
+	"go.uber.org/zap"
+)
+
+func TestHLSPlaylist(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	dir := t.TempDir()
+	h := &HttpServer{lg: zap.NewNop(), cfg: &common.Config{}, HLS: hls.NewPlaylist()}
+	h.cfg.Fallback.HLSSegment = 1
+	if err := h.HLS.Configure(dir, 250*time.Millisecond, time.Second); err != nil {
+		t.Fatal(err)
+	}
+
+	// one complete segment and a part of the next
+	for _, name := range []string{"part00000.ts", "part00001.ts", "part00002.ts", "part00003.ts", "part00004.ts"} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(name), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		if err := h.HLS.AddPart(name, 250*time.Millisecond); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	engine := gin.New()
+	engine.GET("/hls/playlist", func(c *gin.Context) { h.hlsPlaylist(c, time.Duration(h.cfg.Fallback.HLSSegment)*time.Second) })
+
+	for _, tc := range []struct {
+		query  string
+		status int
+	}{
+		{"", http.StatusOK},
+		{"?_HLS_msn=2&_HLS_part=0", http.StatusOK},
+		{"?_HLS_msn=1", http.StatusOK},
+		{"?_HLS_part=0", http.StatusBadRequest},
+		{"?_HLS_msn=x", http.StatusBadRequest},
+		{"?_HLS_msn=-1", http.StatusBadRequest},
+		{"?_HLS_msn=9", http.StatusBadRequest},
+		{"?_HLS_msn=2&_HLS_part=1", http.StatusServiceUnavailable},
+	} {
+		rec := httptest.NewRecorder()
+		engine.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/hls/playlist"+tc.query, nil))
+
+		if rec.Code != tc.status {
+			t.Errorf("%q: expected %d, got %d", tc.query, tc.status, rec.Code)
+			continue
+		}
+		if tc.status == http.StatusOK && (rec.Header().Get("Content-Type") != "application/vnd.apple.mpegurl" || !strings.Contains(rec.Body.String(), "part00004.ts")) {
+			t.Errorf("%q: unexpected playlist %s", tc.query, rec.Body.String())
+		}
+	}
+}
@@ -0,0 +1,125 @@
+package server
+
+import (
+	"context"
+	"crypto/tls"
+	"net/http"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/kaedwen/webrtc/pkg/common"
+	"go.uber.org/zap"
+)
+
+// interval the certificate is checked for expiry and changed addresses
+const renewInterval = 24 * time.Hour
+
+// localCertificate hands out the current leaf of the local ca, it is swapped on renewal
+type localCertificate struct {
+	mu   *sync.RWMutex
+	cert *tls.Certificate
+}
+
+func (l *localCertificate) Get(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	return l.cert, nil
+}
+
+func (l *localCertificate) Set(cert *tls.Certificate) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.cert = cert
+}
+
+func loadLocalCA(cfg *common.Config) (*common.LocalCA, error) {
+	return common.LoadLocalCA(filepath.Join(cfg.State.Dir.String(), "tls"))
+}
+
+// localTLSConfig issues the certificate by the local ca and keeps it up to date,
+// the certificate of acme replaces it once there is one
+func (h *HttpServer) localTLSConfig(ctx context.Context) (*tls.Config, error) {
+	issue := func() (*tls.Certificate, error) {
+		duration, cert, err := common.Time(func() (*tls.Certificate, error) {
+			return h.ca.Leaf(common.LocalHosts(h.cfg.Http.TlsHosts))
+		})
+		if err != nil {
+			return nil, err
+		}
+		h.lg.Info("certificate loaded", zap.Strings("hosts", certHosts(cert)), zap.Time("expires", cert.Leaf.NotAfter), zap.Duration("elapsed", duration))
+
+		return cert, nil
+	}
+
+	cert, err := issue()
+	if err != nil {
+		return nil, err
+	}
+
+	local := localCertificate{mu: &sync.RWMutex{}, cert: cert}
+
+	getCertificate := local.Get
+	if h.acme != nil {
+		go h.acme.Run(ctx)
+
+		getCertificate = func(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+			if cert := h.acme.Certificate(); cert != nil {
+				return cert, nil
+			}
+			return local.Get(hello)
+		}
+	}
+
+	go func() {
+		ticker := time.NewTicker(renewInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				cert, err := issue()
+				if err != nil {
+					h.lg.Error("failed to renew certificate", zap.Error(err))
+					continue
+				}
+				local.Set(cert)
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	return &tls.Config{GetCertificate: getCertificate}, nil
+}
+
+// certHosts lists the names and addresses of the certificate in a new slice, the leaf is shared
+func certHosts(cert *tls.Certificate) []string {
+	hosts := make([]string, 0, len(cert.Leaf.DNSNames)+len(cert.Leaf.IPAddresses))
+	hosts = append(hosts, cert.Leaf.DNSNames...)
+	for _, ip := range cert.Leaf.IPAddresses {
+		hosts = append(hosts, ip.String())
+	}
+
+	return hosts
+}
+
+// caHandler offers the local ca, so devices can trust it once
+func (h *HttpServer) caHandler(c *gin.Context) {
+	c.Header("Content-Disposition", `attachment; filename="doorbell-ca.crt"`)
+	c.Data(http.StatusOK, "application/x-x509-ca-cert", h.ca.Certificate())
+}
+
+// acmeChallengeHandler answers the http-01 challenges of the pending authorizations
+func (h *HttpServer) acmeChallengeHandler(c *gin.Context) {
+	resp, ok := h.acme.ChallengeResponse(c.Param("token"))
+	if !ok {
+		c.Status(http.StatusNotFound)
+		return
+	}
+
+	c.Data(http.StatusOK, "text/plain", []byte(resp))
+}
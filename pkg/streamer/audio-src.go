@@ -0,0 +1,25 @@
+package streamer
+
+import "github.com/kaedwen/webrtc/pkg/common"
+
+func NewAudioSourceElement(cfg *common.ConfigAudioSourceStream) *StreamElement {
+	properties := map[string]interface{}{}
+	if cfg.Source == "alsasrc" || cfg.Source == "pulsesrc" {
+		if cfg.Device != nil {
+			properties["device"] = *cfg.Device
+		} else {
+			properties["device"] = cfg.DeviceName
+		}
+	}
+
+	return &StreamElement{
+		Kind:       cfg.Source,
+		Properties: properties,
+		SrcCaps: NewCaps("audio/x-raw", map[string]any{
+			"channels": cfg.Channels,
+			"rate":     48000,
+		}),
+		Queue: cfg.Queue,
+		Codec: cfg.Codec,
+	}
+}
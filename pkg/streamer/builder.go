@@ -6,7 +6,8 @@ import (
 )
 
 type PipelineBuilder struct {
-	parts []string
+	parts    []string
+	branches []*PipelineBuilder
 }
 
 func NewPipelineBuilder() *PipelineBuilder {
@@ -34,6 +35,24 @@ func (pb *PipelineBuilder) AddFilter(c *Caps) {
 	}
 }
 
+// Append adds the elements and branches of the other builder
+func (pb *PipelineBuilder) Append(other *PipelineBuilder) *PipelineBuilder {
+	pb.parts = append(pb.parts, other.parts...)
+	pb.branches = append(pb.branches, other.branches...)
+	return pb
+}
+
+// AddBranch adds a further chain, it starts from a named element like "t."
+func (pb *PipelineBuilder) AddBranch(branch *PipelineBuilder) *PipelineBuilder {
+	pb.branches = append(pb.branches, branch)
+	return pb
+}
+
 func (pb *PipelineBuilder) Build() string {
-	return strings.Join(pb.parts, " ! ")
+	s := strings.Join(pb.parts, " ! ")
+	for _, b := range pb.branches {
+		s += " " + b.Build()
+	}
+
+	return s
 }
@@ -0,0 +1,105 @@
+package streamer
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+
+	"github.com/go-gst/go-gst/gst"
+)
+
+// classes of the devices known to the device monitor
+const (
+	ClassVideoSource = "Video/Source"
+	ClassAudioSource = "Audio/Source"
+	ClassAudioSink   = "Audio/Sink"
+)
+
+// Device is a capture or playback device found by the device monitor
+type Device struct {
+	Name  string
+	Class string
+	Path  string   // device property of its element, empty when it has none
+	Caps  []string // one entry per format
+}
+
+// Devices lists the present devices of the given class
+func Devices(class string) ([]Device, error) {
+	m := gst.NewDeviceMonitor()
+	if m == nil {
+		return nil, errors.New("failed to create device monitor")
+	}
+
+	m.AddFilter(class, gst.NewAnyCaps())
+	if !m.Start() {
+		return nil, errors.New("failed to start device monitor")
+	}
+	defer m.Stop()
+
+	var devices []Device
+	for _, d := range m.GetDevices() {
+		dev := Device{Name: d.GetDisplayName(), Class: d.GetDeviceClass()}
+
+		if elem := d.CreateElement(""); elem != nil {
+			if v, err := elem.GetProperty("device"); err == nil {
+				dev.Path, _ = v.(string)
+			}
+		}
+
+		if caps := d.GetCaps(); caps != nil {
+			// lists are expanded, so every entry is a single format
+			caps = caps.Ref().Normalize()
+			for i := 0; i < caps.GetSize(); i++ {
+				dev.Caps = append(dev.Caps, caps.GetStructureAt(i).String())
+			}
+		}
+
+		devices = append(devices, dev)
+	}
+
+	return devices, nil
+}
+
+// DiscoverVideoFormat picks the format of the camera at the path closest to the target
+func DiscoverVideoFormat(path string, width uint, height uint, fps uint) (*VideoFormat, error) {
+	devices, err := Devices(ClassVideoSource)
+	if err != nil {
+		return nil, err
+	}
+
+	// the config may name a link like /dev/v4l/by-id/...
+	if p, err := filepath.EvalSymlinks(path); err == nil {
+		path = p
+	}
+
+	for _, d := range devices {
+		if d.Path == path {
+			return BestVideoFormat(d.Caps, width, height, fps)
+		}
+	}
+
+	return nil, fmt.Errorf("%s not found by the device monitor", path)
+}
+
+// NegotiatedCaps returns the caps on the src pads of the elements in the order of the data flow
+func NegotiatedCaps(pipeline *gst.Pipeline) ([]string, error) {
+	elems, err := pipeline.GetElementsSorted()
+	if err != nil {
+		return nil, err
+	}
+
+	var caps []string
+	// sorted from the sinks to the sources
+	for i := len(elems) - 1; i >= 0; i-- {
+		pad := elems[i].GetStaticPad("src")
+		if pad == nil {
+			continue
+		}
+
+		if c := pad.GetCurrentCaps(); c != nil {
+			caps = append(caps, elems[i].GetName()+": "+c.String())
+		}
+	}
+
+	return caps, nil
+}
@@ -0,0 +1,267 @@
+package streamer
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// VideoFormat is one of the modes a camera delivers
+type VideoFormat struct {
+	Media     string // video/x-raw or image/jpeg
+	Format    string // pixel format of raw video
+	Width     uint
+	Height    uint
+	Framerate string // fraction as in caps, e.g. 30/1
+	fps       float64
+}
+
+func (f *VideoFormat) String() string {
+	media := f.Media
+	if f.Format != "" {
+		media += " " + f.Format
+	}
+
+	return fmt.Sprintf("%s %dx%d@%s", media, f.Width, f.Height, f.Framerate)
+}
+
+func (f *VideoFormat) Compressed() bool {
+	return f.Media == "image/jpeg"
+}
+
+// Caps filters the src on this format
+func (f *VideoFormat) Caps() *Caps {
+	filter := map[string]any{
+		"width":     f.Width,
+		"height":    f.Height,
+		"framerate": f.Framerate,
+	}
+
+	if f.Format != "" {
+		filter["format"] = f.Format
+	}
+
+	return NewCaps(f.Media, filter)
+}
+
+// BestVideoFormat picks the format of the caps closest to the target, formats reaching the
+// target size and rate come first and compressed ones save usb bandwidth on equal terms
+func BestVideoFormat(caps []string, width uint, height uint, fps uint) (*VideoFormat, error) {
+	var best *VideoFormat
+	for _, c := range caps {
+		f, err := parseVideoFormat(c, width, height, fps)
+		if err != nil {
+			return nil, err
+		}
+		if f == nil {
+			continue
+		}
+
+		if best == nil || betterVideoFormat(f, best, width, height, fps) {
+			best = f
+		}
+	}
+
+	if best == nil {
+		return nil, fmt.Errorf("no raw or jpeg format among %d caps", len(caps))
+	}
+
+	return best, nil
+}
+
+func betterVideoFormat(a *VideoFormat, b *VideoFormat, width uint, height uint, fps uint) bool {
+	reaches := func(f *VideoFormat) bool {
+		return f.Width >= width && f.Height >= height && f.fps >= float64(fps)
+	}
+	if reaches(a) != reaches(b) {
+		return reaches(a)
+	}
+
+	area := func(f *VideoFormat) float64 {
+		return math.Abs(float64(f.Width)*float64(f.Height) - float64(width)*float64(height))
+	}
+	if area(a) != area(b) {
+		return area(a) < area(b)
+	}
+
+	if a.Compressed() != b.Compressed() {
+		return a.Compressed()
+	}
+
+	return math.Abs(a.fps-float64(fps)) < math.Abs(b.fps-float64(fps))
+}
+
+// parseVideoFormat reads a caps structure, ranges and lists are resolved against the target,
+// other media than raw and jpeg are skipped with nil
+func parseVideoFormat(s string, width uint, height uint, fps uint) (*VideoFormat, error) {
+	parts := splitTopLevel(s, ',')
+
+	f := VideoFormat{Media: strings.TrimSpace(parts[0])}
+	if f.Media != "video/x-raw" && f.Media != "image/jpeg" {
+		return nil, nil
+	}
+
+	for _, p := range parts[1:] {
+		name, value, ok := strings.Cut(strings.TrimSpace(p), "=")
+		if !ok {
+			continue
+		}
+		value = stripType(value)
+
+		var err error
+		switch name {
+		case "format":
+			// a list is left when not normalized, any of its formats does
+			f.Format = stripType(splitTopLevel(strings.Trim(value, "{ }"), ',')[0])
+		case "width":
+			var w float64
+			w, _, err = resolve(value, float64(width), parseNumber)
+			f.Width = uint(w)
+		case "height":
+			var h float64
+			h, _, err = resolve(value, float64(height), parseNumber)
+			f.Height = uint(h)
+		case "framerate":
+			var rate string
+			f.fps, rate, err = resolve(value, float64(fps), parseFraction)
+			f.Framerate = fraction(rate, fps)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid caps %q - %s", s, err)
+		}
+	}
+
+	if f.Width == 0 || f.Height == 0 || f.Framerate == "" {
+		return nil, nil
+	}
+
+	return &f, nil
+}
+
+// resolve takes a single value, a list or a range to the smallest number reaching the target,
+// without one to the largest. The value is also returned as written in the caps, it is empty
+// when the target lies within a range
+func resolve(value string, target float64, parse func(string) (float64, error)) (float64, string, error) {
+	value = strings.TrimSpace(value)
+
+	switch {
+	case strings.HasPrefix(value, "["):
+		bounds := splitTopLevel(strings.Trim(value, "[ ]"), ',')
+		if len(bounds) < 2 {
+			return 0, "", fmt.Errorf("invalid range %s", value)
+		}
+		lo, err := parse(stripType(bounds[0]))
+		if err != nil {
+			return 0, "", err
+		}
+		hi, err := parse(stripType(bounds[1]))
+		if err != nil {
+			return 0, "", err
+		}
+		switch {
+		case target <= lo:
+			return lo, stripType(bounds[0]), nil
+		case target >= hi:
+			return hi, stripType(bounds[1]), nil
+		}
+		return target, "", nil
+	case strings.HasPrefix(value, "{"):
+		var best float64
+		var text string
+		found := false
+		for _, v := range splitTopLevel(strings.Trim(value, "{ }"), ',') {
+			n, t, err := resolve(stripType(v), target, parse)
+			if err != nil {
+				return 0, "", err
+			}
+			if !found || nearer(n, best, target) {
+				best, text, found = n, t, true
+			}
+		}
+		return best, text, nil
+	}
+
+	n, err := parse(value)
+	return n, value, err
+}
+
+func nearer(a float64, b float64, target float64) bool {
+	if (a >= target) != (b >= target) {
+		return a >= target
+	}
+	if a >= target {
+		return a < b
+	}
+
+	return a > b
+}
+
+// fraction keeps the rate the device reported, the target is taken when it lies within a range
+func fraction(rate string, fps uint) string {
+	if rate == "" {
+		return fmt.Sprintf("%d/1", fps)
+	}
+
+	return rate
+}
+
+func parseNumber(s string) (float64, error) {
+	return strconv.ParseFloat(strings.TrimSpace(s), 64)
+}
+
+func parseFraction(s string) (float64, error) {
+	num, den, ok := strings.Cut(strings.TrimSpace(s), "/")
+	if !ok {
+		return parseNumber(s)
+	}
+
+	n, err := parseNumber(num)
+	if err != nil {
+		return 0, err
+	}
+	d, err := parseNumber(den)
+	if err != nil {
+		return 0, err
+	}
+	if d == 0 {
+		return 0, nil
+	}
+
+	return n / d, nil
+}
+
+// stripType removes the type of a caps value, e.g. (int)640
+func stripType(v string) string {
+	v = strings.TrimSpace(v)
+	if strings.HasPrefix(v, "(") {
+		if i := strings.Index(v, ")"); i > 0 {
+			return strings.TrimSpace(v[i+1:])
+		}
+	}
+
+	return v
+}
+
+// splitTopLevel splits outside of ranges, lists and quotes
+func splitTopLevel(s string, sep rune) []string {
+	var parts []string
+	depth, start, quoted := 0, 0, false
+
+	for i, r := range s {
+		switch {
+		case r == '"':
+			quoted = !quoted
+		case quoted:
+		case r == '[' || r == '{' || r == '<':
+			depth++
+		case r == ']' || r == '}' || r == '>':
+			depth--
+		case r == sep && depth == 0:
+			parts = append(parts, s[start:i])
+			start = i + 1
+		}
+	}
+
+	return append(parts, s[start:])
+}
@@ -0,0 +1,214 @@
+package streamer
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseVideoFormat(t *testing.T) {
+	tests := []struct {
+		name string
+		caps string
+		want *VideoFormat
+	}{
+		{
+			name: "fixed",
+			caps: "video/x-raw, format=(string)YUY2, width=(int)640, height=(int)480, framerate=(fraction)30/1",
+			want: &VideoFormat{Media: "video/x-raw", Format: "YUY2", Width: 640, Height: 480, Framerate: "30/1", fps: 30},
+		},
+		{
+			name: "fractional rate is kept",
+			caps: "image/jpeg, width=(int)1920, height=(int)1080, framerate=(fraction)15/2",
+			want: &VideoFormat{Media: "image/jpeg", Width: 1920, Height: 1080, Framerate: "15/2", fps: 7.5},
+		},
+		{
+			name: "list takes the lowest rate reaching the target",
+			caps: "image/jpeg, width=(int)1280, height=(int)720, framerate=(fraction){ 60/1, 30000/1001, 15/1 }",
+			want: &VideoFormat{Media: "image/jpeg", Width: 1280, Height: 720, Framerate: "60/1", fps: 60},
+		},
+		{
+			name: "list keeps the ntsc rate of the device",
+			caps: "image/jpeg, width=(int)1280, height=(int)720, framerate=(fraction){ 30000/1001, 15/1 }",
+			want: &VideoFormat{Media: "image/jpeg", Width: 1280, Height: 720, Framerate: "30000/1001", fps: 30000.0 / 1001},
+		},
+		{
+			name: "range takes the target",
+			caps: "video/x-raw, format=(string){ NV12, I420 }, width=(int)[ 32, 4096 ], height=(int)[ 32, 2160 ], framerate=(fraction)[ 1/1, 60/1 ]",
+			want: &VideoFormat{Media: "video/x-raw", Format: "NV12", Width: 1280, Height: 720, Framerate: "30/1", fps: 30},
+		},
+		{
+			name: "range is clamped to its bound",
+			caps: "video/x-raw, format=(string)NV12, width=(int)[ 32, 640 ], height=(int)[ 32, 480 ], framerate=(fraction)[ 1/1, 15/2 ]",
+			want: &VideoFormat{Media: "video/x-raw", Format: "NV12", Width: 640, Height: 480, Framerate: "15/2", fps: 7.5},
+		},
+		{
+			name: "other media is skipped",
+			caps: "video/x-h264, width=(int)1920, height=(int)1080, framerate=(fraction)30/1",
+		},
+		{
+			name: "incomplete caps are skipped",
+			caps: "video/x-raw, format=(string)YUY2",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseVideoFormat(tt.caps, 1280, 720, 30)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("expected %+v, got %+v", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestParseVideoFormatInvalid(t *testing.T) {
+	for _, caps := range []string{
+		"video/x-raw, width=(int)wide, height=(int)480, framerate=(fraction)30/1",
+		"video/x-raw, width=(int)[ 32 ], height=(int)480, framerate=(fraction)30/1",
+		"video/x-raw, width=(int)640, height=(int)480, framerate=(fraction)30/x",
+	} {
+		if _, err := parseVideoFormat(caps, 1280, 720, 30); err == nil {
+			t.Errorf("expected an error for %q", caps)
+		}
+	}
+}
+
+func TestBestVideoFormat(t *testing.T) {
+	tests := []struct {
+		name string
+		caps []string
+		want string
+	}{
+		{
+			name: "reaching the target wins",
+			caps: []string{
+				"video/x-raw, format=(string)YUY2, width=(int)640, height=(int)480, framerate=(fraction)30/1",
+				"video/x-raw, format=(string)YUY2, width=(int)1280, height=(int)720, framerate=(fraction)10/1",
+				"image/jpeg, width=(int)1920, height=(int)1080, framerate=(fraction)30/1",
+			},
+			want: "image/jpeg 1920x1080@30/1",
+		},
+		{
+			name: "nearer size wins",
+			caps: []string{
+				"image/jpeg, width=(int)3840, height=(int)2160, framerate=(fraction)30/1",
+				"image/jpeg, width=(int)1280, height=(int)720, framerate=(fraction)30/1",
+			},
+			want: "image/jpeg 1280x720@30/1",
+		},
+		{
+			name: "compressed wins on equal terms",
+			caps: []string{
+				"video/x-raw, format=(string)YUY2, width=(int)1280, height=(int)720, framerate=(fraction)30/1",
+				"image/jpeg, width=(int)1280, height=(int)720, framerate=(fraction)30/1",
+			},
+			want: "image/jpeg 1280x720@30/1",
+		},
+		{
+			name: "ntsc rate does not reach the target",
+			caps: []string{
+				"video/x-raw, format=(string)YUY2, width=(int)1280, height=(int)720, framerate=(fraction)60/1",
+				"video/x-raw, format=(string)YUY2, width=(int)1280, height=(int)720, framerate=(fraction)30000/1001",
+			},
+			want: "video/x-raw YUY2 1280x720@60/1",
+		},
+		{
+			name: "nearer rate wins",
+			caps: []string{
+				"video/x-raw, format=(string)YUY2, width=(int)1280, height=(int)720, framerate=(fraction)60/1",
+				"video/x-raw, format=(string)YUY2, width=(int)1280, height=(int)720, framerate=(fraction)30/1",
+			},
+			want: "video/x-raw YUY2 1280x720@30/1",
+		},
+		{
+			name: "largest without one reaching the target",
+			caps: []string{
+				"video/x-raw, format=(string)YUY2, width=(int)320, height=(int)240, framerate=(fraction)30/1",
+				"video/x-raw, format=(string)YUY2, width=(int)640, height=(int)480, framerate=(fraction)15/2",
+				"video/x-h264, width=(int)1920, height=(int)1080, framerate=(fraction)30/1",
+			},
+			want: "video/x-raw YUY2 640x480@15/2",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := BestVideoFormat(tt.caps, 1280, 720, 30)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got.String() != tt.want {
+				t.Fatalf("expected %s, got %s", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestBestVideoFormatNone(t *testing.T) {
+	caps := []string{"video/x-h264, width=(int)1920, height=(int)1080, framerate=(fraction)30/1"}
+	if _, err := BestVideoFormat(caps, 1280, 720, 30); err == nil {
+		t.Fatal("expected an error without raw or jpeg caps")
+	}
+}
+
+func TestResolve(t *testing.T) {
+	tests := []struct {
+		value  string
+		target float64
+		want   float64
+		text   string
+	}{
+		{"30/1", 15, 30, "30/1"},
+		{"[ 1/1, 60/1 ]", 30, 30, ""},
+		{"[ 1/1, 15/2 ]", 30, 7.5, "15/2"},
+		{"[ 10/1, 60/1 ]", 5, 10, "10/1"},
+		{"{ 60/1, 30/1, 15/1 }", 20, 30, "30/1"},
+		{"{ 15/1, 30000/1001 }", 30, 30000.0 / 1001, "30000/1001"},
+		{"{ (fraction)5/1, (fraction)[ 10/1, 20/1 ] }", 15, 15, ""},
+	}
+
+	for _, tt := range tests {
+		got, text, err := resolve(tt.value, tt.target, parseFraction)
+		if err != nil {
+			t.Fatalf("%s: %s", tt.value, err)
+		}
+		if got != tt.want || text != tt.text {
+			t.Errorf("%s: expected %v (%q), got %v (%q)", tt.value, tt.want, tt.text, got, text)
+		}
+	}
+}
+
+func TestSplitTopLevel(t *testing.T) {
+	tests := []struct {
+		s    string
+		want []string
+	}{
+		{"a, b", []string{"a", " b"}},
+		{"a, b={ c, d }, e=[ 1, 2 ]", []string{"a", " b={ c, d }", " e=[ 1, 2 ]"}},
+		{`a, b="c, d"`, []string{"a", ` b="c, d"`}},
+		{"a", []string{"a"}},
+	}
+
+	for _, tt := range tests {
+		if got := splitTopLevel(tt.s, ','); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: expected %q, got %q", tt.s, tt.want, got)
+		}
+	}
+}
+
+func TestStripType(t *testing.T) {
+	tests := map[string]string{
+		"(int)640":        "640",
+		" (string) YUY2 ": "YUY2",
+		"30/1":            "30/1",
+	}
+
+	for in, want := range tests {
+		if got := stripType(in); got != want {
+			t.Errorf("%q: expected %q, got %q", in, want, got)
+		}
+	}
+}
@@ -0,0 +1,251 @@
+package streamer
+
+import (
+	"fmt"
+	"path/filepath"
+	"time"
+
+	"github.com/go-gst/go-gst/gst"
+	"github.com/go-gst/go-gst/gst/app"
+	"github.com/pion/webrtc/v3/pkg/media"
+)
+
+const (
+	// tee the branches are split off from
+	streamTee = "stream-tee"
+	// tee of the frames decoded from a passthrough stream
+	decodedTee = "decoded-tee"
+
+	MJPEGValve = "mjpeg-valve"
+	HLSValve   = "hls-valve"
+
+	mjpegSink  = "mjpeg"
+	motionSink = "motion"
+	hlsSink    = "hls"
+)
+
+// Branch is a further output of the video pipeline
+type Branch struct {
+	// build returns the elements, raw tells whether it gets raw frames or the encoded stream of a passthrough pipeline
+	build func(raw bool) *PipelineBuilder
+	// decoded branches always get raw frames, a passthrough stream is decoded once for all of them
+	decoded bool
+}
+
+// addBranches appends the branches to a launch pipeline containing the tee
+func addBranches(pb *PipelineBuilder, raw bool, branches []Branch) {
+	pb.AddWithProperties("tee", map[string]any{
+		"name": streamTee,
+	})
+
+	var decoded []Branch
+	for _, b := range branches {
+		if b.decoded && !raw {
+			decoded = append(decoded, b)
+			continue
+		}
+
+		pb.AddBranch(NewPipelineBuilder().Add(streamTee + ".").Append(b.build(raw)))
+	}
+
+	if len(decoded) == 0 {
+		return
+	}
+
+	// encoded frames must not be dropped before the decoder, the branches drop the decoded ones
+	dec := NewPipelineBuilder().Add(streamTee + ".").Add("queue").Add("decodebin").Add("videoconvert")
+	dec.AddWithProperties("tee", map[string]any{
+		"name": decodedTee,
+	})
+	pb.AddBranch(dec)
+
+	for _, b := range decoded {
+		pb.AddBranch(NewPipelineBuilder().Add(decodedTee + ".").Append(b.build(true)))
+	}
+}
+
+// linkBranches adds the branches as bins to an element based pipeline and links them to the tee
+func linkBranches(pipeline *gst.Pipeline, tee *gst.Element, branches []Branch) error {
+	for _, b := range branches {
+		bin, err := gst.NewBinFromString(b.build(true).Build(), true)
+		if err != nil {
+			return err
+		}
+
+		if err := pipeline.Add(bin.Element); err != nil {
+			return err
+		}
+
+		if err := tee.Link(bin.Element); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// closedValve drops everything until a consumer shows up
+func closedValve(pb *PipelineBuilder, name string) {
+	pb.AddWithProperties("valve", map[string]any{
+		"name": name,
+		"drop": true,
+	})
+}
+
+// MJPEGBranch encodes jpegs at the given rate for multipart streams
+func MJPEGBranch(fps uint) Branch {
+	return Branch{decoded: true, build: func(bool) *PipelineBuilder {
+		pb := NewPipelineBuilder()
+		pb.AddWithProperties("queue", map[string]any{
+			"leaky":            "downstream",
+			"max-size-buffers": int(1),
+		})
+		closedValve(pb, MJPEGValve)
+		pb.Add("videoconvert")
+		pb.AddWithProperties("videorate", map[string]any{
+			"drop-only": true,
+		})
+		pb.AddFilter(NewCaps("video/x-raw", map[string]any{
+			"framerate": fmt.Sprint(fps, "/1"),
+		}))
+		pb.Add("jpegenc")
+		pb.AddWithProperties("appsink", map[string]any{
+			"name":        mjpegSink,
+			"max-buffers": int(1),
+			"drop":        true,
+			"sync":        false,
+			"async":       false,
+		})
+
+		return pb
+	}}
+}
+
+// HLSBranch writes the stream to dir in parts of the given duration, each one starting
+// with a keyframe. The parts are packaged into segments and the playlist by the caller,
+// see NewHLSSink. The encoded stream of a passthrough pipeline is split as it is, so
+// its parts are as long as the keyframe interval of the camera
+func HLSBranch(dir string, part time.Duration, fps uint) Branch {
+	return Branch{build: func(raw bool) *PipelineBuilder {
+		pb := NewPipelineBuilder()
+		if raw {
+			pb.AddWithProperties("queue", map[string]any{
+				"leaky":            "downstream",
+				"max-size-buffers": int(2),
+			})
+		} else {
+			// dropping encoded frames breaks the stream
+			pb.Add("queue")
+		}
+		closedValve(pb, HLSValve)
+		if raw {
+			pb.Add("videoconvert")
+			pb.AddWithProperties("x264enc", map[string]any{
+				"speed-preset": "ultrafast",
+				"tune":         "zerolatency",
+				"key-int-max":  max(int(part.Seconds()*float64(fps)), 1),
+			})
+			pb.Add("h264parse")
+		}
+		pb.AddWithProperties("splitmuxsink", map[string]any{
+			"name":                   hlsSink,
+			"muxer-factory":          "mpegtsmux",
+			"location":               fmt.Sprintf("%q", filepath.Join(dir, "part%05d.ts")),
+			"max-size-time":          uint64(part.Nanoseconds()),
+			"send-keyframe-requests": true,
+		})
+
+		return pb
+	}}
+}
+
+// MotionBranch scales the frames down to grayscale for the motion detection
+func MotionBranch(width uint, height uint, fps uint) Branch {
+	return Branch{decoded: true, build: func(bool) *PipelineBuilder {
+		pb := NewPipelineBuilder()
+		pb.AddWithProperties("queue", map[string]any{
+			"leaky":            "downstream",
+			"max-size-buffers": int(1),
+		})
+		pb.Add("videoconvert")
+		pb.Add("videoscale")
+		pb.AddWithProperties("videorate", map[string]any{
+			"drop-only": true,
+		})
+		pb.AddFilter(NewCaps("video/x-raw", map[string]any{
+			"format":    "GRAY8",
+			"width":     width,
+			"height":    height,
+			"framerate": fmt.Sprint(fps, "/1"),
+		}))
+		pb.AddWithProperties("appsink", map[string]any{
+			"name":        motionSink,
+			"max-buffers": int(1),
+			"drop":        true,
+			"sync":        false,
+		})
+
+		return pb
+	}}
+}
+
+// SetValve lets the named valve pass or drop the buffers
+func SetValve(pipeline *gst.Pipeline, name string, open bool) error {
+	elem, err := pipeline.GetElementByName(name)
+	if err != nil {
+		return err
+	}
+
+	return elem.SetProperty("drop", !open)
+}
+
+func newBranchSink(pipeline *gst.Pipeline, name string) (<-chan media.Sample, error) {
+	elem, err := pipeline.GetElementByName(name)
+	if err != nil {
+		return nil, err
+	}
+
+	ch := make(chan media.Sample, 10)
+	setCallback(app.SinkFromElement(elem), ch, name)
+
+	return ch, nil
+}
+
+// NewMJPEGSink delivers the jpegs of the mjpeg branch
+func NewMJPEGSink(pipeline *gst.Pipeline) (<-chan media.Sample, error) {
+	return newBranchSink(pipeline, mjpegSink)
+}
+
+// NewMotionSink delivers the grayscale frames of the motion branch
+func NewMotionSink(pipeline *gst.Pipeline) (<-chan media.Sample, error) {
+	return newBranchSink(pipeline, motionSink)
+}
+
+// NewHLSSink hands each part of the hls branch to onPart once it is complete, that is when
+// splitmuxsink opens the next one. The durations are taken from the clock, the branch is live
+func NewHLSSink(pipeline *gst.Pipeline, onPart func(name string, duration time.Duration)) error {
+	elem, err := pipeline.GetElementByName(hlsSink)
+	if err != nil {
+		return err
+	}
+
+	var (
+		location string
+		opened   time.Time
+	)
+
+	_, err = elem.Connect("format-location", func(self *gst.Element, id uint) string {
+		now := time.Now()
+		if id > 0 {
+			onPart(location, now.Sub(opened))
+		}
+
+		location, opened = fmt.Sprintf("part%05d.ts", id), now
+
+		// the previous file is closed already, the next one goes next to it
+		prev, _ := self.GetProperty("location")
+		return filepath.Join(filepath.Dir(fmt.Sprint(prev)), location)
+	})
+
+	return err
+}
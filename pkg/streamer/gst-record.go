@@ -0,0 +1,118 @@
+package streamer
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/go-gst/go-gst/gst"
+	"github.com/go-gst/go-gst/gst/app"
+	"github.com/kaedwen/webrtc/pkg/common"
+	"go.uber.org/zap"
+)
+
+// time the muxer gets to finalize the file
+const finalizeTimeout = 10 * time.Second
+
+// RecordPipeline muxes already encoded samples into a file
+type RecordPipeline struct {
+	*gst.Pipeline
+	video *app.Source
+	audio *app.Source
+}
+
+// RecordingExtension returns the container used for the given video codec
+func RecordingExtension(codec common.StreamCodec) string {
+	switch codec {
+	case common.H264, common.H265:
+		return ".mp4"
+	default:
+		return ".webm"
+	}
+}
+
+// CreateRecordPipeline writes the video and opus samples to location, with rtp set the
+// video samples are rtp packets as delivered by the passthrough pipeline
+func CreateRecordPipeline(lg *zap.Logger, codec common.StreamCodec, rtp bool, channels uint, location string) (*RecordPipeline, error) {
+	var mux, video string
+
+	switch codec {
+	case common.VP8:
+		mux, video = "webmmux", "video/x-vp8"
+	case common.VP9:
+		mux, video = "webmmux", "video/x-vp9"
+	case common.H264:
+		mux, video = "mp4mux", "video/x-h264,stream-format=byte-stream,alignment=au ! h264parse"
+		if rtp {
+			video = "application/x-rtp,media=video,clock-rate=90000,encoding-name=H264,payload=96 ! rtph264depay ! h264parse"
+		}
+	case common.H265:
+		mux, video = "mp4mux", "video/x-h265,stream-format=byte-stream,alignment=au ! h265parse"
+		if rtp {
+			video = "application/x-rtp,media=video,clock-rate=90000,encoding-name=H265,payload=96 ! rtph265depay ! h265parse"
+		}
+	default:
+		return nil, fmt.Errorf("unsupported recording codec given - %s", codec)
+	}
+
+	ps := fmt.Sprintf("appsrc name=video format=time ! %s ! queue ! %s name=mux ! filesink location=%q "+
+		"appsrc name=audio format=time ! audio/x-opus,channels=%d,rate=48000,channel-mapping-family=0 ! opusparse ! queue ! mux.",
+		video, mux, location, channels)
+	lg.Info("launch pipeline", zap.String("definition", ps))
+
+	pipeline, err := gst.NewPipelineFromString(ps)
+	if err != nil {
+		return nil, err
+	}
+
+	p := RecordPipeline{Pipeline: pipeline}
+	for name, src := range map[string]**app.Source{"video": &p.video, "audio": &p.audio} {
+		elem, err := pipeline.GetElementByName(name)
+		if err != nil {
+			return nil, err
+		}
+		*src = app.SrcFromElement(elem)
+	}
+
+	return &p, nil
+}
+
+func push(src *app.Source, data []byte, pts time.Duration, duration time.Duration) error {
+	buffer := gst.NewBufferFromBytes(data)
+	buffer.SetPresentationTimestamp(gst.ClockTime(pts.Nanoseconds()))
+	if duration > 0 {
+		buffer.SetDuration(gst.ClockTime(duration.Nanoseconds()))
+	}
+
+	if ret := src.PushBuffer(buffer); ret != gst.FlowOK {
+		return fmt.Errorf("failed to send bytes to gst pipeline - %s", ret.String())
+	}
+
+	return nil
+}
+
+func (p *RecordPipeline) PushVideo(data []byte, pts time.Duration, duration time.Duration) error {
+	return push(p.video, data, pts, duration)
+}
+
+func (p *RecordPipeline) PushAudio(data []byte, pts time.Duration, duration time.Duration) error {
+	return push(p.audio, data, pts, duration)
+}
+
+// Finish ends both streams, waits for the muxer to write the file and stops the pipeline
+func (p *RecordPipeline) Finish() error {
+	defer p.SetState(gst.StateNull)
+
+	p.video.EndStream()
+	p.audio.EndStream()
+
+	msg := p.GetPipelineBus().TimedPopFiltered(gst.ClockTime(finalizeTimeout.Nanoseconds()), gst.MessageEOS|gst.MessageError)
+	if msg == nil {
+		return fmt.Errorf("recording was not finalized in time")
+	}
+
+	if msg.Type() == gst.MessageError {
+		return msg.ParseError()
+	}
+
+	return nil
+}
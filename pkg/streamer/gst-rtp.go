@@ -0,0 +1,51 @@
+package streamer
+
+import (
+	"fmt"
+
+	"github.com/go-gst/go-gst/gst"
+	"github.com/kaedwen/webrtc/pkg/common"
+	"github.com/pion/webrtc/v3/pkg/media"
+	"go.uber.org/zap"
+)
+
+// CreateVideoPipelineRTP pulls an already encoded stream from an ip camera and only
+// repacketizes it, every sample carries one complete rtp packet. The branches
+// get the encoded stream
+func CreateVideoPipelineRTP(lg *zap.Logger, s StreamElement) (*gst.Pipeline, <-chan media.Sample, error) {
+	if s.Kind != "rtspsrc" {
+		return nil, nil, fmt.Errorf("passthrough not supported for video source - %s", s.Kind)
+	}
+
+	pb := NewPipelineBuilder()
+	pb.AddWithProperties(s.Kind, s.Properties)
+
+	switch s.Codec {
+	case common.H264:
+		pb.Add("rtph264depay")
+		pb.AddWithProperties("h264parse", map[string]any{
+			"config-interval": int(-1),
+		})
+		addBranches(pb, false, s.Branches)
+		pb.AddWithProperties("rtph264pay", map[string]any{
+			"config-interval": int(-1),
+			"mtu":             int(1200),
+			"pt":              int(96),
+		})
+	case common.H265:
+		pb.Add("rtph265depay")
+		pb.AddWithProperties("h265parse", map[string]any{
+			"config-interval": int(-1),
+		})
+		addBranches(pb, false, s.Branches)
+		pb.AddWithProperties("rtph265pay", map[string]any{
+			"config-interval": int(-1),
+			"mtu":             int(1200),
+			"pt":              int(96),
+		})
+	default:
+		return nil, nil, fmt.Errorf("unsupported passthrough codec given - %s", s.Codec)
+	}
+
+	return launchWithAppSink(lg, pb, "video")
+}
@@ -2,15 +2,21 @@ package streamer
 
 import (
 	"fmt"
+	"time"
 
 	"github.com/go-gst/go-gst/gst"
 	"github.com/go-gst/go-gst/gst/app"
 	"github.com/kaedwen/webrtc/pkg/common"
+	"github.com/kaedwen/webrtc/pkg/metrics"
 	"github.com/pion/webrtc/v3/pkg/media"
 	"go.uber.org/zap"
 )
 
-func setCallback(sink *app.Sink, ch chan<- media.Sample) {
+// name of the video encoder, the bitrate is changed on it while running
+const videoEncoder = "encoder"
+
+// setCallback hands the samples of the sink to ch, the track labels the dropped ones
+func setCallback(sink *app.Sink, ch chan<- media.Sample, track string) {
 	sink.SetCallbacks(&app.SinkCallbacks{
 		NewSampleFunc: func(sink *app.Sink) gst.FlowReturn {
 			// Pull the sample that triggered this callback
@@ -31,19 +37,77 @@ func setCallback(sink *app.Sink, ch chan<- media.Sample) {
 			data := buffer.Map(gst.MapRead).AsUint8Slice()
 			defer buffer.Unmap()
 
-			ch <- media.Sample{Data: data, Duration: *buffer.Duration().AsDuration()}
+			// rtp packets and some decoders leave the duration unset
+			var duration time.Duration
+			if d := buffer.Duration().AsDuration(); d != nil {
+				duration = *d
+			}
+
+			// the streaming thread must not wait for a slow reader, the sample is dropped instead
+			select {
+			case ch <- media.Sample{Data: data, Duration: duration}:
+			default:
+				metrics.SamplesDropped.WithLabelValues(track).Inc()
+			}
 
 			return gst.FlowOK
 		},
 	})
 }
 
+// createStage creates the element for the given stage including its caps
+func createStage(lg *zap.Logger, s StreamElement) (ElementList, error) {
+	elem, err := gst.NewElement(s.Kind)
+	if err != nil {
+		return nil, err
+	}
+
+	for name, value := range s.Properties {
+		elem.Set(name, value)
+	}
+
+	if s.Dynamic {
+		elems := ElementList{NewDynamicElement(elem)}
+
+		// pads are not there yet, so filter with a dedicated element
+		if s.SrcCaps != nil {
+			c := s.SrcCaps.Build()
+			lg.Info("capsfilter", zap.String("caps", c))
+
+			filter, err := gst.NewElementWithProperties("capsfilter", map[string]interface{}{
+				"caps": gst.NewCapsFromString(c),
+			})
+			if err != nil {
+				return nil, err
+			}
+			elems = append(elems, NewElement(filter, nil, nil))
+		}
+
+		return elems, nil
+	}
+
+	if s.SrcCaps != nil {
+		c := s.SrcCaps.Build()
+		lg.Info("capsfilter", zap.String("caps", c))
+		return ElementList{NewElement(elem, nil, gst.NewCapsFromString(c))}, nil
+	}
+
+	return ElementList{NewElement(elem, nil, nil)}, nil
+}
+
 func CreateVideoPipelineSinkWithLaunch(lg *zap.Logger, s StreamElement) (*gst.Pipeline, <-chan media.Sample, error) {
 	pb := NewPipelineBuilder()
 
-	pb.AddWithProperties("v4l2src", s.Properties)
+	pb.AddWithProperties(s.Kind, s.Properties)
 	pb.AddFilter(s.SrcCaps)
+
+	for _, d := range s.Decode {
+		pb.AddWithProperties(d.Kind, d.Properties)
+		pb.AddFilter(d.SrcCaps)
+	}
+
 	pb.Add("videoconvert")
+	addBranches(pb, true, s.Branches)
 
 	if s.Queue {
 		pb.Add("queue")
@@ -52,6 +116,7 @@ func CreateVideoPipelineSinkWithLaunch(lg *zap.Logger, s StreamElement) (*gst.Pi
 	switch s.Codec {
 	case common.VP8:
 		pb.AddWithProperties("vp8enc", map[string]any{
+			"name":              videoEncoder,
 			"target-bitrate":    s.Bitrate,
 			"error-resilient":   "partitions",
 			"keyframe-max-dist": int(10),
@@ -61,13 +126,15 @@ func CreateVideoPipelineSinkWithLaunch(lg *zap.Logger, s StreamElement) (*gst.Pi
 		})
 	case common.VP9:
 		pb.AddWithProperties("vp9enc", map[string]any{
-			"bitrate": s.Bitrate,
+			"name":           videoEncoder,
+			"target-bitrate": s.Bitrate,
 		})
 	case common.H264:
 		pb.AddFilter(NewCaps("video/x-raw", map[string]any{
 			"format": "I420",
 		}))
 		pb.AddWithProperties("x264enc", map[string]any{
+			"name":         videoEncoder,
 			"bitrate":      s.Bitrate,
 			"speed-preset": "ultrafast",
 			"tune":         "zerolatency",
@@ -80,6 +147,11 @@ func CreateVideoPipelineSinkWithLaunch(lg *zap.Logger, s StreamElement) (*gst.Pi
 		return nil, nil, fmt.Errorf("unsupported video codec given - %s", s.Codec)
 	}
 
+	return launchWithAppSink(lg, pb, "video")
+}
+
+// launchWithAppSink terminates the given pipeline with an appsink and launches it
+func launchWithAppSink(lg *zap.Logger, pb *PipelineBuilder, track string) (*gst.Pipeline, <-chan media.Sample, error) {
 	pb.AddWithProperties("appsink", map[string]any{
 		"name": "appsink",
 	})
@@ -99,7 +171,7 @@ func CreateVideoPipelineSinkWithLaunch(lg *zap.Logger, s StreamElement) (*gst.Pi
 
 	appsink := app.SinkFromElement(elem)
 	ch := make(chan media.Sample, 100)
-	setCallback(appsink, ch)
+	setCallback(appsink, ch, track)
 
 	return pipeline, ch, nil
 }
@@ -111,24 +183,19 @@ func CreateVideoPipelineSink(lg *zap.Logger, s StreamElement) (*gst.Pipeline, <-
 		return nil, nil, err
 	}
 
-	elems := make(ElementList, 0)
-
 	// Create the src
-	src, err := gst.NewElement("v4l2src")
+	elems, err := createStage(lg, s)
 	if err != nil {
 		return nil, nil, err
 	}
 
-	for name, value := range s.Properties {
-		src.Set(name, value)
-	}
-
-	if s.SrcCaps != nil {
-		c := s.SrcCaps.Build()
-		lg.Info("capsfilter", zap.String("caps", c))
-		elems = append(elems, NewElement(src, nil, gst.NewCapsFromString(c)))
-	} else {
-		elems = append(elems, NewElement(src, nil, nil))
+	// and the optional decode stages
+	for _, d := range s.Decode {
+		de, err := createStage(lg, d)
+		if err != nil {
+			return nil, nil, err
+		}
+		elems = append(elems, de...)
 	}
 
 	// just to be on the save side
@@ -138,6 +205,13 @@ func CreateVideoPipelineSink(lg *zap.Logger, s StreamElement) (*gst.Pipeline, <-
 	}
 	elems = append(elems, NewElement(conv, nil, nil))
 
+	// split off the raw frames for the further outputs
+	tee, err := gst.NewElement("tee")
+	if err != nil {
+		return nil, nil, err
+	}
+	elems = append(elems, NewElement(tee, nil, nil))
+
 	if s.Queue {
 		// add a queue
 		queue, err := gst.NewElement("queue")
@@ -149,7 +223,7 @@ func CreateVideoPipelineSink(lg *zap.Logger, s StreamElement) (*gst.Pipeline, <-
 
 	switch s.Codec {
 	case common.VP8:
-		enc, err := gst.NewElement("vp8enc")
+		enc, err := gst.NewElementWithName("vp8enc", videoEncoder)
 		if err != nil {
 			return nil, nil, err
 		}
@@ -163,7 +237,7 @@ func CreateVideoPipelineSink(lg *zap.Logger, s StreamElement) (*gst.Pipeline, <-
 
 		elems = append(elems, NewElement(enc, nil, nil))
 	case common.VP9:
-		enc, err := gst.NewElement("vp9enc")
+		enc, err := gst.NewElementWithName("vp9enc", videoEncoder)
 		if err != nil {
 			return nil, nil, err
 		}
@@ -172,7 +246,7 @@ func CreateVideoPipelineSink(lg *zap.Logger, s StreamElement) (*gst.Pipeline, <-
 
 		elems = append(elems, NewElement(enc, nil, nil))
 	case common.H264:
-		enc, err := gst.NewElement("x264enc")
+		enc, err := gst.NewElementWithName("x264enc", videoEncoder)
 		if err != nil {
 			return nil, nil, err
 		}
@@ -201,7 +275,7 @@ func CreateVideoPipelineSink(lg *zap.Logger, s StreamElement) (*gst.Pipeline, <-
 	elems = append(elems, NewElement(appsink.Element, nil, nil))
 
 	ch := make(chan media.Sample, 100)
-	setCallback(appsink, ch)
+	setCallback(appsink, ch, "video")
 
 	// Add the elements to the pipeline
 	err = pipeline.AddMany(elems.List()...)
@@ -214,10 +288,28 @@ func CreateVideoPipelineSink(lg *zap.Logger, s StreamElement) (*gst.Pipeline, <-
 	if err != nil {
 		return nil, nil, err
 	}
+	err = linkBranches(pipeline, tee, s.Branches)
+	if err != nil {
+		return nil, nil, err
+	}
 
 	return pipeline, ch, nil
 }
 
+// SetBitrate changes the bitrate of the running video encoder
+func SetBitrate(pipeline *gst.Pipeline, codec common.StreamCodec, bitrate uint) error {
+	enc, err := pipeline.GetElementByName(videoEncoder)
+	if err != nil {
+		return err
+	}
+
+	if codec == common.H264 {
+		return enc.SetProperty("bitrate", bitrate)
+	}
+
+	return enc.SetProperty("target-bitrate", bitrate)
+}
+
 func CreateAudioPipelineSink(s StreamElement, lg *zap.Logger) (*gst.Pipeline, <-chan media.Sample, error) {
 	// Create a pipeline
 	pipeline, err := gst.NewPipeline("pion-audio-pipeline")
@@ -281,7 +373,7 @@ func CreateAudioPipelineSink(s StreamElement, lg *zap.Logger) (*gst.Pipeline, <-
 	elems = append(elems, NewElement(appsink.Element, nil, nil))
 
 	ch := make(chan media.Sample, 100)
-	setCallback(appsink, ch)
+	setCallback(appsink, ch, "audio")
 
 	// Add the elements to the pipeline
 	err = pipeline.AddMany(elems.List()...)
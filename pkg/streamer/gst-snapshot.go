@@ -0,0 +1,67 @@
+package streamer
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/go-gst/go-gst/gst"
+	"github.com/go-gst/go-gst/gst/app"
+)
+
+const snapshotSink = "snapshot"
+
+// SnapshotBranch encodes at most one frame per second to jpeg, the sink only holds the latest one
+func SnapshotBranch() Branch {
+	return Branch{decoded: true, build: func(bool) *PipelineBuilder {
+		pb := NewPipelineBuilder()
+		pb.AddWithProperties("queue", map[string]any{
+			"leaky":            "downstream",
+			"max-size-buffers": int(1),
+		})
+		pb.Add("videoconvert")
+		pb.AddWithProperties("videorate", map[string]any{
+			"drop-only": true,
+		})
+		pb.AddFilter(NewCaps("video/x-raw", map[string]any{
+			"framerate": "1/1",
+		}))
+		pb.Add("jpegenc")
+		pb.AddWithProperties("appsink", map[string]any{
+			"name":        snapshotSink,
+			"max-buffers": int(1),
+			"drop":        true,
+			"sync":        false,
+		})
+
+		return pb
+	}}
+}
+
+// SnapshotSink hands out the latest jpeg of the snapshot branch
+type SnapshotSink struct {
+	sink *app.Sink
+}
+
+func NewSnapshotSink(pipeline *gst.Pipeline) (*SnapshotSink, error) {
+	elem, err := pipeline.GetElementByName(snapshotSink)
+	if err != nil {
+		return nil, err
+	}
+
+	return &SnapshotSink{app.SinkFromElement(elem)}, nil
+}
+
+// Pull waits up to timeout for the next jpeg
+func (s *SnapshotSink) Pull(timeout time.Duration) ([]byte, error) {
+	sample := s.sink.TryPullSample(gst.ClockTime(timeout.Nanoseconds()))
+	if sample == nil {
+		return nil, fmt.Errorf("no snapshot within %s", timeout)
+	}
+
+	buffer := sample.GetBuffer()
+	if buffer == nil {
+		return nil, fmt.Errorf("snapshot without buffer")
+	}
+
+	return buffer.Bytes(), nil
+}
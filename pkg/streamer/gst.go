@@ -1,6 +1,10 @@
 package streamer
 
 import (
+	"context"
+	"strings"
+	"time"
+
 	"github.com/go-gst/go-gst/gst"
 	"github.com/go-gst/go-gst/gst/app"
 	"go.uber.org/zap"
@@ -14,12 +18,36 @@ type Element struct {
 	*gst.Element
 	preFilter  *gst.Caps
 	postFilter *gst.Caps
+	dynamic    bool
 }
 
 type ElementList []Element
 
 func NewElement(element *gst.Element, pre *gst.Caps, post *gst.Caps) Element {
-	return Element{element, pre, post}
+	return Element{element, pre, post, false}
+}
+
+// NewDynamicElement creates an element whose src pads are added at runtime
+func NewDynamicElement(element *gst.Element) Element {
+	return Element{element, nil, nil, true}
+}
+
+func linkDynamic(src Element, dst Element) error {
+	_, err := src.Connect("pad-added", func(self *gst.Element, pad *gst.Pad) {
+		sink := dst.GetStaticPad("sink")
+		if sink == nil || sink.IsLinked() {
+			return
+		}
+
+		// only care about the video stream
+		if caps := pad.GetCurrentCaps(); caps != nil && !strings.Contains(caps.String(), "video") {
+			return
+		}
+
+		pad.Link(sink)
+	})
+
+	return err
 }
 
 func (elems ElementList) Link() error {
@@ -29,7 +57,11 @@ func (elems ElementList) Link() error {
 			continue
 		}
 		pe := elems[idx-1]
-		if pe.postFilter != nil {
+		if pe.dynamic {
+			if err := linkDynamic(pe, elem); err != nil {
+				return err
+			}
+		} else if pe.postFilter != nil {
 			if err := pe.LinkFiltered(elem.Element, pe.postFilter); err != nil {
 				return err
 			}
@@ -66,19 +98,29 @@ func handleMessage(msg *gst.Message) error {
 	return nil
 }
 
-func LoopBus(lg *zap.Logger, pipeline *gst.Pipeline) {
+// the bus loop notices a done context within this interval
+const busPollInterval = time.Second
+
+// LoopBus logs the messages of the pipeline bus, errors are handed to the optional onError
+func LoopBus(ctx context.Context, lg *zap.Logger, pipeline *gst.Pipeline, onError func(error)) {
 	// Retrieve the bus from the pipeline
 	bus := pipeline.GetPipelineBus()
 
-	// Loop over messsages from the pipeline
+	// Loop over messsages from the pipeline until the context is done
 	go func() {
 		for {
-			msg := bus.TimedPop(gst.ClockTimeNone)
-			if msg == nil {
+			msg := bus.TimedPop(gst.ClockTime(busPollInterval.Nanoseconds()))
+			if ctx.Err() != nil {
 				return
 			}
+			if msg == nil {
+				continue
+			}
 			if err := handleMessage(msg); err != nil {
 				lg.Error("failed to handle message", zap.Error(err))
+				if onError != nil {
+					onError(err)
+				}
 			}
 		}
 	}()
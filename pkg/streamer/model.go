@@ -15,6 +15,11 @@ type StreamElement struct {
 	SrcCaps    *Caps
 	EnvCaps    *Caps
 	Queue      bool
+	Dynamic    bool            // src pads only show up at runtime (e.g. decodebin)
+	Decode     []StreamElement // stages between the src and the encoder
+	Branches   []Branch        // further outputs split off before the encoder
+	Format     *VideoFormat    // chosen from the capabilities of the device, nil when configured
+	Warning    error           // why the configured caps are used instead of a chosen format
 }
 
 type Caps struct {
@@ -27,6 +32,10 @@ func NewCaps(mime string, filter map[string]any) *Caps {
 }
 
 func (c *Caps) Build() string {
+	if len(c.filter) == 0 {
+		return c.mime
+	}
+
 	fv := make([]string, 0, len(c.filter))
 	for k, v := range c.filter {
 		fv = append(fv, fmt.Sprint(k, "=", v))
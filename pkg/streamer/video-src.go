@@ -0,0 +1,169 @@
+package streamer
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/kaedwen/webrtc/pkg/common"
+)
+
+type videoSourceFactory func(cfg *common.ConfigVideoSourceStream) (*StreamElement, error)
+
+// supported video sources, each one knows its properties, caps and decode stage
+var videoSources = map[string]videoSourceFactory{
+	"v4l2src":      newV4l2Source,
+	"libcamerasrc": newLibcameraSource,
+	"videotestsrc": newTestSource,
+	"rtspsrc":      newRtspSource,
+	"filesrc":      newFileSource,
+}
+
+func NewVideoSourceElement(cfg *common.ConfigVideoSourceStream) (*StreamElement, error) {
+	f, ok := videoSources[cfg.Source]
+	if !ok {
+		return nil, fmt.Errorf("unsupported video source given - %s", cfg.Source)
+	}
+
+	return f(cfg)
+}
+
+// format of the v4l2 source chosen from the capabilities of the camera
+const AutoFormat = "auto"
+
+func IsCompressedFormat(format string) bool {
+	switch strings.ToUpper(format) {
+	case "MJPG", "MJPEG", "JPEG":
+		return true
+	}
+
+	return false
+}
+
+func rawCaps(cfg *common.ConfigVideoSourceStream, format string) *Caps {
+	filter := map[string]any{
+		"height":    cfg.Height,
+		"width":     cfg.Width,
+		"framerate": fmt.Sprintf("%d/1", cfg.Framerate),
+	}
+
+	if format != "" {
+		filter["format"] = format
+	}
+
+	return NewCaps("video/x-raw", filter)
+}
+
+// scale and rate stage for sources which deliver whatever they have
+func normalizeStages(cfg *common.ConfigVideoSourceStream) []StreamElement {
+	return []StreamElement{
+		{Kind: "videoconvert"},
+		{Kind: "videoscale"},
+		{Kind: "videorate", SrcCaps: rawCaps(cfg, "")},
+	}
+}
+
+func newV4l2Source(cfg *common.ConfigVideoSourceStream) (*StreamElement, error) {
+	s := StreamElement{
+		Kind: "v4l2src",
+		Properties: map[string]any{
+			"device": cfg.Device,
+		},
+	}
+
+	var f *VideoFormat
+	if strings.EqualFold(cfg.Format, AutoFormat) {
+		var err error
+		f, err = DiscoverVideoFormat(cfg.Device, cfg.Width, cfg.Height, cfg.Framerate)
+		if err != nil {
+			// without udev or when the monitor misses the camera the configured size and rate still do
+			s.Warning = fmt.Errorf("failed to discover video format, using the configured caps - %s", err)
+		}
+	}
+
+	if f != nil {
+		s.Format = f
+
+		s.SrcCaps = f.Caps()
+		if f.Compressed() {
+			s.Decode = []StreamElement{{Kind: "jpegdec"}}
+		}
+
+		// the camera is brought to the configured size and rate when it has no exact match
+		if f.Width != cfg.Width || f.Height != cfg.Height || f.Framerate != fmt.Sprintf("%d/1", cfg.Framerate) {
+			s.Decode = append(s.Decode, normalizeStages(cfg)...)
+		}
+	} else if IsCompressedFormat(cfg.Format) {
+		// usb cameras deliver mjpeg at higher resolutions
+		s.SrcCaps = NewCaps("image/jpeg", map[string]any{
+			"height":    cfg.Height,
+			"width":     cfg.Width,
+			"framerate": fmt.Sprintf("%d/1", cfg.Framerate),
+		})
+		s.Decode = []StreamElement{{Kind: "jpegdec"}}
+	} else if s.Warning != nil {
+		s.SrcCaps = rawCaps(cfg, "")
+	} else {
+		s.SrcCaps = rawCaps(cfg, cfg.Format)
+	}
+
+	return &s, nil
+}
+
+func newLibcameraSource(cfg *common.ConfigVideoSourceStream) (*StreamElement, error) {
+	s := StreamElement{
+		Kind:       "libcamerasrc",
+		Properties: map[string]any{},
+		// libcamera picks the format itself
+		SrcCaps: rawCaps(cfg, ""),
+	}
+
+	if cfg.Location != nil {
+		s.Properties["camera-name"] = *cfg.Location
+	}
+
+	return &s, nil
+}
+
+func newTestSource(cfg *common.ConfigVideoSourceStream) (*StreamElement, error) {
+	return &StreamElement{
+		Kind: "videotestsrc",
+		Properties: map[string]any{
+			"is-live": true,
+		},
+		SrcCaps: rawCaps(cfg, ""),
+	}, nil
+}
+
+func newRtspSource(cfg *common.ConfigVideoSourceStream) (*StreamElement, error) {
+	if cfg.Location == nil {
+		return nil, fmt.Errorf("rtspsrc needs a location")
+	}
+
+	return &StreamElement{
+		Kind: "rtspsrc",
+		Properties: map[string]any{
+			"location": *cfg.Location,
+			"latency":  int(0),
+		},
+		Dynamic: true,
+		Decode: append([]StreamElement{
+			{Kind: "decodebin", Dynamic: true},
+		}, normalizeStages(cfg)...),
+	}, nil
+}
+
+func newFileSource(cfg *common.ConfigVideoSourceStream) (*StreamElement, error) {
+	if cfg.Location == nil {
+		return nil, fmt.Errorf("filesrc needs a location")
+	}
+
+	return &StreamElement{
+		Kind: "filesrc",
+		Properties: map[string]any{
+			"location": *cfg.Location,
+		},
+		Decode: append([]StreamElement{
+			{Kind: "decodebin", Dynamic: true},
+		}, normalizeStages(cfg)...),
+	}, nil
+}
@@ -0,0 +1,185 @@
+package turn
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/kaedwen/webrtc/pkg/common"
+	"github.com/pion/turn/v2"
+	"github.com/pion/webrtc/v3"
+	"go.uber.org/zap"
+)
+
+type credential struct {
+	key     []byte
+	expires time.Time
+}
+
+type TurnServer struct {
+	lg     *zap.Logger
+	cfg    *common.ConfigTURN
+	mu     *sync.Mutex
+	host   string
+	users  map[string]credential // username to long-term key
+	server *turn.Server
+}
+
+func NewTurnServer(ctx context.Context, lg *zap.Logger, cfg *common.ConfigTURN) (*TurnServer, error) {
+	if cfg.PublicIP == nil {
+		return nil, fmt.Errorf("turn server needs a public ip")
+	}
+
+	relayIP := net.ParseIP(*cfg.PublicIP)
+	if relayIP == nil {
+		return nil, fmt.Errorf("invalid public ip - %s", *cfg.PublicIP)
+	}
+
+	_, port, err := net.SplitHostPort(cfg.Listen)
+	if err != nil {
+		return nil, err
+	}
+
+	host := *cfg.PublicIP
+	if cfg.PublicHost != nil {
+		host = *cfg.PublicHost
+	}
+
+	s := TurnServer{
+		lg:    lg,
+		cfg:   cfg,
+		mu:    &sync.Mutex{},
+		host:  net.JoinHostPort(host, port),
+		users: make(map[string]credential),
+	}
+
+	udpListener, err := net.ListenPacket("udp", cfg.Listen)
+	if err != nil {
+		return nil, err
+	}
+
+	tcpListener, err := net.Listen("tcp", cfg.Listen)
+	if err != nil {
+		udpListener.Close()
+		return nil, err
+	}
+
+	generator := func() turn.RelayAddressGenerator {
+		return &turn.RelayAddressGeneratorPortRange{
+			RelayAddress: relayIP,
+			Address:      "0.0.0.0",
+			MinPort:      cfg.RelayMinPort,
+			MaxPort:      cfg.RelayMaxPort,
+		}
+	}
+
+	s.server, err = turn.NewServer(turn.ServerConfig{
+		Realm:       cfg.Realm,
+		AuthHandler: s.authHandler,
+		PacketConnConfigs: []turn.PacketConnConfig{{
+			PacketConn:            udpListener,
+			RelayAddressGenerator: generator(),
+			PermissionHandler:     s.permissionHandler,
+		}},
+		ListenerConfigs: []turn.ListenerConfig{{
+			Listener:              tcpListener,
+			RelayAddressGenerator: generator(),
+			PermissionHandler:     s.permissionHandler,
+		}},
+	})
+	if err != nil {
+		udpListener.Close()
+		tcpListener.Close()
+		return nil, err
+	}
+
+	lg.Info("turn server listening", zap.String("address", cfg.Listen), zap.String("public", s.host))
+
+	go func() {
+		<-ctx.Done()
+		if err := s.server.Close(); err != nil {
+			lg.Error("failed to close turn server", zap.Error(err))
+		}
+	}()
+
+	return &s, nil
+}
+
+func (s *TurnServer) authHandler(username, realm string, srcAddr net.Addr) ([]byte, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	c, ok := s.users[username]
+	if ok && time.Now().After(c.expires) {
+		delete(s.users, username)
+		ok = false
+	}
+	if !ok {
+		s.lg.Warn("turn authentication failed", zap.String("user", username), zap.String("remote", srcAddr.String()))
+		return nil, false
+	}
+
+	return c.key, true
+}
+
+// permissionHandler only relays to public peers
+func (s *TurnServer) permissionHandler(clientAddr net.Addr, peerIP net.IP) bool {
+	if !common.PublicIP(peerIP) {
+		s.lg.Warn("turn permission denied", zap.String("peer", peerIP.String()), zap.String("remote", clientAddr.String()))
+		return false
+	}
+
+	return true
+}
+
+// NewCredentials creates long-term credentials valid for the given signaling session
+func (s *TurnServer) NewCredentials(session string) (webrtc.ICEServer, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return webrtc.ICEServer{}, err
+	}
+
+	username := session
+	password := hex.EncodeToString(b)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.purge()
+	s.users[username] = credential{
+		key:     turn.GenerateAuthKey(username, s.cfg.Realm, password),
+		expires: time.Now().Add(s.cfg.CredentialTTL),
+	}
+
+	return webrtc.ICEServer{
+		URLs: []string{
+			fmt.Sprint("turn:", s.host, "?transport=udp"),
+			fmt.Sprint("turn:", s.host, "?transport=tcp"),
+		},
+		Username:       username,
+		Credential:     password,
+		CredentialType: webrtc.ICECredentialTypePassword,
+	}, nil
+}
+
+// purge drops the expired credentials, the sessions of most are long gone
+func (s *TurnServer) purge() {
+	now := time.Now()
+	for username, c := range s.users {
+		if now.After(c.expires) {
+			delete(s.users, username)
+		}
+	}
+}
+
+// Revoke removes the credentials of the given signaling session
+func (s *TurnServer) Revoke(session string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.users, session)
+}
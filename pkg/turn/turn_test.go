@@ -0,0 +1,82 @@
+package turn
+
+import (
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/kaedwen/webrtc/pkg/common"
+	"go.uber.org/zap"
+)
+
+func newTestServer(ttl time.Duration) *TurnServer {
+	return &TurnServer{
+		lg:    zap.NewNop(),
+		cfg:   &common.ConfigTURN{Realm: "doorbell", CredentialTTL: ttl},
+		mu:    &sync.Mutex{},
+		host:  "127.0.0.1:3478",
+		users: make(map[string]credential),
+	}
+}
+
+func TestExpiredCredentialsArePurged(t *testing.T) {
+	s := newTestServer(10 * time.Millisecond)
+	remote := &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 5000}
+
+	if _, err := s.NewCredentials("a"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.NewCredentials("b"); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := s.authHandler("a", "doorbell", remote); !ok {
+		t.Fatal("expected fresh credentials to authenticate")
+	}
+
+	time.Sleep(20 * time.Millisecond)
+
+	// a lookup drops the expired credential
+	if _, ok := s.authHandler("a", "doorbell", remote); ok {
+		t.Fatal("expected expired credentials to be rejected")
+	}
+	if _, ok := s.users["a"]; ok {
+		t.Fatal("expected a to be dropped on lookup")
+	}
+
+	// new credentials sweep the ones never looked up again
+	if _, err := s.NewCredentials("c"); err != nil {
+		t.Fatal(err)
+	}
+	if len(s.users) != 1 {
+		t.Fatalf("expected only c to be left, got %d credentials", len(s.users))
+	}
+}
+
+func TestPermissionHandler(t *testing.T) {
+	s := newTestServer(time.Hour)
+	client := &net.UDPAddr{IP: net.IPv4(203, 0, 113, 7), Port: 5000}
+
+	for _, tc := range []struct {
+		peer string
+		ok   bool
+	}{
+		{"198.51.100.20", true},
+		{"2001:db8::1", true},
+		{"127.0.0.1", false},
+		{"::1", false},
+		{"10.0.0.5", false},
+		{"172.16.3.4", false},
+		{"192.168.1.10", false},
+		{"fd00::1", false},
+		{"169.254.1.1", false},
+		{"fe80::1", false},
+		{"224.0.0.1", false},
+		{"0.0.0.0", false},
+		{"::", false},
+	} {
+		if ok := s.permissionHandler(client, net.ParseIP(tc.peer)); ok != tc.ok {
+			t.Errorf("%s: expected %t, got %t", tc.peer, tc.ok, ok)
+		}
+	}
+}
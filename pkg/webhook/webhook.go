@@ -0,0 +1,232 @@
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+	"text/template"
+	"time"
+
+	"github.com/kaedwen/webrtc/pkg/common"
+	"github.com/kaedwen/webrtc/pkg/events"
+	"github.com/kaedwen/webrtc/pkg/metrics"
+	"go.uber.org/zap"
+)
+
+const (
+	defaultTimeout = 10 * time.Second
+	defaultRetries = 3
+
+	// upper bound of the backoff between the attempts
+	maxBackoff = 30 * time.Second
+
+	signatureHeader = "X-Webhook-Signature"
+	timestampHeader = "X-Webhook-Timestamp"
+)
+
+// backoff between the attempts, doubled after every failure
+var initialBackoff = time.Second
+
+const defaultBody = `{"event":{{json .Event}},"timestamp":{{json .Timestamp}},"device":{{json .Device}},"snapshot":{{json .SnapshotUrl}}}`
+
+// Data is handed to the body templates
+type Data struct {
+	events.Data
+	Device      string
+	SnapshotUrl string
+}
+
+var funcs = template.FuncMap{
+	"json": func(v any) (string, error) {
+		b, err := json.Marshal(v)
+		return string(b), err
+	},
+}
+
+// permanentError is not retried
+type permanentError struct {
+	error
+}
+
+type hook struct {
+	lg     *zap.Logger
+	cfg    common.ConfigWebhook
+	name   string
+	device string
+	url    string // of the snapshot
+	body   *template.Template
+	client *http.Client
+}
+
+// NewWebhookHandler subscribes every hook on its own, so a slow one does not hold back the others
+func NewWebhookHandler(ctx context.Context, lg *zap.Logger, cfg *common.ConfigWebhooks, bus *events.Bus) (<-chan struct{}, error) {
+	var wg sync.WaitGroup
+	for _, c := range cfg.Hooks {
+		h, err := newHook(lg, cfg, c)
+		if err != nil {
+			return nil, err
+		}
+
+		kinds := make([]events.Kind, 0, len(c.Events))
+		for _, e := range c.Events {
+			kinds = append(kinds, events.Kind(e))
+		}
+
+		ch := bus.Subscribe(ctx, "webhook-"+h.name, events.DefaultQueueSize, kinds...)
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			h.run(ctx, ch)
+		}()
+	}
+
+	return common.Done(&wg), nil
+}
+
+func newHook(lg *zap.Logger, cfg *common.ConfigWebhooks, c common.ConfigWebhook) (*hook, error) {
+	u, err := url.Parse(c.Url)
+	if err != nil || u.Host == "" {
+		return nil, fmt.Errorf("invalid webhook url - %s", c.Url)
+	}
+
+	name := c.Name
+	if name == "" {
+		name = u.Host
+	}
+
+	if c.Method == "" {
+		c.Method = http.MethodPost
+	}
+	if c.Timeout == 0 {
+		c.Timeout = defaultTimeout
+	}
+	if c.Retries == nil {
+		c.Retries = common.Ptr[uint](defaultRetries)
+	}
+
+	body := c.Body
+	if body == "" {
+		body = defaultBody
+	}
+
+	tmpl, err := template.New(name).Funcs(funcs).Parse(body)
+	if err != nil {
+		return nil, fmt.Errorf("invalid body of webhook %s - %s", name, err)
+	}
+
+	return &hook{
+		lg:     lg.With(zap.String("hook", name)),
+		cfg:    c,
+		name:   name,
+		device: cfg.Device,
+		url:    cfg.SnapshotUrl,
+		body:   tmpl,
+		client: &http.Client{Timeout: c.Timeout},
+	}, nil
+}
+
+func (h *hook) run(ctx context.Context, ch <-chan events.Event) {
+	for e := range ch {
+		h.lg.Info("triggering webhook", zap.String("event", string(e.Kind())))
+
+		if err := h.trigger(ctx, h.data(e)); err != nil {
+			h.lg.Error("failed to trigger webhook", zap.String("event", string(e.Kind())), zap.Error(err))
+		}
+	}
+}
+
+func (h *hook) data(e events.Event) Data {
+	return Data{
+		Data:        events.NewData(e),
+		Device:      h.device,
+		SnapshotUrl: h.url,
+	}
+}
+
+// trigger renders the body once and sends it until it is accepted or the retries are used up
+func (h *hook) trigger(ctx context.Context, data Data) error {
+	var body bytes.Buffer
+	if err := h.body.Execute(&body, data); err != nil {
+		return fmt.Errorf("failed to render body - %s", err)
+	}
+
+	backoff := initialBackoff
+	for attempt := uint(0); ; attempt++ {
+		duration, _, err := common.Time(func() (any, error) {
+			return nil, h.send(ctx, body.Bytes())
+		})
+		metrics.WebhookDuration.WithLabelValues(h.name, metrics.Result(err)).Observe(duration.Seconds())
+
+		var perr permanentError
+		if err == nil || errors.As(err, &perr) || attempt >= *h.cfg.Retries {
+			return err
+		}
+
+		h.lg.Warn("webhook failed, retrying", zap.Duration("backoff", backoff), zap.Error(err))
+
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+
+		backoff = min(2*backoff, maxBackoff)
+	}
+}
+
+func (h *hook) send(ctx context.Context, body []byte) error {
+	req, err := http.NewRequestWithContext(ctx, h.cfg.Method, h.cfg.Url, bytes.NewReader(body))
+	if err != nil {
+		return permanentError{err}
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range h.cfg.Headers {
+		req.Header.Set(k, v)
+	}
+
+	// the timestamp is signed along, so a captured request can not be replayed later
+	if h.cfg.Secret != nil {
+		ts := strconv.FormatInt(time.Now().Unix(), 10)
+		req.Header.Set(timestampHeader, ts)
+		req.Header.Set(signatureHeader, "sha256="+sign(*h.cfg.Secret, ts, body))
+	}
+
+	res, err := h.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode >= 200 && res.StatusCode < 300 {
+		return nil
+	}
+
+	err = fmt.Errorf("received wrong status code - %d", res.StatusCode)
+
+	// the request itself is wrong, sending it again does not help
+	if res.StatusCode >= 400 && res.StatusCode < 500 && res.StatusCode != http.StatusTooManyRequests && res.StatusCode != http.StatusRequestTimeout {
+		return permanentError{err}
+	}
+
+	return err
+}
+
+// sign returns the hex encoded hmac-sha256 of "<timestamp>.<body>"
+func sign(secret string, ts string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(ts))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
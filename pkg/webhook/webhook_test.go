@@ -0,0 +1,282 @@
+package webhook
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/kaedwen/webrtc/pkg/common"
+	"github.com/kaedwen/webrtc/pkg/events"
+	"go.uber.org/zap"
+)
+
+type request struct {
+	header http.Header
+	body   []byte
+	at     time.Time
+}
+
+// standIn records the requests of a receiver and answers them with the given status codes,
+// the last one is repeated
+type standIn struct {
+	*httptest.Server
+	mu       *sync.Mutex
+	requests []request
+}
+
+func newStandIn(t *testing.T, delay time.Duration, status ...int) *standIn {
+	t.Helper()
+
+	s := &standIn{mu: &sync.Mutex{}}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+
+		s.mu.Lock()
+		n := len(s.requests)
+		s.requests = append(s.requests, request{r.Header.Clone(), body, time.Now()})
+		s.mu.Unlock()
+
+		time.Sleep(delay)
+
+		code := http.StatusOK
+		if len(status) > 0 {
+			code = status[min(n, len(status)-1)]
+		}
+		w.WriteHeader(code)
+	}))
+	t.Cleanup(s.Close)
+
+	return s
+}
+
+func (s *standIn) recorded() []request {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]request(nil), s.requests...)
+}
+
+func fastBackoff(t *testing.T) {
+	prev := initialBackoff
+	initialBackoff = 10 * time.Millisecond
+	t.Cleanup(func() { initialBackoff = prev })
+}
+
+func newTestHook(t *testing.T, c common.ConfigWebhook) *hook {
+	t.Helper()
+
+	h, err := newHook(zap.NewNop(), &common.ConfigWebhooks{Device: "door", SnapshotUrl: "https://door.example/snapshot.jpg"}, c)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return h
+}
+
+func TestDefaultBody(t *testing.T) {
+	s := newStandIn(t, 0)
+	h := newTestHook(t, common.ConfigWebhook{Url: s.URL})
+
+	if err := h.trigger(context.Background(), h.data(events.NewRing())); err != nil {
+		t.Fatal(err)
+	}
+
+	r := s.recorded()[0]
+	if r.header.Get("Content-Type") != "application/json" {
+		t.Fatalf("unexpected content type %q", r.header.Get("Content-Type"))
+	}
+
+	var m map[string]string
+	if err := json.Unmarshal(r.body, &m); err != nil {
+		t.Fatalf("expected json, got %s", r.body)
+	}
+	if m["event"] != "ring" || m["device"] != "door" || m["snapshot"] != "https://door.example/snapshot.jpg" || m["timestamp"] == "" {
+		t.Fatalf("unexpected body %s", r.body)
+	}
+	if r.header.Get(signatureHeader) != "" {
+		t.Fatal("expected no signature without secret")
+	}
+}
+
+func TestTemplatedBody(t *testing.T) {
+	s := newStandIn(t, 0)
+	h := newTestHook(t, common.ConfigWebhook{
+		Url:     s.URL,
+		Method:  http.MethodPut,
+		Headers: map[string]string{"Authorization": "Bearer token"},
+		Body:    `{"text":{{json (printf "%s at %s" .Event .Device)}},"ratio":{{.Ratio}}}`,
+	})
+
+	if err := h.trigger(context.Background(), h.data(events.NewMotion(true, 0.25))); err != nil {
+		t.Fatal(err)
+	}
+
+	r := s.recorded()[0]
+	if string(r.body) != `{"text":"motion-start at door","ratio":0.25}` {
+		t.Fatalf("unexpected body %s", r.body)
+	}
+	if r.header.Get("Authorization") != "Bearer token" {
+		t.Fatalf("unexpected headers %v", r.header)
+	}
+}
+
+func TestInvalidBody(t *testing.T) {
+	_, err := newHook(zap.NewNop(), &common.ConfigWebhooks{}, common.ConfigWebhook{Url: "http://hook.example", Body: "{{.Event"})
+	if err == nil {
+		t.Fatal("expected a broken template to be rejected")
+	}
+}
+
+func TestSignature(t *testing.T) {
+	s := newStandIn(t, 0)
+	h := newTestHook(t, common.ConfigWebhook{Url: s.URL, Secret: common.Ptr("secret")})
+
+	if err := h.trigger(context.Background(), h.data(events.NewRing())); err != nil {
+		t.Fatal(err)
+	}
+
+	r := s.recorded()[0]
+	ts := r.header.Get(timestampHeader)
+	if ts == "" {
+		t.Fatal("expected a timestamp")
+	}
+
+	mac := hmac.New(sha256.New, []byte("secret"))
+	mac.Write([]byte(ts + "." + string(r.body)))
+	if want := "sha256=" + hex.EncodeToString(mac.Sum(nil)); r.header.Get(signatureHeader) != want {
+		t.Fatalf("expected signature %s, got %s", want, r.header.Get(signatureHeader))
+	}
+}
+
+func TestRetries(t *testing.T) {
+	fastBackoff(t)
+
+	for _, tc := range []struct {
+		status   int
+		attempts int
+	}{
+		{http.StatusBadRequest, 1},
+		{http.StatusNotFound, 1},
+		{http.StatusRequestTimeout, 3},
+		{http.StatusTooManyRequests, 3},
+		{http.StatusInternalServerError, 3},
+		{http.StatusBadGateway, 3},
+	} {
+		s := newStandIn(t, 0, tc.status)
+		h := newTestHook(t, common.ConfigWebhook{Url: s.URL, Retries: common.Ptr[uint](2)})
+
+		if err := h.trigger(context.Background(), h.data(events.NewRing())); err == nil {
+			t.Errorf("%d: expected an error", tc.status)
+		}
+		if n := len(s.recorded()); n != tc.attempts {
+			t.Errorf("%d: expected %d attempts, got %d", tc.status, tc.attempts, n)
+		}
+	}
+}
+
+func TestRetrySucceeds(t *testing.T) {
+	fastBackoff(t)
+
+	s := newStandIn(t, 0, http.StatusServiceUnavailable, http.StatusServiceUnavailable, http.StatusNoContent)
+	h := newTestHook(t, common.ConfigWebhook{Url: s.URL})
+
+	if err := h.trigger(context.Background(), h.data(events.NewRing())); err != nil {
+		t.Fatal(err)
+	}
+
+	rs := s.recorded()
+	if len(rs) != 3 {
+		t.Fatalf("expected 3 attempts, got %d", len(rs))
+	}
+
+	// the body is rendered once, every attempt sends the same
+	if string(rs[0].body) != string(rs[2].body) {
+		t.Fatalf("expected the same body, got %s and %s", rs[0].body, rs[2].body)
+	}
+
+	// the backoff doubles
+	if first, second := rs[1].at.Sub(rs[0].at), rs[2].at.Sub(rs[1].at); first < initialBackoff || second < 2*initialBackoff {
+		t.Fatalf("expected a doubling backoff, waited %s and %s", first, second)
+	}
+}
+
+func TestTimeout(t *testing.T) {
+	fastBackoff(t)
+
+	s := newStandIn(t, 200*time.Millisecond)
+	h := newTestHook(t, common.ConfigWebhook{Url: s.URL, Timeout: 20 * time.Millisecond, Retries: common.Ptr[uint](1)})
+
+	start := time.Now()
+	if err := h.trigger(context.Background(), h.data(events.NewRing())); err == nil {
+		t.Fatal("expected a timeout")
+	}
+
+	// a timeout is retried like a server error
+	if n := len(s.recorded()); n != 2 {
+		t.Fatalf("expected 2 attempts, got %d", n)
+	}
+	if elapsed := time.Since(start); elapsed > 150*time.Millisecond {
+		t.Fatalf("expected the attempts to be cut off, took %s", elapsed)
+	}
+}
+
+func TestCancelStopsRetrying(t *testing.T) {
+	prev := initialBackoff
+	initialBackoff = time.Hour
+	t.Cleanup(func() { initialBackoff = prev })
+
+	s := newStandIn(t, 0, http.StatusInternalServerError)
+	h := newTestHook(t, common.ConfigWebhook{Url: s.URL})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		cancel()
+	}()
+
+	if err := h.trigger(ctx, h.data(events.NewRing())); err != context.Canceled {
+		t.Fatalf("expected the backoff to be cancelled, got %v", err)
+	}
+}
+
+func TestEventFilter(t *testing.T) {
+	all := newStandIn(t, 0)
+	rings := newStandIn(t, 0)
+
+	bus := events.NewBus(zap.NewNop())
+	ctx, cancel := context.WithCancel(context.Background())
+
+	done, err := NewWebhookHandler(ctx, zap.NewNop(), &common.ConfigWebhooks{Hooks: []common.ConfigWebhook{
+		{Name: "all", Url: all.URL},
+		{Name: "rings", Url: rings.URL, Events: []string{"ring"}},
+	}}, bus)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	bus.Publish(events.NewMotion(true, 0.5))
+	bus.Publish(events.NewRing())
+
+	deadline := time.Now().Add(5 * time.Second)
+	for len(all.recorded()) < 2 || len(rings.recorded()) < 1 {
+		if time.Now().After(deadline) {
+			t.Fatal("expected the hooks to be triggered")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	cancel()
+	<-done
+
+	if rs := rings.recorded(); len(rs) != 1 || !strings.Contains(string(rs[0].body), `"event":"ring"`) {
+		t.Fatalf("expected only the ring, got %d requests", len(rs))
+	}
+}
@@ -0,0 +1,162 @@
+package webrtc
+
+import (
+	"context"
+	"time"
+
+	"github.com/kaedwen/webrtc/pkg/motion"
+	"github.com/kaedwen/webrtc/pkg/server"
+	"github.com/kaedwen/webrtc/pkg/streamer"
+	"go.uber.org/zap"
+)
+
+// valves gating the fallback branches of the video pipeline
+var fallbackValves = map[server.StreamKind]string{
+	server.StreamMJPEG: streamer.MJPEGValve,
+	server.StreamHLS:   streamer.HLSValve,
+}
+
+// openValves opens the branches of the current consumers again, a rebuilt pipeline starts with all closed
+func (wh *WebrtcHandler) openValves() {
+	wh.consumerMu.Lock()
+	defer wh.consumerMu.Unlock()
+
+	for kind, reqs := range wh.consumers {
+		if len(reqs) == 0 {
+			continue
+		}
+
+		if err := streamer.SetValve(wh.videoPipeline, fallbackValves[kind], true); err != nil {
+			wh.lg.Error("failed to open stream", zap.String("kind", string(kind)), zap.Error(err))
+		}
+	}
+}
+
+// branches returns the outputs split off the video pipeline besides the webrtc stream
+func (wh *WebrtcHandler) branches() []streamer.Branch {
+	cfg := wh.cfg.Load()
+
+	branches := []streamer.Branch{
+		streamer.SnapshotBranch(),
+		streamer.MJPEGBranch(cfg.Fallback.MJPEGFps),
+		streamer.HLSBranch(cfg.Fallback.HLSDir.String(), cfg.Fallback.HLSPart, cfg.VideoSrc.Framerate),
+	}
+
+	if cfg.Motion.Enabled {
+		branches = append(branches, streamer.MotionBranch(motion.Width, motion.Height, motion.Fps))
+	}
+
+	return branches
+}
+
+func (wh *WebrtcHandler) handleFallbacks(ctx context.Context, ch <-chan *server.StreamRequest) {
+	for {
+		select {
+		case req := <-ch:
+			wh.addConsumer(req)
+
+			go func() {
+				select {
+				case <-req.Done:
+				case <-ctx.Done():
+				}
+				wh.removeConsumer(req)
+			}()
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// handleMJPEG hands the frames of the mjpeg branch to its consumers
+func (wh *WebrtcHandler) handleMJPEG(ctx context.Context) error {
+	mjpegCh, err := streamer.NewMJPEGSink(wh.videoPipeline)
+	if err != nil {
+		return err
+	}
+
+	go func() {
+		for {
+			select {
+			case data := <-mjpegCh:
+				wh.consumerMu.Lock()
+				for req := range wh.consumers[server.StreamMJPEG] {
+					// slow clients skip frames
+					select {
+					case req.Frames <- data.Data:
+					default:
+					}
+				}
+				wh.consumerMu.Unlock()
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	return nil
+}
+
+// handleHLS packages the parts of the hls branch into the playlist
+func (wh *WebrtcHandler) handleHLS() error {
+	return streamer.NewHLSSink(wh.videoPipeline, func(name string, duration time.Duration) {
+		if err := wh.playlist.AddPart(name, duration); err != nil {
+			wh.lg.Error("failed to add hls part", zap.String("part", name), zap.Error(err))
+		}
+	})
+}
+
+// addConsumer opens the branch for the first consumer and makes sure the pipelines run
+func (wh *WebrtcHandler) addConsumer(req *server.StreamRequest) {
+	wh.consumerMu.Lock()
+	if wh.consumers[req.Kind] == nil {
+		wh.consumers[req.Kind] = make(map[*server.StreamRequest]bool)
+	}
+	wh.consumers[req.Kind][req] = true
+	first := len(wh.consumers[req.Kind]) == 1
+	wh.consumerMu.Unlock()
+
+	wh.consuming.Add(1)
+	wh.lg.Info("new stream consumer", zap.String("kind", string(req.Kind)))
+
+	// the lock keeps the video pipeline from being rebuilt meanwhile
+	wh.mu.Lock()
+	defer wh.mu.Unlock()
+
+	if first {
+		if err := streamer.SetValve(wh.videoPipeline, fallbackValves[req.Kind], true); err != nil {
+			wh.lg.Error("failed to open stream", zap.String("kind", string(req.Kind)), zap.Error(err))
+		}
+	}
+
+	wh.startPipelines()
+}
+
+// removeConsumer closes the branch after the last consumer and stops the pipelines when unused
+func (wh *WebrtcHandler) removeConsumer(req *server.StreamRequest) {
+	wh.consumerMu.Lock()
+	delete(wh.consumers[req.Kind], req)
+	last := len(wh.consumers[req.Kind]) == 0
+	wh.consumerMu.Unlock()
+
+	wh.consuming.Add(-1)
+	wh.lg.Info("stream consumer gone", zap.String("kind", string(req.Kind)))
+
+	wh.mu.Lock()
+	defer wh.mu.Unlock()
+
+	if last {
+		if err := streamer.SetValve(wh.videoPipeline, fallbackValves[req.Kind], false); err != nil {
+			wh.lg.Error("failed to close stream", zap.String("kind", string(req.Kind)), zap.Error(err))
+		}
+
+		// the next consumer must not get an outdated playlist
+		if req.Kind == server.StreamHLS {
+			wh.playlist.Reset()
+		}
+	}
+
+	if !wh.needsPipelines() {
+		wh.stopPipelines()
+	}
+}
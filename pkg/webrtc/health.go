@@ -0,0 +1,64 @@
+package webrtc
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/go-gst/go-gst/gst"
+	"github.com/kaedwen/webrtc/pkg/events"
+	"github.com/kaedwen/webrtc/pkg/metrics"
+)
+
+// time a pipeline gets to reach playing
+const probeTimeout = 5 * time.Second
+
+// pipelineHealth remembers the last known error of a pipeline
+type pipelineHealth struct {
+	mu  sync.Mutex
+	err error
+}
+
+func (p *pipelineHealth) Set(err error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.err = err
+}
+
+func (p *pipelineHealth) Check() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return p.err
+}
+
+// probePipeline makes sure the pipeline is able to reach playing and stops it again
+func probePipeline(pipeline *gst.Pipeline) error {
+	defer pipeline.SetState(gst.StateNull)
+
+	if err := pipeline.SetState(gst.StatePlaying); err != nil {
+		return err
+	}
+
+	ret, state := pipeline.GetState(gst.StatePlaying, gst.ClockTime(probeTimeout.Nanoseconds()))
+	if ret == gst.StateChangeFailure || state != gst.StatePlaying {
+		return fmt.Errorf("pipeline did not reach playing - %s", state.String())
+	}
+
+	return nil
+}
+
+// busErrorHandler counts the bus errors of the named pipeline, marks it unhealthy and publishes them
+func (wh *WebrtcHandler) busErrorHandler(name string, health *pipelineHealth) func(error) {
+	return func(err error) {
+		metrics.PipelineErrors.WithLabelValues(name).Inc()
+		health.Set(err)
+		wh.bus.Publish(events.NewPipelineError(name, err))
+	}
+}
+
+func (wh *WebrtcHandler) probePipelines() {
+	wh.audioHealth.Set(probePipeline(wh.audioPipeline))
+	wh.videoHealth.Set(probePipeline(wh.videoPipeline))
+}
@@ -0,0 +1,61 @@
+package webrtc
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"encoding/base64"
+	"fmt"
+	"time"
+
+	"github.com/kaedwen/webrtc/pkg/common"
+	"github.com/pion/webrtc/v3"
+)
+
+// default lifetime of time limited turn credentials
+const defaultCredentialTTL = 24 * time.Hour
+
+// restCredentials derives time limited credentials from the shared secret
+// as described in the TURN REST API draft (coturn use-auth-secret)
+func restCredentials(secret string, user string, ttl time.Duration) (string, string) {
+	if ttl <= 0 {
+		ttl = defaultCredentialTTL
+	}
+
+	username := fmt.Sprint(time.Now().Add(ttl).Unix(), ":", user)
+
+	m := hmac.New(sha1.New, []byte(secret))
+	m.Write([]byte(username))
+
+	return username, base64.StdEncoding.EncodeToString(m.Sum(nil))
+}
+
+// iceServers builds the ice servers for a single session
+func iceServers(cfg *common.ConfigICE, user string) []webrtc.ICEServer {
+	if cfg.NoExternal {
+		return []webrtc.ICEServer{}
+	}
+
+	if len(cfg.Servers) == 0 {
+		return []webrtc.ICEServer{{URLs: []string{STUN_SERVER}}}
+	}
+
+	servers := make([]webrtc.ICEServer, 0, len(cfg.Servers))
+	for _, s := range cfg.Servers {
+		is := webrtc.ICEServer{
+			URLs: s.Urls,
+		}
+
+		if s.Secret != nil {
+			is.Username, is.Credential = restCredentials(*s.Secret, user, s.TTL)
+			is.CredentialType = webrtc.ICECredentialTypePassword
+		} else if s.Username != "" {
+			is.Username = s.Username
+			is.Credential = s.Credential
+			is.CredentialType = webrtc.ICECredentialTypePassword
+		}
+
+		servers = append(servers, is)
+	}
+
+	return servers
+}
@@ -0,0 +1,51 @@
+package webrtc
+
+import (
+	"context"
+	"time"
+
+	"github.com/kaedwen/webrtc/pkg/events"
+	"github.com/kaedwen/webrtc/pkg/metrics"
+	"github.com/kaedwen/webrtc/pkg/motion"
+	"github.com/kaedwen/webrtc/pkg/streamer"
+	"go.uber.org/zap"
+)
+
+// handleMotion runs the detection on the frames of the motion branch and publishes its events
+func (wh *WebrtcHandler) handleMotion(ctx context.Context) error {
+	if !wh.cfg.Load().Motion.Enabled {
+		return nil
+	}
+
+	frames, err := streamer.NewMotionSink(wh.videoPipeline)
+	if err != nil {
+		return err
+	}
+
+	detector := motion.NewDetector(&wh.cfg.Load().Motion)
+
+	go func() {
+		for {
+			select {
+			case s := <-frames:
+				ev := detector.Process(s.Data, time.Now())
+				if ev == nil {
+					continue
+				}
+
+				state := "stop"
+				if ev.Active {
+					state = "start"
+				}
+				metrics.MotionEvents.WithLabelValues(state).Inc()
+				wh.lg.Info("motion", zap.String("state", state), zap.Float64("ratio", ev.Ratio))
+
+				wh.bus.Publish(events.NewMotion(ev.Active, ev.Ratio))
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	return nil
+}
@@ -0,0 +1,211 @@
+package webrtc
+
+import (
+	"context"
+	"encoding/binary"
+
+	"github.com/kaedwen/webrtc/pkg/common"
+	"github.com/kaedwen/webrtc/pkg/streamer"
+	"github.com/pion/interceptor"
+	"github.com/pion/rtp"
+	"github.com/pion/webrtc/v3"
+	"github.com/pion/webrtc/v3/pkg/media"
+	"go.uber.org/zap"
+)
+
+// maximum number of packets kept for a single group of pictures
+const maxCachedPackets = 4096
+
+// keyframeCache keeps every packet since the last keyframe so a new peer
+// gets a picture immediately instead of waiting for the camera
+type keyframeCache struct {
+	codec   common.StreamCodec
+	packets []*rtp.Packet
+	valid   bool
+}
+
+func newKeyframeCache(codec common.StreamCodec) *keyframeCache {
+	return &keyframeCache{codec: codec}
+}
+
+func (c *keyframeCache) Push(p *rtp.Packet) {
+	if isKeyframeStart(c.codec, p.Payload) {
+		c.packets = c.packets[:0]
+		c.valid = true
+	}
+
+	if !c.valid {
+		return
+	}
+
+	if len(c.packets) >= maxCachedPackets {
+		// gop is too long, wait for the next keyframe
+		c.Reset()
+		return
+	}
+
+	c.packets = append(c.packets, p)
+}
+
+func (c *keyframeCache) Reset() {
+	c.packets = c.packets[:0]
+	c.valid = false
+}
+
+func (c *keyframeCache) Packets() []*rtp.Packet {
+	return c.packets
+}
+
+// isKeyframeStart reports whether the payload starts a new keyframe, the parsers
+// insert the parameter sets in front of every keyframe so those are used as marker
+func isKeyframeStart(codec common.StreamCodec, payload []byte) bool {
+	switch codec {
+	case common.H264:
+		if len(payload) < 1 {
+			return false
+		}
+
+		switch t := payload[0] & 0x1F; t {
+		case 24: // STAP-A
+			return len(payload) > 3 && payload[3]&0x1F == 7
+		case 28: // FU-A
+			return len(payload) > 1 && payload[1]&0x80 != 0 && payload[1]&0x1F == 7
+		default:
+			return t == 7 // SPS
+		}
+	case common.H265:
+		if len(payload) < 2 {
+			return false
+		}
+
+		switch t := (payload[0] >> 1) & 0x3F; t {
+		case 48: // AP
+			return len(payload) > 4 && binary.BigEndian.Uint16(payload[2:4]) > 0 && (payload[4]>>1)&0x3F == 32
+		case 49: // FU
+			return len(payload) > 2 && payload[2]&0x80 != 0 && payload[2]&0x3F == 32
+		default:
+			return t == 32 // VPS
+		}
+	}
+
+	return false
+}
+
+func newAPI() (*webrtc.API, error) {
+	m := &webrtc.MediaEngine{}
+	if err := m.RegisterDefaultCodecs(); err != nil {
+		return nil, err
+	}
+
+	// H265 is not part of the default codecs but cameras deliver it
+	videoRTCPFeedback := []webrtc.RTCPFeedback{{Type: "goog-remb"}, {Type: "ccm", Parameter: "fir"}, {Type: "nack"}, {Type: "nack", Parameter: "pli"}}
+	err := m.RegisterCodec(webrtc.RTPCodecParameters{
+		RTPCodecCapability: webrtc.RTPCodecCapability{MimeType: webrtc.MimeTypeH265, ClockRate: 90000, RTCPFeedback: videoRTCPFeedback},
+		PayloadType:        116,
+	}, webrtc.RTPCodecTypeVideo)
+	if err != nil {
+		return nil, err
+	}
+
+	i := &interceptor.Registry{}
+	if err := webrtc.RegisterDefaultInterceptors(m, i); err != nil {
+		return nil, err
+	}
+
+	return webrtc.NewAPI(webrtc.WithMediaEngine(m), webrtc.WithInterceptorRegistry(i)), nil
+}
+
+func (wh *WebrtcHandler) handleVideoPackets(ctx context.Context, cfg *common.ConfigVideoSourceStream) error {
+	src, err := streamer.NewVideoSourceElement(cfg)
+	if err != nil {
+		return err
+	}
+
+	if src.Warning != nil {
+		wh.lg.Warn("video format", zap.Error(src.Warning))
+	}
+
+	src.Codec = cfg.Codec
+	src.Branches = wh.branches()
+
+	var videoCh <-chan media.Sample
+	wh.videoPipeline, videoCh, err = streamer.CreateVideoPipelineRTP(wh.lg, *src)
+	if err != nil {
+		return err
+	}
+
+	wh.snapshotSink, err = streamer.NewSnapshotSink(wh.videoPipeline)
+	if err != nil {
+		return err
+	}
+
+	streamer.LoopBus(ctx, wh.lg.With(zap.String("sub-context", "video")), wh.videoPipeline, wh.busErrorHandler("video", wh.videoHealth))
+
+	// a rebuilt pipeline starts with a new stream
+	wh.videoMu.Lock()
+	wh.keyframes = newKeyframeCache(cfg.Codec)
+	wh.videoMu.Unlock()
+
+	go func() {
+		wh.lg.Info("wait for video packet")
+		for {
+			select {
+			case data := <-videoCh:
+				p := &rtp.Packet{}
+				if err := p.Unmarshal(data.Data); err != nil {
+					wh.lg.Error("failed to parse video packet", zap.Error(err))
+					continue
+				}
+
+				wh.pushPacket(p)
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	return nil
+}
+
+// pushPacket caches the packet and queues it for every live peer
+func (wh *WebrtcHandler) pushPacket(p *rtp.Packet) {
+	wh.videoMu.Lock()
+	defer wh.videoMu.Unlock()
+
+	wh.keyframes.Push(p)
+	wh.recordPacket(p)
+	for _, ph := range wh.peers.Snapshot() {
+		if ph.live.Load() {
+			ph.PushPacket(p)
+		}
+	}
+}
+
+func (wh *WebrtcHandler) resetKeyframes() {
+	wh.videoMu.Lock()
+	defer wh.videoMu.Unlock()
+
+	if wh.keyframes != nil {
+		wh.keyframes.Reset()
+	}
+}
+
+// goLive replays the cached keyframe to the peer and lets it receive the live packets,
+// the replay is written directly as it is larger than the queue of the peer
+func (wh *WebrtcHandler) goLive(ph *PeerHandle) {
+	wh.videoMu.Lock()
+	defer wh.videoMu.Unlock()
+
+	if ph.live.Load() {
+		return
+	}
+
+	for _, p := range wh.keyframes.Packets() {
+		if err := ph.videoRTPTrack.WriteRTP(p); err != nil {
+			wh.lg.Error("failed to replay video packet", zap.String("id", ph.id), zap.Error(err))
+			break
+		}
+	}
+
+	ph.live.Store(true)
+}
@@ -0,0 +1,297 @@
+package webrtc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/kaedwen/webrtc/pkg/common"
+	"github.com/kaedwen/webrtc/pkg/server"
+	"github.com/pion/interceptor"
+	"github.com/pion/rtcp"
+	"github.com/pion/rtp"
+	"github.com/pion/rtp/codecs"
+	"github.com/pion/webrtc/v3"
+	"github.com/pion/webrtc/v3/pkg/media/samplebuilder"
+	"go.uber.org/zap"
+)
+
+// packets the sample builder may hold back to reorder
+const maxLatePackets = 256
+
+// publisherCodec returns the parameters a publisher has to use so its packets fit the viewer tracks
+func publisherCodec(codec common.StreamCodec) (webrtc.RTPCodecParameters, error) {
+	videoRTCPFeedback := []webrtc.RTCPFeedback{{Type: "goog-remb"}, {Type: "ccm", Parameter: "fir"}, {Type: "nack"}, {Type: "nack", Parameter: "pli"}}
+
+	switch codec {
+	case common.H264:
+		return webrtc.RTPCodecParameters{
+			RTPCodecCapability: webrtc.RTPCodecCapability{MimeType: webrtc.MimeTypeH264, ClockRate: 90000, SDPFmtpLine: "level-asymmetry-allowed=1;packetization-mode=1;profile-level-id=42e01f", RTCPFeedback: videoRTCPFeedback},
+			PayloadType:        102,
+		}, nil
+	case common.VP8:
+		return webrtc.RTPCodecParameters{
+			RTPCodecCapability: webrtc.RTPCodecCapability{MimeType: webrtc.MimeTypeVP8, ClockRate: 90000, RTCPFeedback: videoRTCPFeedback},
+			PayloadType:        96,
+		}, nil
+	case common.VP9:
+		return webrtc.RTPCodecParameters{
+			RTPCodecCapability: webrtc.RTPCodecCapability{MimeType: webrtc.MimeTypeVP9, ClockRate: 90000, SDPFmtpLine: "profile-id=0", RTCPFeedback: videoRTCPFeedback},
+			PayloadType:        98,
+		}, nil
+	default:
+		// H265 is not offered, there is no depacketizer to rebuild its samples
+		return webrtc.RTPCodecParameters{}, fmt.Errorf("unsupported publisher codec - %s", codec)
+	}
+}
+
+// newPublisherAPI only accepts the codecs the viewers are served with, so no transcoding is needed
+func newPublisherAPI(codec common.StreamCodec) (*webrtc.API, error) {
+	m := &webrtc.MediaEngine{}
+
+	err := m.RegisterCodec(webrtc.RTPCodecParameters{
+		RTPCodecCapability: webrtc.RTPCodecCapability{MimeType: webrtc.MimeTypeOpus, ClockRate: 48000, Channels: 2, SDPFmtpLine: "minptime=10;useinbandfec=1"},
+		PayloadType:        111,
+	}, webrtc.RTPCodecTypeAudio)
+	if err != nil {
+		return nil, err
+	}
+
+	video, err := publisherCodec(codec)
+	if err != nil {
+		return nil, err
+	}
+
+	err = m.RegisterCodec(video, webrtc.RTPCodecTypeVideo)
+	if err != nil {
+		return nil, err
+	}
+
+	i := &interceptor.Registry{}
+	if err := webrtc.RegisterDefaultInterceptors(m, i); err != nil {
+		return nil, err
+	}
+
+	return webrtc.NewAPI(webrtc.WithMediaEngine(m), webrtc.WithInterceptorRegistry(i)), nil
+}
+
+// newDepacketizer returns the depacketizer to rebuild samples of the given codec
+func newDepacketizer(mime string) (rtp.Depacketizer, error) {
+	switch mime {
+	case webrtc.MimeTypeOpus:
+		return &codecs.OpusPacket{}, nil
+	case webrtc.MimeTypeH264:
+		return &codecs.H264Packet{}, nil
+	case webrtc.MimeTypeVP8:
+		return &codecs.VP8Packet{}, nil
+	case webrtc.MimeTypeVP9:
+		return &codecs.VP9Packet{}, nil
+	default:
+		return nil, fmt.Errorf("no depacketizer for %s", mime)
+	}
+}
+
+func (wh *WebrtcHandler) handlePublishers(ctx context.Context, ch <-chan *server.SignalingHandle) {
+	for {
+		select {
+		case sh := <-ch:
+			wh.lg.Info("new publisher", zap.String("id", sh.Id))
+
+			if err := wh.createPublisher(ctx, sh); err != nil {
+				wh.lg.Error("failed to create publisher", zap.Error(err))
+				sh.Trcv <- server.NewErrorMessage(err)
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func (wh *WebrtcHandler) createPublisher(rctx context.Context, sh *server.SignalingHandle) error {
+	wh.mu.Lock()
+	defer wh.mu.Unlock()
+
+	if wh.publisherAPI == nil {
+		return fmt.Errorf("publishing is not supported with %s", wh.cfg.Load().VideoSrc.Codec)
+	}
+
+	if wh.publisher != "" {
+		return fmt.Errorf("publisher %s already connected", wh.publisher)
+	}
+
+	servers := iceServers(&wh.cfg.Load().Ice, sh.Id)
+	if wh.relay != nil {
+		is, err := wh.relay.NewCredentials(sh.Id)
+		if err != nil {
+			return err
+		}
+		servers = append(servers, is)
+	}
+
+	sh.Trcv <- server.NewIceServersMessage(servers)
+
+	peerConnection, err := wh.publisherAPI.NewPeerConnection(webrtc.Configuration{
+		ICEServers: servers,
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, kind := range []webrtc.RTPCodecType{webrtc.RTPCodecTypeAudio, webrtc.RTPCodecTypeVideo} {
+		_, err = peerConnection.AddTransceiverFromKind(kind, webrtc.RTPTransceiverInit{Direction: webrtc.RTPTransceiverDirectionRecvonly})
+		if err != nil {
+			peerConnection.Close()
+			return err
+		}
+	}
+
+	hctx, hcancel := context.WithCancel(rctx)
+	context.AfterFunc(hctx, func() { close(sh.Done) })
+
+	peerConnection.OnICECandidate(func(i *webrtc.ICECandidate) {
+		if i != nil && sh.Trickle {
+			sh.Trcv <- server.NewIceCandidateMessage(*i)
+		}
+	})
+
+	peerConnection.OnTrack(func(track *webrtc.TrackRemote, receiver *webrtc.RTPReceiver) {
+		wh.lg.Info("received publisher track", zap.String("kind", track.Kind().String()), zap.String("codec", track.Codec().MimeType))
+
+		if track.Kind() == webrtc.RTPCodecTypeVideo {
+			go wh.requestKeyframes(hctx, peerConnection, track)
+		}
+
+		if err := wh.forwardTrack(track); err != nil && !errors.Is(err, io.EOF) {
+			wh.lg.Error("failed to forward publisher track", zap.String("kind", track.Kind().String()), zap.Error(err))
+		}
+	})
+
+	peerConnection.OnConnectionStateChange(func(state webrtc.PeerConnectionState) {
+		wh.lg.Info("publisher state has changed", zap.String("state", state.String()))
+
+		switch state {
+		case webrtc.PeerConnectionStateConnected:
+			wh.startPublishing()
+		case webrtc.PeerConnectionStateDisconnected, webrtc.PeerConnectionStateFailed:
+			peerConnection.Close()
+		case webrtc.PeerConnectionStateClosed:
+			hcancel()
+			wh.stopPublishing(sh.Id)
+		}
+	})
+
+	wh.publisher = sh.Id
+
+	go wh.handleSignaling(hctx, sh, peerConnection, func() {
+		peerConnection.Close()
+		hcancel()
+		wh.stopPublishing(sh.Id)
+	})
+
+	return nil
+}
+
+// startPublishing replaces the local source by the publisher
+func (wh *WebrtcHandler) startPublishing() {
+	wh.mu.Lock()
+	defer wh.mu.Unlock()
+
+	if wh.publishing.Swap(true) {
+		return
+	}
+
+	wh.lg.Info("publisher connected, stopping local source")
+	wh.stopPipelines()
+}
+
+// stopPublishing falls back to the local source when viewers are left
+func (wh *WebrtcHandler) stopPublishing(id string) {
+	wh.mu.Lock()
+	defer wh.mu.Unlock()
+
+	if wh.publisher != id {
+		return
+	}
+
+	wh.publisher = ""
+	if wh.relay != nil {
+		wh.relay.Revoke(id)
+	}
+
+	wh.resetKeyframes()
+
+	if wh.publishing.Swap(false) && wh.needsPipelines() {
+		wh.lg.Info("publisher gone, restarting local source")
+		wh.startPipelines()
+	}
+}
+
+// requestKeyframes sends a PLI on an interval so new viewers get a picture soon
+func (wh *WebrtcHandler) requestKeyframes(ctx context.Context, peerConnection *webrtc.PeerConnection, track *webrtc.TrackRemote) {
+	ticker := time.NewTicker(time.Second * 3)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if err := peerConnection.WriteRTCP([]rtcp.Packet{&rtcp.PictureLossIndication{MediaSSRC: uint32(track.SSRC())}}); err != nil {
+				if errors.Is(err, io.ErrClosedPipe) {
+					return
+				}
+
+				wh.lg.Error("failed to send PLI", zap.Error(err))
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// forwardTrack writes the publisher track to every viewer until it ends
+func (wh *WebrtcHandler) forwardTrack(track *webrtc.TrackRemote) error {
+	// passthrough viewers take the packets as they are
+	if track.Kind() == webrtc.RTPCodecTypeVideo && wh.cfg.Load().VideoSrc.Passthrough {
+		for {
+			p, _, err := track.ReadRTP()
+			if err != nil {
+				return err
+			}
+
+			wh.pushPacket(p)
+		}
+	}
+
+	depacketizer, err := newDepacketizer(track.Codec().MimeType)
+	if err != nil {
+		return err
+	}
+
+	sb := samplebuilder.New(maxLatePackets, depacketizer, track.Codec().ClockRate)
+
+	for {
+		p, _, err := track.ReadRTP()
+		if err != nil {
+			return err
+		}
+
+		sb.Push(p)
+
+		for s := sb.Pop(); s != nil; s = sb.Pop() {
+			if track.Kind() == webrtc.RTPCodecTypeVideo {
+				wh.recordVideo(*s)
+			} else {
+				wh.recordAudio(*s)
+			}
+
+			for _, ph := range wh.peers.Snapshot() {
+				if track.Kind() == webrtc.RTPCodecTypeVideo {
+					ph.PushVideo(*s)
+				} else {
+					ph.PushAudio(*s)
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,25 @@
+package webrtc
+
+import (
+	"testing"
+
+	"github.com/kaedwen/webrtc/pkg/common"
+)
+
+// every codec offered to a publisher needs a depacketizer for its samples
+func TestPublisherCodecsCanBeDepacketized(t *testing.T) {
+	for _, codec := range []common.StreamCodec{common.H264, common.H265, common.VP8, common.VP9} {
+		params, err := publisherCodec(codec)
+		if err != nil {
+			continue
+		}
+
+		if _, err := newDepacketizer(params.MimeType); err != nil {
+			t.Errorf("%s is offered but %s", codec, err)
+		}
+	}
+
+	if _, err := newPublisherAPI(common.H265); err == nil {
+		t.Fatal("expected H265 not to be offered to publishers")
+	}
+}
@@ -0,0 +1,85 @@
+package webrtc
+
+import (
+	"bytes"
+
+	"github.com/kaedwen/webrtc/pkg/common"
+	"github.com/pion/rtp"
+	"github.com/pion/webrtc/v3/pkg/media"
+	"go.uber.org/zap"
+)
+
+// isKeyframeSample reports whether the encoded sample can be decoded on its own
+func isKeyframeSample(codec common.StreamCodec, data []byte) bool {
+	switch codec {
+	case common.VP8:
+		// inverse key frame flag of the frame tag
+		return len(data) > 0 && data[0]&0x01 == 0
+	case common.VP9:
+		if len(data) < 1 || data[0]>>6 != 0x02 {
+			return false
+		}
+
+		// profile 3 carries a reserved bit before show_existing_frame
+		shift := 3
+		if (data[0]>>4)&0x03 == 0x03 {
+			shift = 2
+		}
+		if (data[0]>>shift)&0x01 != 0 {
+			return false
+		}
+		return (data[0]>>(shift-1))&0x01 == 0
+	case common.H264, common.H265:
+		// walk the annex b start codes
+		for i := bytes.Index(data, []byte{0, 0, 1}); i >= 0 && i+3 < len(data); {
+			nal := data[i+3]
+			if codec == common.H264 {
+				if t := nal & 0x1F; t == 5 || t == 7 {
+					return true
+				}
+			} else if t := (nal >> 1) & 0x3F; (t >= 16 && t <= 21) || t == 32 {
+				return true
+			}
+
+			next := bytes.Index(data[i+3:], []byte{0, 0, 1})
+			if next < 0 {
+				break
+			}
+			i += 3 + next
+		}
+	}
+
+	return false
+}
+
+func (wh *WebrtcHandler) recordAudio(s media.Sample) {
+	if wh.recorder != nil {
+		wh.recorder.PushAudio(s.Data, s.Duration)
+	}
+}
+
+func (wh *WebrtcHandler) recordVideo(s media.Sample) {
+	if wh.recorder != nil {
+		wh.recorder.PushVideo(s.Data, s.Duration, isKeyframeSample(wh.cfg.Load().VideoSrc.Codec, s.Data))
+	}
+}
+
+func (wh *WebrtcHandler) recordPacket(p *rtp.Packet) {
+	if wh.recorder == nil {
+		return
+	}
+
+	b, err := p.Marshal()
+	if err != nil {
+		wh.lg.Error("failed to record video packet", zap.Error(err))
+		return
+	}
+
+	wh.recorder.PushVideo(b, 0, isKeyframeStart(wh.cfg.Load().VideoSrc.Codec, p.Payload))
+}
+
+// needsPipelines reports whether the pipelines have to run, the recorder and
+// the motion detection need them all the time
+func (wh *WebrtcHandler) needsPipelines() bool {
+	return wh.recorder != nil || wh.cfg.Load().Motion.Enabled || wh.peers.Len() > 0 || wh.snapshotting.Load() > 0 || wh.consuming.Load() > 0
+}
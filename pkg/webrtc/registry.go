@@ -0,0 +1,170 @@
+package webrtc
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+
+	"github.com/kaedwen/webrtc/pkg/metrics"
+	"github.com/pion/rtp"
+	"github.com/pion/webrtc/v3"
+	"github.com/pion/webrtc/v3/pkg/media"
+	"go.uber.org/zap"
+)
+
+// samples or packets a peer may fall behind before they are dropped
+const peerQueueSize = 64
+
+type PeerHandle struct {
+	id            string
+	audioTrack    *webrtc.TrackLocalStaticSample
+	videoTrack    *webrtc.TrackLocalStaticSample
+	videoRTPTrack *webrtc.TrackLocalStaticRTP // used instead of videoTrack on passthrough
+	live          atomic.Bool                 // passthrough peer received the cached keyframe
+	audio         chan media.Sample
+	video         chan media.Sample
+	packets       chan *rtp.Packet
+}
+
+func newPeerHandle(id string) *PeerHandle {
+	return &PeerHandle{
+		id:      id,
+		audio:   make(chan media.Sample, peerQueueSize),
+		video:   make(chan media.Sample, peerQueueSize),
+		packets: make(chan *rtp.Packet, peerQueueSize),
+	}
+}
+
+// PushAudio queues the sample without blocking, a full queue drops it
+func (ph *PeerHandle) PushAudio(s media.Sample) {
+	select {
+	case ph.audio <- s:
+	default:
+		metrics.SamplesDropped.WithLabelValues("audio").Inc()
+	}
+}
+
+// PushVideo queues the sample without blocking, a full queue drops it
+func (ph *PeerHandle) PushVideo(s media.Sample) {
+	select {
+	case ph.video <- s:
+	default:
+		metrics.SamplesDropped.WithLabelValues("video").Inc()
+	}
+}
+
+// PushPacket queues the packet without blocking, a full queue drops it
+func (ph *PeerHandle) PushPacket(p *rtp.Packet) {
+	select {
+	case ph.packets <- p:
+	default:
+		metrics.SamplesDropped.WithLabelValues("video").Inc()
+	}
+}
+
+// run writes the queued samples to the tracks until the context is done,
+// every track has its own writer so a stalled one does not hold back the others
+func (ph *PeerHandle) run(ctx context.Context, lg *zap.Logger) {
+	lg = lg.With(zap.String("id", ph.id))
+
+	go writeSamples(ctx, lg, "audio", ph.audio, ph.audioTrack)
+
+	if ph.videoRTPTrack != nil {
+		go func() {
+			for {
+				select {
+				case p := <-ph.packets:
+					if err := ph.videoRTPTrack.WriteRTP(p); err != nil {
+						metrics.SamplesDropped.WithLabelValues("video").Inc()
+						lg.Error("failed to write video packet", zap.Error(err))
+						continue
+					}
+					metrics.SamplesWritten.WithLabelValues("video").Inc()
+				case <-ctx.Done():
+					return
+				}
+			}
+		}()
+	} else {
+		go writeSamples(ctx, lg, "video", ph.video, ph.videoTrack)
+	}
+}
+
+func writeSamples(ctx context.Context, lg *zap.Logger, kind string, ch <-chan media.Sample, track *webrtc.TrackLocalStaticSample) {
+	for {
+		select {
+		case s := <-ch:
+			if err := track.WriteSample(s); err != nil {
+				metrics.SamplesDropped.WithLabelValues(kind).Inc()
+				lg.Error("failed to write sample", zap.String("kind", kind), zap.Error(err))
+				continue
+			}
+			metrics.SamplesWritten.WithLabelValues(kind).Inc()
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// peerRegistry is copy-on-write, the sample loops read a snapshot without
+// locking while joins and leaves replace it
+type peerRegistry struct {
+	mu    *sync.Mutex
+	peers atomic.Pointer[map[string]*PeerHandle]
+}
+
+func newPeerRegistry() *peerRegistry {
+	r := peerRegistry{mu: &sync.Mutex{}}
+	r.peers.Store(&map[string]*PeerHandle{})
+	return &r
+}
+
+// Snapshot returns the current peers, the map must not be modified
+func (r *peerRegistry) Snapshot() map[string]*PeerHandle {
+	return *r.peers.Load()
+}
+
+func (r *peerRegistry) Len() int {
+	return len(r.Snapshot())
+}
+
+// Add stores the handle and returns the number of peers
+func (r *peerRegistry) Add(ph *PeerHandle) int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	current := *r.peers.Load()
+	next := make(map[string]*PeerHandle, len(current)+1)
+	for id, p := range current {
+		next[id] = p
+	}
+	next[ph.id] = ph
+
+	r.peers.Store(&next)
+	metrics.PeersActive.Set(float64(len(next)))
+
+	return len(next)
+}
+
+// Remove drops the handle, it reports whether it was present and the number of peers left
+func (r *peerRegistry) Remove(id string) (bool, int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	current := *r.peers.Load()
+	if _, ok := current[id]; !ok {
+		return false, len(current)
+	}
+
+	next := make(map[string]*PeerHandle, len(current))
+	for pid, p := range current {
+		if pid != id {
+			next[pid] = p
+		}
+	}
+
+	r.peers.Store(&next)
+	metrics.PeersActive.Set(float64(len(next)))
+
+	return true, len(next)
+}
@@ -0,0 +1,222 @@
+package webrtc
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/pion/webrtc/v3"
+	"github.com/pion/webrtc/v3/pkg/media"
+	"go.uber.org/zap"
+)
+
+// fakeSource hands samples to the peers of the registry the way the sample loops of the pipelines do
+type fakeSource struct {
+	peers *peerRegistry
+	sent  int
+}
+
+func (s *fakeSource) push() {
+	sample := media.Sample{Data: []byte{byte(s.sent)}, Duration: time.Millisecond}
+	for _, ph := range s.peers.Snapshot() {
+		ph.PushAudio(sample)
+		ph.PushVideo(sample)
+	}
+	s.sent++
+}
+
+// run pushes until the context is done
+func (s *fakeSource) run(ctx context.Context) {
+	for ctx.Err() == nil {
+		s.push()
+		time.Sleep(100 * time.Microsecond)
+	}
+}
+
+func newTestPeer(t *testing.T, id string) *PeerHandle {
+	t.Helper()
+
+	ph := newPeerHandle(id)
+
+	var err error
+	ph.audioTrack, err = webrtc.NewTrackLocalStaticSample(webrtc.RTPCodecCapability{MimeType: webrtc.MimeTypeOpus}, "audio", id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ph.videoTrack, err = webrtc.NewTrackLocalStaticSample(webrtc.RTPCodecCapability{MimeType: webrtc.MimeTypeVP8}, "video", id)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return ph
+}
+
+// waitFor polls the condition, the writers run on their own goroutines
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+
+	deadline := time.Now().Add(2 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestPeerRegistryAddRemove(t *testing.T) {
+	r := newPeerRegistry()
+
+	if n := r.Add(newPeerHandle("a")); n != 1 {
+		t.Fatalf("expected 1 peer, got %d", n)
+	}
+	if n := r.Add(newPeerHandle("b")); n != 2 {
+		t.Fatalf("expected 2 peers, got %d", n)
+	}
+
+	// a snapshot taken before is not changed by later leaves
+	before := r.Snapshot()
+
+	if ok, n := r.Remove("a"); !ok || n != 1 {
+		t.Fatalf("expected removal with 1 left, got %t and %d", ok, n)
+	}
+	if ok, n := r.Remove("a"); ok || n != 1 {
+		t.Fatalf("expected no second removal, got %t and %d", ok, n)
+	}
+
+	if len(before) != 2 {
+		t.Fatalf("snapshot changed to %d peers", len(before))
+	}
+	if _, ok := r.Snapshot()["b"]; !ok || r.Len() != 1 {
+		t.Fatalf("expected only b to be left, got %v", r.Snapshot())
+	}
+}
+
+func TestPeerRegistryConcurrentLifecycle(t *testing.T) {
+	r := newPeerRegistry()
+	src := &fakeSource{peers: r}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	done := make(chan struct{})
+	go func() {
+		src.run(ctx)
+		close(done)
+	}()
+
+	// t.Fatal is for the test goroutine only, the peers are built up front
+	peers := make([]*PeerHandle, 20)
+	for i := range peers {
+		peers[i] = newTestPeer(t, fmt.Sprint("peer-", i))
+	}
+
+	// peers join, stream and leave while the source fans out
+	var wg sync.WaitGroup
+	for i, ph := range peers {
+		wg.Add(1)
+		go func(i int, ph *PeerHandle) {
+			defer wg.Done()
+
+			pctx, pcancel := context.WithCancel(ctx)
+			defer pcancel()
+
+			ph.run(pctx, zap.NewNop())
+			r.Add(ph)
+
+			time.Sleep(time.Duration(i%5) * time.Millisecond)
+
+			if ok, _ := r.Remove(ph.id); !ok {
+				t.Errorf("peer %s was not registered", ph.id)
+			}
+		}(i, ph)
+	}
+	wg.Wait()
+
+	cancel()
+	<-done
+
+	if r.Len() != 0 {
+		t.Fatalf("expected no peers left, got %d", r.Len())
+	}
+}
+
+func TestSlowPeerDoesNotStallOthers(t *testing.T) {
+	r := newPeerRegistry()
+	src := &fakeSource{peers: r}
+
+	// the slow peer never writes, its queue fills up
+	slow := newPeerHandle("slow")
+	fast := newPeerHandle("fast")
+	r.Add(slow)
+	r.Add(fast)
+
+	n := 4 * peerQueueSize
+	pushed := make(chan struct{})
+	go func() {
+		defer close(pushed)
+		for i := 0; i < n; i++ {
+			src.push()
+			// the fast peer gets every single sample
+			if s := <-fast.video; int(s.Data[0]) != i%256 {
+				t.Errorf("expected sample %d, got %d", i%256, s.Data[0])
+				return
+			}
+			<-fast.audio
+		}
+	}()
+
+	select {
+	case <-pushed:
+	case <-time.After(2 * time.Second):
+		t.Fatal("the source got stuck on the slow peer")
+	}
+
+	if len(slow.video) != peerQueueSize || len(slow.audio) != peerQueueSize {
+		t.Fatalf("expected the slow queues to be full, got %d and %d", len(slow.video), len(slow.audio))
+	}
+}
+
+func TestPeerStop(t *testing.T) {
+	r := newPeerRegistry()
+	src := &fakeSource{peers: r}
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	ph := newTestPeer(t, "peer")
+	ph.run(ctx, zap.NewNop())
+	r.Add(ph)
+
+	// the writers drain the queues while running
+	for i := 0; i < peerQueueSize; i++ {
+		src.push()
+	}
+	waitFor(t, "the queues to drain", func() bool {
+		return len(ph.video) == 0 && len(ph.audio) == 0
+	})
+
+	cancel()
+	r.Remove(ph.id)
+
+	// give the writers the time to notice
+	time.Sleep(50 * time.Millisecond)
+
+	// a stopped peer takes no more writes, pushing to it directly fills the queues
+	for i := 0; i < peerQueueSize; i++ {
+		ph.PushVideo(media.Sample{Data: []byte{0}})
+		ph.PushAudio(media.Sample{Data: []byte{0}})
+	}
+	time.Sleep(50 * time.Millisecond)
+
+	if len(ph.video) != peerQueueSize || len(ph.audio) != peerQueueSize {
+		t.Fatalf("expected the stopped writers to leave the queues, got %d and %d", len(ph.video), len(ph.audio))
+	}
+
+	// the source does not reach a removed peer
+	src.push()
+	if len(ph.video) != peerQueueSize {
+		t.Fatalf("removed peer still got samples")
+	}
+}
@@ -0,0 +1,187 @@
+package webrtc
+
+import (
+	"context"
+	"reflect"
+	"time"
+
+	"github.com/go-gst/go-gst/gst"
+	"github.com/kaedwen/webrtc/pkg/common"
+	"github.com/kaedwen/webrtc/pkg/streamer"
+	"go.uber.org/zap"
+)
+
+// Reload applies the stream config of a reload, the bitrate is changed on the running encoder
+// and only the pipelines of changed sources are rebuilt, the peers stay connected
+func (wh *WebrtcHandler) Reload(cfg *common.ConfigStream) error {
+	wh.mu.Lock()
+	defer wh.mu.Unlock()
+
+	cur := wh.cfg.Load()
+	next := *cfg
+
+	// the tracks of the connected peers are bound to the codecs
+	if next.VideoSrc.Codec != cur.VideoSrc.Codec || next.VideoSrc.Passthrough != cur.VideoSrc.Passthrough || next.AudioSrc.Codec != cur.AudioSrc.Codec {
+		wh.lg.Warn("codec changes take effect after a restart")
+		next.VideoSrc.Codec, next.VideoSrc.Passthrough, next.AudioSrc.Codec = cur.VideoSrc.Codec, cur.VideoSrc.Passthrough, cur.AudioSrc.Codec
+	}
+
+	rebuildAudio := !reflect.DeepEqual(cur.AudioSrc, next.AudioSrc)
+	rebuildVideo := videoChanged(cur, &next)
+
+	wh.cfg.Store(&next)
+
+	var steps []step
+	if rebuildAudio {
+		steps = append(steps, step{apply: wh.rebuildAudio, undo: wh.rebuildAudio})
+	}
+
+	if rebuildVideo {
+		steps = append(steps, step{apply: wh.rebuildVideo, undo: wh.rebuildVideo})
+	} else if next.VideoSrc.Bitrate != cur.VideoSrc.Bitrate && !next.VideoSrc.Passthrough {
+		steps = append(steps, step{
+			apply: func() error {
+				if err := streamer.SetBitrate(wh.videoPipeline, next.VideoSrc.Codec, next.VideoSrc.Bitrate); err != nil {
+					return err
+				}
+				wh.lg.Info("changed video bitrate", zap.Uint("bitrate", next.VideoSrc.Bitrate))
+				return nil
+			},
+			undo: func() error {
+				return streamer.SetBitrate(wh.videoPipeline, cur.VideoSrc.Codec, cur.VideoSrc.Bitrate)
+			},
+		})
+	}
+
+	return applySteps(wh.lg, func() { wh.cfg.Store(cur) }, steps...)
+}
+
+// step changes a pipeline to the stored config, undo brings it back once the running one is stored again
+type step struct {
+	apply func() error
+	undo  func() error
+}
+
+// applySteps runs the steps in order, when one fails the running config is stored again and
+// every step so far is undone, the failed one included, so no pipeline is left on the new config
+func applySteps(lg *zap.Logger, rollback func(), steps ...step) error {
+	for i, s := range steps {
+		err := s.apply()
+		if err == nil {
+			continue
+		}
+
+		rollback()
+		for j := i; j >= 0; j-- {
+			if err := steps[j].undo(); err != nil {
+				lg.Error("failed to restore pipeline", zap.Error(err))
+			}
+		}
+
+		return err
+	}
+
+	return nil
+}
+
+// videoChanged tells whether the video pipeline has to be rebuilt, a new bitrate is set on the running one
+func videoChanged(cur *common.ConfigStream, next *common.ConfigStream) bool {
+	a, b := cur.VideoSrc, next.VideoSrc
+	a.Bitrate, b.Bitrate = 0, 0
+
+	return !reflect.DeepEqual(a, b) || !reflect.DeepEqual(cur.Fallback, next.Fallback) || !reflect.DeepEqual(cur.Motion, next.Motion)
+}
+
+func (wh *WebrtcHandler) buildAudio() error {
+	ctx, cancel := context.WithCancel(wh.ctx)
+	wh.audioCancel = cancel
+
+	cfg := wh.cfg.Load()
+
+	return wh.handleAudioSamples(ctx, &cfg.AudioSrc)
+}
+
+func (wh *WebrtcHandler) buildVideo() error {
+	ctx, cancel := context.WithCancel(wh.ctx)
+	wh.videoCancel = cancel
+
+	cfg := wh.cfg.Load()
+
+	// the parts of the new pipeline start over
+	if err := wh.playlist.Configure(cfg.Fallback.HLSDir.String(), cfg.Fallback.HLSPart, time.Duration(cfg.Fallback.HLSSegment)*time.Second); err != nil {
+		return err
+	}
+
+	var err error
+	if cfg.VideoSrc.Passthrough {
+		err = wh.handleVideoPackets(ctx, &cfg.VideoSrc)
+	} else {
+		err = wh.handleVideoSamples(ctx, &cfg.VideoSrc)
+	}
+	if err != nil {
+		return err
+	}
+
+	if err := wh.handleMJPEG(ctx); err != nil {
+		return err
+	}
+
+	if err := wh.handleHLS(); err != nil {
+		return err
+	}
+
+	return wh.handleMotion(ctx)
+}
+
+// rebuildAudio replaces the audio pipeline, it is started again when in use
+func (wh *WebrtcHandler) rebuildAudio() error {
+	wh.audioCancel()
+	if wh.audioPipeline != nil {
+		_ = wh.audioPipeline.SetState(gst.StateNull)
+	}
+
+	if err := wh.buildAudio(); err != nil {
+		return err
+	}
+
+	if err := probePipeline(wh.audioPipeline); err != nil {
+		return err
+	}
+	wh.audioHealth.Set(nil)
+	wh.lg.Info("rebuilt audio pipeline")
+
+	wh.restartPipelines()
+
+	return nil
+}
+
+// rebuildVideo replaces the video pipeline and its branches, it is started again when in use
+func (wh *WebrtcHandler) rebuildVideo() error {
+	wh.videoCancel()
+	if wh.videoPipeline != nil {
+		_ = wh.videoPipeline.SetState(gst.StateNull)
+	}
+
+	if err := wh.buildVideo(); err != nil {
+		return err
+	}
+
+	if err := probePipeline(wh.videoPipeline); err != nil {
+		return err
+	}
+	wh.videoHealth.Set(nil)
+	wh.lg.Info("rebuilt video pipeline")
+
+	wh.openValves()
+
+	wh.restartPipelines()
+
+	return nil
+}
+
+// restartPipelines starts the pipelines after a rebuild when anyone needs them
+func (wh *WebrtcHandler) restartPipelines() {
+	if wh.needsPipelines() {
+		wh.startPipelines()
+	}
+}
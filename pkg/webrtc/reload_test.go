@@ -0,0 +1,84 @@
+package webrtc
+
+import (
+	"errors"
+	"testing"
+
+	"go.uber.org/zap"
+)
+
+// fakePipeline stands in for a pipeline built from the stored config
+type fakePipeline struct {
+	stored *string
+	built  string
+	fail   string // config it fails to build
+}
+
+func (p *fakePipeline) rebuild() error {
+	if *p.stored == p.fail {
+		return errors.New("failed to build " + p.fail)
+	}
+	p.built = *p.stored
+	return nil
+}
+
+func (p *fakePipeline) step() step {
+	return step{apply: p.rebuild, undo: p.rebuild}
+}
+
+func TestApplyStepsRestoresEarlierSteps(t *testing.T) {
+	stored := "next"
+	audio := &fakePipeline{stored: &stored, built: "cur"}
+	video := &fakePipeline{stored: &stored, built: "cur", fail: "next"}
+
+	// the audio is rebuilt, the video fails afterwards
+	err := applySteps(zap.NewNop(), func() { stored = "cur" }, audio.step(), video.step())
+	if err == nil {
+		t.Fatal("expected the failed video to be reported")
+	}
+
+	if stored != "cur" {
+		t.Fatalf("expected the running config to be stored again, got %s", stored)
+	}
+	if audio.built != "cur" {
+		t.Fatalf("expected the audio to be restored, it runs on %s", audio.built)
+	}
+	if video.built != "cur" {
+		t.Fatalf("expected the video to be restored, it runs on %s", video.built)
+	}
+}
+
+func TestApplyStepsKeepsLaterStepsUntouched(t *testing.T) {
+	stored := "next"
+	audio := &fakePipeline{stored: &stored, built: "cur", fail: "next"}
+
+	var applied, undone bool
+	later := step{
+		apply: func() error { applied = true; return nil },
+		undo:  func() error { undone = true; return nil },
+	}
+
+	if err := applySteps(zap.NewNop(), func() { stored = "cur" }, audio.step(), later); err == nil {
+		t.Fatal("expected the failed audio to be reported")
+	}
+	if applied || undone {
+		t.Fatalf("expected the later step to be left alone, applied %t and undone %t", applied, undone)
+	}
+	if audio.built != "cur" {
+		t.Fatalf("expected the audio to be restored, it runs on %s", audio.built)
+	}
+}
+
+func TestApplyStepsSucceeds(t *testing.T) {
+	stored := "next"
+	audio := &fakePipeline{stored: &stored, built: "cur"}
+	video := &fakePipeline{stored: &stored, built: "cur"}
+
+	rolledBack := false
+	if err := applySteps(zap.NewNop(), func() { rolledBack = true }, audio.step(), video.step()); err != nil {
+		t.Fatal(err)
+	}
+	if rolledBack || audio.built != "next" || video.built != "next" {
+		t.Fatalf("expected both to run on next, got %s and %s", audio.built, video.built)
+	}
+}
@@ -0,0 +1,91 @@
+package webrtc
+
+import (
+	"context"
+
+	"github.com/kaedwen/webrtc/pkg/server"
+	"github.com/pion/webrtc/v3"
+	"go.uber.org/zap"
+)
+
+// answerOffer applies the remote offer and sends the answer back
+func answerOffer(sh *server.SignalingHandle, peerConnection *webrtc.PeerConnection, offer webrtc.SessionDescription) error {
+	// Set the remote SessionDescription
+	err := peerConnection.SetRemoteDescription(offer)
+	if err != nil {
+		return err
+	}
+
+	// Create an answer
+	answer, err := peerConnection.CreateAnswer(nil)
+	if err != nil {
+		return err
+	}
+
+	// Create channel that is blocked until ICE Gathering is complete
+	gatherComplete := webrtc.GatheringCompletePromise(peerConnection)
+
+	// Sets the LocalDescription, and starts our UDP listeners
+	err = peerConnection.SetLocalDescription(answer)
+	if err != nil {
+		return err
+	}
+
+	// without trickle the answer has to carry all candidates
+	if !sh.Trickle {
+		<-gatherComplete
+	}
+
+	// Send the answer
+	sh.Trcv <- server.NewAnswerMessage(peerConnection.LocalDescription())
+
+	return nil
+}
+
+// handleSignaling processes the incoming messages until the channel is closed, which calls onClose
+func (wh *WebrtcHandler) handleSignaling(ctx context.Context, sh *server.SignalingHandle, peerConnection *webrtc.PeerConnection, onClose func()) {
+	for {
+		select {
+		case m, ok := <-sh.Recv:
+			// return when channel is closed
+			if !ok {
+				onClose()
+				return
+			}
+
+			switch true {
+			case m.IsIceCandidateMessage():
+				pm, err := m.ToIceCandidateMessage()
+				if err != nil {
+					wh.lg.Error("failed to parse candidate", zap.Error(err))
+					continue
+				}
+
+				wh.lg.Info("received new ice candidate", zap.Any("candidate", pm.Candidate))
+
+				err = peerConnection.AddICECandidate(pm.Candidate)
+				if err != nil {
+					wh.lg.Error("failed to add ice candidate", zap.Error(err))
+				}
+			case m.IsAnswerMessage():
+				wh.lg.Info("received answer", zap.Any("data", m.Data))
+			case m.IsOfferMessage():
+				pm, err := m.ToOfferMessage()
+				if err != nil {
+					wh.lg.Error("failed to parse offer", zap.Error(err))
+					continue
+				}
+
+				wh.lg.Info("received offer", zap.Any("data", pm.Offer))
+
+				err = answerOffer(sh, peerConnection, pm.Offer)
+				if err != nil {
+					wh.lg.Error("failed to handle offer", zap.Error(err))
+					sh.Trcv <- server.NewErrorMessage(err)
+				}
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
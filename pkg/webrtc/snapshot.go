@@ -0,0 +1,60 @@
+package webrtc
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/kaedwen/webrtc/pkg/server"
+)
+
+// time the pipeline gets to deliver a frame after it was started
+const snapshotTimeout = 5 * time.Second
+
+func (wh *WebrtcHandler) handleSnapshots(ctx context.Context, ch <-chan *server.SnapshotRequest) {
+	for {
+		select {
+		case req := <-ch:
+			go func() {
+				image, err := wh.snapshot()
+				req.Reply <- server.SnapshotReply{Image: image, Err: err}
+			}()
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// snapshot takes the next frame of the video pipeline, it is started for
+// the time being when nobody else needs it
+func (wh *WebrtcHandler) snapshot() ([]byte, error) {
+	if wh.publishing.Load() {
+		return nil, errors.New("no local frames while a publisher is connected")
+	}
+
+	wh.snapshotting.Add(1)
+	defer func() {
+		if wh.snapshotting.Add(-1) > 0 {
+			return
+		}
+
+		wh.mu.Lock()
+		defer wh.mu.Unlock()
+
+		if !wh.needsPipelines() {
+			wh.stopPipelines()
+		}
+	}()
+
+	// the sink is taken along, the pipeline may be rebuilt meanwhile
+	wh.mu.Lock()
+	wh.startPipelines()
+	sink := wh.snapshotSink
+	wh.mu.Unlock()
+
+	if sink == nil {
+		return nil, errors.New("video pipeline has no snapshot branch")
+	}
+
+	return sink.Pull(snapshotTimeout)
+}
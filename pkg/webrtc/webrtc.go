@@ -6,50 +6,113 @@ import (
 	"fmt"
 	"io"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/go-gst/go-gst/gst"
 	"github.com/kaedwen/webrtc/pkg/common"
+	"github.com/kaedwen/webrtc/pkg/events"
+	"github.com/kaedwen/webrtc/pkg/hls"
+	"github.com/kaedwen/webrtc/pkg/metrics"
+	"github.com/kaedwen/webrtc/pkg/recorder"
 	"github.com/kaedwen/webrtc/pkg/server"
 	"github.com/kaedwen/webrtc/pkg/streamer"
+	"github.com/kaedwen/webrtc/pkg/turn"
 	"github.com/pion/rtcp"
 	"github.com/pion/webrtc/v3"
 	"github.com/pion/webrtc/v3/pkg/media"
 	"go.uber.org/zap"
 )
 
+// used when no ice servers are configured
 const STUN_SERVER = "stun:stun.l.google.com:19302"
 
 type WebrtcHandler struct {
 	lg            *zap.Logger
 	mu            *sync.Mutex
-	cfg           *common.ConfigStream
+	ctx           context.Context                     // parent of the pipeline contexts
+	cfg           atomic.Pointer[common.ConfigStream] // replaced on reload
+	api           *webrtc.API
+	publisherAPI  *webrtc.API
+	relay         *turn.TurnServer
+	recorder      *recorder.Recorder
+	bus           *events.Bus
 	audioPipeline *gst.Pipeline
 	videoPipeline *gst.Pipeline
-	peerHandles   map[string]*PeerHandle
+	audioHealth   *pipelineHealth
+	videoHealth   *pipelineHealth
+	audioCancel   context.CancelFunc // stops the consumers of the audio pipeline
+	videoCancel   context.CancelFunc // stops the consumers of the video pipeline
+	snapshotSink  *streamer.SnapshotSink
+	snapshotting  atomic.Int32 // pending snapshots keep the pipelines running
+	consumerMu    *sync.Mutex
+	consumers     map[server.StreamKind]map[*server.StreamRequest]bool
+	consuming     atomic.Int32 // fallback stream consumers keep the pipelines running
+	playlist      *hls.Playlist
+	keyframes     *keyframeCache
+	videoMu       *sync.Mutex // orders the keyframe replay against the live packets
+	peers         *peerRegistry
+	publisher     string      // id of the connected whip publisher
+	publishing    atomic.Bool // publisher replaces the local pipelines
+	muted         atomic.Bool // drops the audio of the peers instead of playing it
 }
 
-type PeerHandle struct {
-	audioTrack *webrtc.TrackLocalStaticSample
-	videoTrack *webrtc.TrackLocalStaticSample
-}
-
-func NewWebrtcHandler(ctx context.Context, lg *zap.Logger, cfg *common.ConfigStream, ch <-chan *server.SignalingHandle) error {
+func NewWebrtcHandler(ctx context.Context, lg *zap.Logger, cfg *common.ConfigStream, ch <-chan *server.SignalingHandle, publish <-chan *server.SignalingHandle, snapshots <-chan *server.SnapshotRequest, streams <-chan *server.StreamRequest, playlist *hls.Playlist, bus *events.Bus, health *common.Health, relay *turn.TurnServer, rec *recorder.Recorder) (*WebrtcHandler, error) {
 	wh := WebrtcHandler{
 		lg:          lg,
-		cfg:         cfg,
+		ctx:         ctx,
+		relay:       relay,
+		recorder:    rec,
+		bus:         bus,
 		mu:          &sync.Mutex{},
-		peerHandles: make(map[string]*PeerHandle, 0),
+		videoMu:     &sync.Mutex{},
+		consumerMu:  &sync.Mutex{},
+		consumers:   make(map[server.StreamKind]map[*server.StreamRequest]bool),
+		playlist:    playlist,
+		audioHealth: &pipelineHealth{},
+		videoHealth: &pipelineHealth{},
+		peers:       newPeerRegistry(),
 	}
 
-	err := wh.handleAudioSamples(ctx, &cfg.AudioSrc)
+	wh.cfg.Store(cfg)
+
+	var err error
+	wh.api, err = newAPI()
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	err = wh.handleVideoSamples(ctx, &cfg.VideoSrc)
+	// the viewers are served anyway, publishers are turned away
+	wh.publisherAPI, err = newPublisherAPI(cfg.VideoSrc.Codec)
 	if err != nil {
-		return err
+		lg.Warn("publishing is disabled", zap.Error(err))
+	}
+
+	err = wh.buildAudio()
+	if err != nil {
+		return nil, err
+	}
+
+	err = wh.buildVideo()
+	if err != nil {
+		return nil, err
+	}
+
+	// check the pipelines once before anyone connects
+	wh.probePipelines()
+	health.AddReadinessCheck("audio-pipeline", wh.audioHealth.Check)
+	health.AddReadinessCheck("video-pipeline", wh.videoHealth.Check)
+
+	go wh.handleFallbacks(ctx, streams)
+	go wh.handlePublishers(ctx, publish)
+	go wh.handleSnapshots(ctx, snapshots)
+	go wh.handleMute(bus.Subscribe(ctx, "mute", events.DefaultQueueSize, events.KindMute))
+
+	// recorder and motion detection need the stream before anyone rings
+	if wh.needsPipelines() {
+		wh.mu.Lock()
+		wh.startPipelines()
+		wh.mu.Unlock()
 	}
 
 	go func() {
@@ -65,7 +128,9 @@ func NewWebrtcHandler(ctx context.Context, lg *zap.Logger, cfg *common.ConfigStr
 				}
 
 				// make sure the pipelines are running
+				wh.mu.Lock()
 				wh.startPipelines()
+				wh.mu.Unlock()
 
 			case <-ctx.Done():
 				return
@@ -73,16 +138,21 @@ func NewWebrtcHandler(ctx context.Context, lg *zap.Logger, cfg *common.ConfigStr
 		}
 	}()
 
-	return nil
+	return &wh, nil
 }
 
 func (wh *WebrtcHandler) startPipelines() {
+	// the publisher delivers the stream instead
+	if wh.publishing.Load() {
+		return
+	}
 
 	if wh.audioPipeline.GetCurrentState() != gst.StatePlaying {
 		err := wh.audioPipeline.SetState(gst.StatePlaying)
 		if err != nil {
 			wh.lg.Fatal("failed to start audio pipeline", zap.Error(err))
 		}
+		wh.audioHealth.Set(nil)
 		wh.lg.Info("started audio pipeline")
 	}
 
@@ -91,6 +161,7 @@ func (wh *WebrtcHandler) startPipelines() {
 		if err != nil {
 			wh.lg.Fatal("failed to start video pipeline", zap.Error(err))
 		}
+		wh.videoHealth.Set(nil)
 		wh.lg.Info("started video pipeline")
 	}
 
@@ -109,48 +180,31 @@ func (wh *WebrtcHandler) stopPipelines() {
 		wh.lg.Fatal("failed to pause video pipeline", zap.Error(err))
 	}
 
+	// the next start begins with a new stream
+	wh.resetKeyframes()
+
 }
 
 func (wh *WebrtcHandler) handleAudioSamples(ctx context.Context, cfg *common.ConfigAudioSourceStream) error {
-	properties := map[string]interface{}{}
-	if cfg.Source == "alsasrc" || cfg.Source == "pulsesrc" {
-		if cfg.Device != nil {
-			properties["device"] = *cfg.Device
-		} else {
-			properties["device"] = cfg.DeviceName
-		}
-	}
-
-	src := streamer.StreamElement{
-		Kind:       cfg.Source,
-		Properties: properties,
-		SrcCaps: streamer.NewCaps("audio/x-raw", map[string]any{
-			"channels": cfg.Channels,
-			"rate":     48000,
-		}),
-		Queue: cfg.Queue,
-		Codec: cfg.Codec,
-	}
+	src := streamer.NewAudioSourceElement(cfg)
 
 	var err error
 	var audioCh <-chan media.Sample
-	wh.audioPipeline, audioCh, err = streamer.CreateAudioPipelineSink(src, wh.lg)
+	wh.audioPipeline, audioCh, err = streamer.CreateAudioPipelineSink(*src, wh.lg)
 	if err != nil {
 		return err
 	}
 
-	streamer.LoopBus(wh.lg.With(zap.String("sub-context", "audio")), wh.audioPipeline)
+	streamer.LoopBus(ctx, wh.lg.With(zap.String("sub-context", "audio")), wh.audioPipeline, wh.busErrorHandler("audio", wh.audioHealth))
 
 	go func() {
 		wh.lg.Info("wait for audio sample")
 		for {
 			select {
 			case data := <-audioCh:
-				for id, ph := range wh.peerHandles {
-					err := ph.audioTrack.WriteSample(data)
-					if err != nil {
-						wh.lg.Error("failed to write audio sample", zap.String("id", id), zap.Error(err))
-					}
+				wh.recordAudio(data)
+				for _, ph := range wh.peers.Snapshot() {
+					ph.PushAudio(data)
 				}
 			case <-ctx.Done():
 				return
@@ -162,41 +216,47 @@ func (wh *WebrtcHandler) handleAudioSamples(ctx context.Context, cfg *common.Con
 }
 
 func (wh *WebrtcHandler) handleVideoSamples(ctx context.Context, cfg *common.ConfigVideoSourceStream) error {
-	src := streamer.StreamElement{
-		Kind: cfg.Source,
-		Properties: map[string]interface{}{
-			"device": cfg.Device,
-		},
-		SrcCaps: streamer.NewCaps("video/x-raw", map[string]any{
-			"height":    cfg.Height,
-			"width":     cfg.Width,
-			"framerate": fmt.Sprintf("%d/1", cfg.Framerate),
-			"format":    "YUY2",
-		}),
-		Bitrate: cfg.Bitrate,
-		Queue:   cfg.Queue,
-		Codec:   cfg.Codec,
+	src, err := streamer.NewVideoSourceElement(cfg)
+	if err != nil {
+		return err
+	}
+
+	src.Bitrate = cfg.Bitrate
+	src.Queue = cfg.Queue
+	src.Codec = cfg.Codec
+	src.Branches = wh.branches()
+
+	if src.Warning != nil {
+		wh.lg.Warn("video format", zap.Error(src.Warning))
+	}
+
+	if f := src.Format; f != nil {
+		wh.lg.Info("chose video format", zap.Stringer("format", f))
+		metrics.VideoFormat.Reset()
+		metrics.VideoFormat.WithLabelValues(f.Media, f.Format, fmt.Sprint(f.Width), fmt.Sprint(f.Height), f.Framerate).Set(1)
 	}
 
-	var err error
 	var videoCh <-chan media.Sample
-	wh.videoPipeline, videoCh, err = streamer.CreateVideoPipelineSink(wh.lg, src)
+	wh.videoPipeline, videoCh, err = streamer.CreateVideoPipelineSink(wh.lg, *src)
 	if err != nil {
 		return err
 	}
 
-	streamer.LoopBus(wh.lg.With(zap.String("sub-context", "video")), wh.videoPipeline)
+	wh.snapshotSink, err = streamer.NewSnapshotSink(wh.videoPipeline)
+	if err != nil {
+		return err
+	}
+
+	streamer.LoopBus(ctx, wh.lg.With(zap.String("sub-context", "video")), wh.videoPipeline, wh.busErrorHandler("video", wh.videoHealth))
 
 	go func() {
 		wh.lg.Info("wait for video sample")
 		for {
 			select {
 			case data := <-videoCh:
-				for id, ph := range wh.peerHandles {
-					err := ph.videoTrack.WriteSample(data)
-					if err != nil {
-						wh.lg.Error("failed to write video sample", zap.String("id", id), zap.Error(err))
-					}
+				wh.recordVideo(data)
+				for _, ph := range wh.peers.Snapshot() {
+					ph.PushVideo(data)
 				}
 			case <-ctx.Done():
 				return
@@ -211,23 +271,37 @@ func (wh *WebrtcHandler) createPeerHandle(rctx context.Context, sh *server.Signa
 	wh.mu.Lock()
 	defer wh.mu.Unlock()
 
-	// Prepare the configuration
+	// Prepare the configuration, the client uses the same servers
+	servers := iceServers(&wh.cfg.Load().Ice, sh.Id)
+
+	// the embedded turn server is always offered
+	if wh.relay != nil {
+		is, err := wh.relay.NewCredentials(sh.Id)
+		if err != nil {
+			return err
+		}
+		servers = append(servers, is)
+	}
+
+	sh.Trcv <- server.NewIceServersMessage(servers)
+
 	config := webrtc.Configuration{
-		ICEServers: []webrtc.ICEServer{{URLs: []string{STUN_SERVER}}},
+		ICEServers: servers,
 	}
 
 	// Create a new RTCPeerConnection
-	peerConnection, err := webrtc.NewPeerConnection(config)
+	peerConnection, err := wh.api.NewPeerConnection(config)
 	if err != nil {
 		return err
 	}
 
 	// create a context for this handle
 	hctx, hcancel := context.WithCancel(rctx)
+	context.AfterFunc(hctx, func() { close(sh.Done) })
 
 	// sent the candidate out when where is one
 	peerConnection.OnICECandidate(func(i *webrtc.ICECandidate) {
-		if i != nil {
+		if i != nil && sh.Trickle {
 			sh.Trcv <- server.NewIceCandidateMessage(*i)
 		}
 	})
@@ -236,7 +310,7 @@ func (wh *WebrtcHandler) createPeerHandle(rctx context.Context, sh *server.Signa
 	// for the given codec
 	peerConnection.OnTrack(func(track *webrtc.TrackRemote, receiver *webrtc.RTPReceiver) {
 		wh.lg.Info("received track", zap.String("kind", track.Kind().String()), zap.String("codec", track.Codec().MimeType))
-		cfg := wh.cfg.AudioSink
+		cfg := wh.cfg.Load().AudioSink
 
 		if track.Codec().MimeType != "audio/opus" {
 			wh.lg.Error("mimetype not supported", zap.String("mime", track.Codec().MimeType))
@@ -301,7 +375,7 @@ func (wh *WebrtcHandler) createPeerHandle(rctx context.Context, sh *server.Signa
 				continue
 			}
 
-			if n > 0 {
+			if n > 0 && !wh.muted.Load() {
 				err := pipeline.Push(buf[:n])
 				if err != nil {
 					wh.lg.Error("push failed", zap.Error(err))
@@ -319,21 +393,12 @@ func (wh *WebrtcHandler) createPeerHandle(rctx context.Context, sh *server.Signa
 			peerConnection.Close()
 			hcancel()
 
-			// remove this handle
-			wh.mu.Lock()
-
-			delete(wh.peerHandles, sh.Id)
-
-			if len(wh.peerHandles) == 0 {
-				wh.stopPipelines()
-			}
-
-			wh.mu.Unlock()
+			wh.removePeerHandle(sh.Id)
 		}
 	})
 
 	// Create a audio track
-	audioTrack, err := webrtc.NewTrackLocalStaticSample(webrtc.RTPCodecCapability{MimeType: wh.cfg.AudioSrc.Codec.Mime()}, "audio", "pion1")
+	audioTrack, err := webrtc.NewTrackLocalStaticSample(webrtc.RTPCodecCapability{MimeType: wh.cfg.Load().AudioSrc.Codec.Mime()}, "audio", "pion1")
 	if err != nil {
 		return err
 	}
@@ -342,107 +407,91 @@ func (wh *WebrtcHandler) createPeerHandle(rctx context.Context, sh *server.Signa
 		return err
 	}
 
-	// Create a video track
-	videoTrack, err := webrtc.NewTrackLocalStaticSample(webrtc.RTPCodecCapability{MimeType: wh.cfg.VideoSrc.Codec.Mime()}, "video", "pion2")
-	if err != nil {
-		return err
-	}
-	_, err = peerConnection.AddTrack(videoTrack)
-	if err != nil {
-		return err
-	}
-
-	onOfferReceived := func(offer webrtc.SessionDescription) error {
+	hndl := newPeerHandle(sh.Id)
+	hndl.audioTrack = audioTrack
 
-		// Set the remote SessionDescription
-		err = peerConnection.SetRemoteDescription(offer)
+	// Create a video track
+	if wh.cfg.Load().VideoSrc.Passthrough {
+		hndl.videoRTPTrack, err = webrtc.NewTrackLocalStaticRTP(webrtc.RTPCodecCapability{MimeType: wh.cfg.Load().VideoSrc.Codec.Mime()}, "video", "pion2")
 		if err != nil {
 			return err
 		}
-
-		// Create an answer
-		answer, err := peerConnection.CreateAnswer(nil)
+		_, err = peerConnection.AddTrack(hndl.videoRTPTrack)
 		if err != nil {
 			return err
 		}
-
-		// Create channel that is blocked until ICE Gathering is complete
-		//gatherComplete := webrtc.GatheringCompletePromise(peerConnection)
-
-		// Sets the LocalDescription, and starts our UDP listeners
-		err = peerConnection.SetLocalDescription(answer)
+	} else {
+		hndl.videoTrack, err = webrtc.NewTrackLocalStaticSample(webrtc.RTPCodecCapability{MimeType: wh.cfg.Load().VideoSrc.Codec.Mime()}, "video", "pion2")
+		if err != nil {
+			return err
+		}
+		_, err = peerConnection.AddTrack(hndl.videoTrack)
 		if err != nil {
 			return err
 		}
+	}
 
-		//<-gatherComplete
+	peerConnection.OnConnectionStateChange(func(state webrtc.PeerConnectionState) {
+		switch state {
+		case webrtc.PeerConnectionStateConnected:
+			// packets can only be delivered once the transport is up
+			if hndl.videoRTPTrack != nil {
+				wh.goLive(hndl)
+			}
+		case webrtc.PeerConnectionStateFailed, webrtc.PeerConnectionStateClosed:
+			peerConnection.Close()
+			hcancel()
 
-		// Send the answer
-		sh.Trcv <- server.NewAnswerMessage(peerConnection.LocalDescription())
+			wh.removePeerHandle(sh.Id)
+		}
+	})
 
-		return nil
-	}
+	// start the writers and add handle to list
+	hndl.run(hctx, wh.lg)
+	wh.peers.Add(hndl)
+	wh.bus.Publish(events.NewPeer(sh.Id, true))
 
-	hndl := PeerHandle{audioTrack, videoTrack}
+	go wh.handleSignaling(hctx, sh, peerConnection, func() {
+		peerConnection.Close()
+		hcancel()
 
-	// add handle to list
-	wh.peerHandles[sh.Id] = &hndl
+		wh.removePeerHandle(sh.Id)
+	})
 
-	go func() {
-		for {
-			select {
-			case m, ok := <-sh.Recv:
-				// return when channel is closed
-				if !ok {
-					wh.mu.Lock()
-					defer wh.mu.Unlock()
-
-					// remove this handle
-					delete(wh.peerHandles, sh.Id)
-
-					// when there is no left over pause the pipelines
-					if len(wh.peerHandles) == 0 {
-						wh.stopPipelines()
-					}
+	return nil
+}
 
-					return
-				}
+// removePeerHandle drops the peer once, no matter which callback notices first
+func (wh *WebrtcHandler) removePeerHandle(id string) {
+	removed, left := wh.peers.Remove(id)
+	if !removed {
+		return
+	}
 
-				switch true {
-				case m.IsIceCandidateMessage():
-					pm, err := m.ToIceCandidateMessage()
-					if err != nil {
-						wh.lg.Error("failed to parse candidate", zap.Error(err))
-						continue
-					}
+	wh.bus.Publish(events.NewPeer(id, false))
 
-					wh.lg.Info("received new ice candidate", zap.Any("candidate", pm.Candidate))
+	if wh.relay != nil {
+		wh.relay.Revoke(id)
+	}
 
-					err = peerConnection.AddICECandidate(pm.Candidate)
-					if err != nil {
-						wh.lg.Error("failed to add ice candidate", zap.Error(err))
-					}
-				case m.IsAnswerMessage():
-					wh.lg.Info("received answer", zap.Any("data", m.Data))
-				case m.IsOfferMessage():
-					pm, err := m.ToOfferMessage()
-					if err != nil {
-						wh.lg.Error("failed to parse offer", zap.Error(err))
-						continue
-					}
+	if left > 0 {
+		return
+	}
+
+	wh.mu.Lock()
+	defer wh.mu.Unlock()
 
-					wh.lg.Info("received offer", zap.Any("data", pm.Offer))
+	// when there is no left over pause the pipelines, a peer may have joined meanwhile
+	if !wh.needsPipelines() {
+		wh.stopPipelines()
+	}
+}
 
-					err = onOfferReceived(pm.Offer)
-					if err != nil {
-						wh.lg.Error("failed to handle offer", zap.Error(err))
-					}
-				}
-			case <-hctx.Done():
-				return
-			}
+func (wh *WebrtcHandler) handleMute(ch <-chan events.Event) {
+	for e := range ch {
+		if m, ok := e.(events.Mute); ok {
+			wh.muted.Store(m.Muted)
+			wh.lg.Info("speaker", zap.Bool("muted", m.Muted))
 		}
-	}()
-
-	return nil
+	}
 }
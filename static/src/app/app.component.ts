@@ -2,7 +2,8 @@ import { Component, ComponentRef, HostListener, OnInit, ViewChild, ViewContainer
 import { VideoComponent } from './components/video/video.component';
 import { AudioComponent } from './components/audio/audio.component';
 import { SignalingService } from './services/signaling.service';
-import { IsAnswer, IsIceCandidate, IsOffer } from './model';
+import { PushService } from './services/push.service';
+import { IsAnswer, IsConfig, IsIceCandidate, IsIceServers, IsOffer } from './model';
 
 @Component({
   selector: 'app-root',
@@ -26,6 +27,8 @@ export class AppComponent implements OnInit {
       await this.startAudio()
     }
 
+    void this.push.Subscribe();
+
     for (const el of this.audioList) {
       el.instance.toggleMute();
     }
@@ -42,7 +45,7 @@ export class AppComponent implements OnInit {
     this.selfAudioRunning = true;
   }
 
-  constructor(private signaling: SignalingService) {
+  constructor(private signaling: SignalingService, private push: PushService) {
     //this.pc = new RTCPeerConnection({iceServers: [{urls: 'stun:stun.l.google.com:19302'}]});
     this.pc = new RTCPeerConnection();
 
@@ -91,10 +94,6 @@ export class AppComponent implements OnInit {
       }
     };
 
-    // Offer to receive 1 audio, and 1 video track
-    this.pc.addTransceiver('audio', { direction: 'sendrecv' })
-    this.pc.addTransceiver('video', { direction: 'recvonly' })
-
     // Let the "negotiationneeded" event trigger offer generation.
     this.pc.onnegotiationneeded = (e) => {
       this.pc.createOffer()
@@ -113,6 +112,23 @@ export class AppComponent implements OnInit {
 
     this.signaling.subscribe((m) => {
       switch (true) {
+        case IsConfig(m):
+          console.log('CONFIG: received', m.data);
+
+          this.push.SetPath(m.data.push);
+
+          break;
+        case IsIceServers(m):
+          console.log('ICE: received servers', m.data);
+
+          // use the same servers as the service before negotiating
+          this.pc.setConfiguration({ iceServers: m.data });
+
+          // Offer to receive 1 audio, and 1 video track
+          this.pc.addTransceiver('audio', { direction: 'sendrecv' })
+          this.pc.addTransceiver('video', { direction: 'recvonly' })
+
+          break;
         case IsIceCandidate(m):
           console.log('ICE: received new candidate', m.data);
           break;
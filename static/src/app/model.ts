@@ -1,6 +1,6 @@
 
 export interface SignalingMessage {
-  type: 'new-ice-candidate' | 'offer' | 'answer';
+  type: 'new-ice-candidate' | 'offer' | 'answer' | 'ice-servers' | 'config' | 'error';
   data: any;
 }
 
@@ -16,6 +16,16 @@ export interface IceCandidateMessage extends SignalingMessage {
   data: RTCIceCandidate;
 }
 
+export interface IceServersMessage extends SignalingMessage {
+  data: RTCIceServer[];
+}
+
+export interface ConfigMessage extends SignalingMessage {
+  data: {
+    push?: string; // base path of the push endpoints, missing when disabled
+  };
+}
+
 export const IsSignalingMessage = (d: any): d is SignalingMessage => {
   return !!d && typeof(d.type) === 'string';
 }
@@ -28,6 +38,14 @@ export const IsAnswer = (d: any): d is AnswerMessage => {
   return IsSignalingMessage(d) && d.type === 'answer';
 }
 
+export const IsIceServers = (d: any): d is IceServersMessage => {
+  return IsSignalingMessage(d) && d.type === 'ice-servers';
+}
+
 export const IsOffer = (d: any): d is OfferMessage => {
   return IsSignalingMessage(d) && d.type === 'offer';
 }
+
+export const IsConfig = (d: any): d is ConfigMessage => {
+  return IsSignalingMessage(d) && d.type === 'config';
+}
@@ -0,0 +1,42 @@
+import { Injectable } from '@angular/core';
+import { SwPush } from '@angular/service-worker';
+
+@Injectable({
+  providedIn: 'root'
+})
+export class PushService {
+  private requested = false;
+  private path?: string; // sent by the server, unset while push is disabled
+
+  constructor(private swPush: SwPush) { }
+
+  public SetPath(path?: string) {
+    this.path = path;
+  }
+
+  // Subscribe asks for the permission once, browsers only allow that on a user gesture
+  public async Subscribe(): Promise<void> {
+    if (this.requested || !this.path || !this.swPush.isEnabled) {
+      return;
+    }
+    this.requested = true;
+
+    try {
+      const res = await fetch(`${this.path}/key`);
+      if (!res.ok) {
+        throw new Error(`unexpected status ${res.status}`);
+      }
+      const { publicKey } = await res.json();
+
+      const sub = await this.swPush.requestSubscription({ serverPublicKey: publicKey });
+
+      await fetch(`${this.path}/subscriptions`, {
+        method: 'POST',
+        headers: { 'Content-Type': 'application/json' },
+        body: JSON.stringify(sub),
+      });
+    } catch (e) {
+      console.error('PUSH: failed to subscribe', e);
+    }
+  }
+}